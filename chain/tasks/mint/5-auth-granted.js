@@ -4,11 +4,15 @@ task("5-auth-granted", "Triggers an authGranted for PGBP")
   .addParam("contract", "The PGBP contract address")
   .addParam("requestId", "The current mint requestId")
   .addParam("consentCode", "The consent code as returned by the OpenBanking consent approval redirection")
+  .addParam("state", "The state as returned by the OpenBanking consent approval redirection")
+  .addParam("idToken", "The ID token (id_token) as returned by the OpenBanking consent approval redirection")
   .setAction(async (taskArgs) => {
 
     const contractAddr = taskArgs.contract
     const networkId = network.name
     const consentCode = taskArgs.consentCode
+    const state = taskArgs.state
+    const idToken = taskArgs.idToken
     const requestId = taskArgs.requestId
     const privateKey = process.env.PRIVATE_KEY
 
@@ -28,9 +32,12 @@ task("5-auth-granted", "Triggers an authGranted for PGBP")
     // Get our own public encryption key
     const myPubKey = getEncryptionKey(privateKey)
 
+    // the state and ID token let the TPP check that the code comes from its own authorisation request
     const payload = {
         consentCode: consentCode,
-        publicKey: myPubKey
+        publicKey: myPubKey,
+        state: state,
+        idToken: idToken
     }
     const encryptedData = await encryptEth(serverPubKey, payload)
 
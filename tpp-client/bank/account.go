@@ -0,0 +1,110 @@
+package bank
+
+import (
+	"errors"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// Account identification schemes
+const (
+	SCHEME_SORT_CODE_ACCOUNT_NUMBER = "SortCodeAccountNumber"
+	SCHEME_IBAN                     = "UK.OBIE.IBAN"
+	// namespaced form of SCHEME_SORT_CODE_ACCOUNT_NUMBER, accepted as an alias
+	SCHEME_OBIE_SORT_CODE_ACCOUNT_NUMBER = "UK.OBIE.SortCodeAccountNumber"
+)
+
+// MAX_SECONDARY_IDENTIFICATION is the maximum length of a secondary identification, e.g. a building society roll number
+const MAX_SECONDARY_IDENTIFICATION = 34
+
+var (
+	sortCodePattern      = regexp.MustCompile(`^\d{6}$`)
+	accountNumberPattern = regexp.MustCompile(`^\d{8}$`)
+	ibanPattern          = regexp.MustCompile(`^[A-Z]{2}\d{2}[A-Z0-9]{11,30}$`)
+	// IBAN lengths of the countries we expect to see. Others are only checked for their mod-97 checksum.
+	ibanLengths = map[string]int{
+		"GB": 22, "IE": 22, "DE": 22, "FR": 27, "ES": 24, "IT": 27, "NL": 18, "BE": 16,
+		"PT": 25, "AT": 20, "CH": 21, "LU": 20, "GI": 23, "JE": 22, "GG": 22, "IM": 22,
+	}
+)
+
+// Scheme returns the account's identification scheme. Accounts without one are identified by IBAN, if set,
+// otherwise by sort code and account number.
+func (a *AccountDetails) Scheme() string {
+	switch {
+	case a.SchemeName == SCHEME_OBIE_SORT_CODE_ACCOUNT_NUMBER:
+		return SCHEME_SORT_CODE_ACCOUNT_NUMBER
+	case a.SchemeName != "":
+		return a.SchemeName
+	case a.Iban != "":
+		return SCHEME_IBAN
+	default:
+		return SCHEME_SORT_CODE_ACCOUNT_NUMBER
+	}
+}
+
+// Identification returns the account's identification in its scheme
+func (a *AccountDetails) Identification() string {
+	if a.Scheme() == SCHEME_IBAN {
+		return NormaliseIban(a.Iban)
+	}
+	return a.SortCode + a.AccountNumber
+}
+
+// Validate checks the account's identification, including its checksum.
+// Sort codes are only modulus checked if a table is given.
+func (a *AccountDetails) Validate(modulus *ModulusTable) error {
+	switch a.Scheme() {
+	case SCHEME_SORT_CODE_ACCOUNT_NUMBER:
+		if !sortCodePattern.MatchString(a.SortCode) {
+			return errors.New("Invalid sort code: expected 6 digits")
+		}
+		if !accountNumberPattern.MatchString(a.AccountNumber) {
+			return errors.New("Invalid account number: expected 8 digits")
+		}
+		if modulus != nil && !modulus.Check(a.SortCode, a.AccountNumber) {
+			return errors.New("Invalid account number: failed the modulus check for sort code " + a.SortCode)
+		}
+	case SCHEME_IBAN:
+		if err := ValidateIban(a.Iban); err != nil {
+			return err
+		}
+	default:
+		return errors.New("Unsupported account scheme: " + a.SchemeName)
+	}
+	if len(a.SecondaryIdentification) > MAX_SECONDARY_IDENTIFICATION {
+		return errors.New("Invalid secondary identification: longer than 34 characters")
+	}
+	return nil
+}
+
+// NormaliseIban removes the spaces of the IBAN's print format and upper-cases it
+func NormaliseIban(iban string) string {
+	return strings.ToUpper(strings.ReplaceAll(iban, " ", ""))
+}
+
+// ValidateIban checks the IBAN's format, its country's length and its mod-97 checksum
+func ValidateIban(iban string) error {
+	iban = NormaliseIban(iban)
+	if !ibanPattern.MatchString(iban) {
+		return errors.New("Invalid IBAN: unexpected format")
+	}
+	if l, ok := ibanLengths[iban[:2]]; ok && len(iban) != l {
+		return errors.New("Invalid IBAN: wrong length for " + iban[:2])
+	}
+	// move the country and check digits to the end, and replace the letters with numbers (A = 10, ..., Z = 35)
+	var digits strings.Builder
+	for _, r := range iban[4:] + iban[:4] {
+		if r >= 'A' && r <= 'Z' {
+			digits.WriteString(big.NewInt(int64(r - 'A' + 10)).String())
+		} else {
+			digits.WriteRune(r)
+		}
+	}
+	n, _ := new(big.Int).SetString(digits.String(), 10)
+	if new(big.Int).Mod(n, big.NewInt(97)).Int64() != 1 {
+		return errors.New("Invalid IBAN: checksum mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package bank
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// AccountInformationClient reads the TPP's own accounts (AISP), e.g. to confirm that a payment has been credited.
+// The account owner must have authorised the client's account access beforehand.
+type AccountInformationClient interface {
+
+	// GetAccountCredits returns the booked credits of the account since the given time
+	GetAccountCredits(account *AccountDetails, since time.Time) ([]AccountTransaction, error)
+}
+
+type AccountTransaction struct {
+	TransactionId string
+	Amount        string
+	Currency      string
+	// Reference is the payment's end-to-end identification, as passed on by the payment scheme
+	Reference string
+	BookedAt  time.Time
+}
+
+// MatchingCredits returns the credits of the given amount and reference
+func MatchingCredits(credits []AccountTransaction, amount string, reference string) []AccountTransaction {
+
+	expected, err := decimal.NewFromString(amount)
+	if err != nil {
+		return nil
+	}
+	var matches []AccountTransaction
+	for _, c := range credits {
+		if c.Reference != reference || (c.Currency != "" && c.Currency != "GBP") {
+			continue
+		}
+		if a, err := decimal.NewFromString(c.Amount); err == nil && a.Equal(expected) {
+			matches = append(matches, c)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,29 @@
+package bank_test
+
+import (
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMatchingCredits(t *testing.T) {
+
+	// arrange
+	credits := []bank.AccountTransaction{
+		{TransactionId: "t1", Amount: "10.50", Currency: "GBP", Reference: "e2e-1"},
+		{TransactionId: "t2", Amount: "10.5", Currency: "GBP", Reference: "e2e-2"},
+		{TransactionId: "t3", Amount: "10.5", Currency: "EUR", Reference: "e2e-2"},
+		{TransactionId: "t4", Amount: "11", Currency: "GBP", Reference: "e2e-2"},
+		{TransactionId: "t5", Amount: "10.500", Currency: "GBP", Reference: "e2e-2"},
+	}
+
+	// act
+	matches := bank.MatchingCredits(credits, "10.5", "e2e-2")
+
+	// assert
+	if assert.Len(t, matches, 2) {
+		assert.Equal(t, "t2", matches[0].TransactionId)
+		assert.Equal(t, "t5", matches[1].TransactionId)
+	}
+	assert.Empty(t, bank.MatchingCredits(credits, "not-a-number", "e2e-1"))
+}
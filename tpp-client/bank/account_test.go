@@ -0,0 +1,98 @@
+package bank_test
+
+import (
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestValidateIban(t *testing.T) {
+	cases := []struct {
+		iban string
+		err  string
+	}{
+		{iban: "GB29NWBK60161331926819"},
+		{iban: "gb29 nwbk 6016 1331 9268 19"},
+		{iban: "DE89370400440532013000"},
+		{iban: "GB28NWBK60161331926819", err: "checksum"},
+		{iban: "GB29NWBK6016133192681", err: "length"},
+		{iban: "GB29-NWBK-6016", err: "format"},
+		{iban: "", err: "format"},
+	}
+	for _, c := range cases {
+		t.Run(c.iban, func(t *testing.T) {
+			// act
+			err := bank.ValidateIban(c.iban)
+
+			// assert
+			if c.err == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), c.err)
+			}
+		})
+	}
+}
+
+func TestAccountDetails_Scheme(t *testing.T) {
+	cases := []struct {
+		name           string
+		account        bank.AccountDetails
+		scheme         string
+		identification string
+	}{
+		{name: "sort code", account: bank.AccountDetails{SortCode: "500000", AccountNumber: "12345601"},
+			scheme: bank.SCHEME_SORT_CODE_ACCOUNT_NUMBER, identification: "50000012345601"},
+		{name: "iban", account: bank.AccountDetails{Iban: "gb29 nwbk 6016 1331 9268 19"},
+			scheme: bank.SCHEME_IBAN, identification: "GB29NWBK60161331926819"},
+		{name: "namespaced sort code", account: bank.AccountDetails{SchemeName: bank.SCHEME_OBIE_SORT_CODE_ACCOUNT_NUMBER, SortCode: "500000", AccountNumber: "12345601", Iban: "GB29NWBK60161331926819"},
+			scheme: bank.SCHEME_SORT_CODE_ACCOUNT_NUMBER, identification: "50000012345601"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act & assert
+			assert.Equal(t, c.scheme, c.account.Scheme())
+			assert.Equal(t, c.identification, c.account.Identification())
+		})
+	}
+}
+
+func TestAccountDetails_Validate(t *testing.T) {
+
+	// arrange
+	modulus, err := bank.ParseModulusTable(strings.NewReader(TEST_MODULUS_TABLE))
+	require.NoError(t, err)
+
+	cases := []struct {
+		name    string
+		account bank.AccountDetails
+		err     string
+	}{
+		{name: "unchecked sort code", account: bank.AccountDetails{SortCode: "500000", AccountNumber: "12345601"}},
+		{name: "valid account number", account: bank.AccountDetails{SortCode: "089999", AccountNumber: "66374958"}},
+		{name: "invalid account number", account: bank.AccountDetails{SortCode: "089999", AccountNumber: "66374959"}, err: "modulus"},
+		{name: "short sort code", account: bank.AccountDetails{SortCode: "50000", AccountNumber: "12345601"}, err: "sort code"},
+		{name: "short account number", account: bank.AccountDetails{SortCode: "500000", AccountNumber: "1234560"}, err: "account number"},
+		{name: "iban", account: bank.AccountDetails{Iban: "GB29NWBK60161331926819", SecondaryIdentification: "ROLL-1234"}},
+		{name: "invalid iban", account: bank.AccountDetails{Iban: "GB28NWBK60161331926819"}, err: "IBAN"},
+		{name: "long roll number", account: bank.AccountDetails{SortCode: "500000", AccountNumber: "12345601", SecondaryIdentification: strings.Repeat("1", 35)}, err: "secondary"},
+		{name: "unsupported scheme", account: bank.AccountDetails{SchemeName: "UK.OBIE.PAN"}, err: "Unsupported"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			err := c.account.Validate(modulus)
+
+			// assert
+			if c.err == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), c.err)
+			}
+		})
+	}
+}
@@ -1,13 +1,17 @@
 package bank
 
-import "crypto/tls"
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+)
 
 // OpenBankingClient is an interface to be implemented by all OB client implementations.
 type OpenBankingClient interface {
 
 	// GetPaymentAuthAccessToken returns an access token for the given request ID.
 	// The implementation may use any TPP identification method (MA-TLS, username/password,...).
-	// It may also choose to cache the access token for a given request ID.
+	// It may also choose to cache the access token, e.g. per client and scope, and return it for multiple request IDs.
 	GetPaymentAuthAccessToken(requestId string) (*AccessToken, error)
 
 	// CreatePaymentAuthRequest returns a payment authorisation request, i.e. a pending consent.
@@ -20,8 +24,29 @@ type OpenBankingClient interface {
 	// The payment ID is used to check the payment status, as payments may take some time to settle.
 	SubmitPayment(data *PaymentAuthGranted, paymentAuthRequest *PaymentAuthRequest, beneficiary *AccountDetails) (*SubmitPaymentResponse, error)
 
-	// GetPaymentStatus returns the status of a payment (settled, pending, failed).
+	// ConfirmFunds asks the bank whether the payer's account can cover the payment of a granted consent.
+	// It is optional; if called, it must precede `SubmitPayment` for the same consent.
+	ConfirmFunds(data *PaymentAuthGranted) (*FundsConfirmationResponse, error)
+
+	// GetPaymentStatus returns the status of a payment. See `PaymentStatus` for the ones which are final.
 	GetPaymentStatus(data *SubmitPaymentResponse) (*PaymentStatusResponse, error)
+
+	// ForgetPayment releases what the client keeps for a request's payment, e.g. its tokens,
+	// once its status needs no more checking or it will not be submitted.
+	ForgetPayment(requestId string)
+
+	// GetPaymentConsentStatus returns the status of a consent created by `CreatePaymentAuthRequest`.
+	// It allows tracking consents which the payer rejected or let expire at the bank.
+	GetPaymentConsentStatus(consent *PaymentAuthResponse, access *AccessToken) (*PaymentConsentStatusResponse, error)
+}
+
+// ConsentApprover approves consents on the payer's behalf, without their interaction with the bank.
+// Only sandboxes allow it.
+type ConsentApprover interface {
+
+	// ApproveConsent approves a consent created by `CreatePaymentAuthRequest` as the given bank user.
+	// The result is what the payer's authorisation would have returned.
+	ApproveConsent(consent *PaymentAuthResponse, username string) (*PaymentAuthGranted, error)
 }
 
 // PaymentAuthRequest contains the details for the payer
@@ -34,9 +59,14 @@ type PaymentAuthRequest struct {
 
 // AccountDetails are the account details of a Payer or Beneficiary
 type AccountDetails struct {
+	// SchemeName of the identification. Inferred from the set fields if empty, see `Scheme`.
+	SchemeName    string
 	SortCode      string
 	AccountNumber string
-	Name          string
+	Iban          string
+	// SecondaryIdentification, e.g. a building society roll number. Optional.
+	SecondaryIdentification string
+	Name                    string
 }
 
 // AccessToken wraps on Oauth2 token
@@ -52,18 +82,64 @@ type OauthClientCreds struct {
 	TransportCert  tls.Certificate
 	SigningCert    tls.Certificate
 	RedirectionUrl string
+	// SigningKeyId is the `kid` of the signing key, as registered in the OB directory
+	SigningKeyId string
+	// JwsIssuer is the `iss` of the request signatures, i.e. `{org-id}/{software-statement-id}`
+	JwsIssuer string
+	// RootCAs trusted when connecting to the ASPSP. The system pool is used if nil.
+	RootCAs *x509.CertPool
+	// TokenAuthMethod is how the client authenticates to the token endpoint. Defaults to `client_secret_post`.
+	TokenAuthMethod string
+	// ReducedSecurity allows requests without a SigningCert, i.e. unsigned. Sandboxes only.
+	ReducedSecurity bool
 }
 
+// Token endpoint authentication methods
+const (
+	CLIENT_SECRET_POST = "client_secret_post"
+	TLS_CLIENT_AUTH    = "tls_client_auth"
+	PRIVATE_KEY_JWT    = "private_key_jwt"
+)
+
 type PaymentAuthResponse struct {
 	RequestId string
 	Url       string
 	ConsentId string
+	// State and Nonce of the authorisation request, to be checked against the authorisation response
+	State string
+	Nonce string
+	// Identifiers of the consented payment
+	Identifiers PaymentIdentifiers
+	// InteractionId of the consent's creation, as echoed by the ASPSP
+	InteractionId string
+}
+
+// PaymentIdentifiers are the references of a request's payment, as they appear on bank statements.
+// They are kept to reconcile the statements with the on-chain requests.
+type PaymentIdentifiers struct {
+	InstructionId string
+	// EndToEndId is passed on to the beneficiary's bank, which shows it as the credit's reference
+	EndToEndId string
+	// Reference of the remittance information, shown on the payer's statement
+	Reference string
 }
 
 type PaymentAuthGranted struct {
 	ConsentId   string
 	RequestId   string
 	ConsentCode string
+	// State and IdToken as returned in the authorisation response
+	State   string
+	IdToken string
+	// AuthDate and CustomerIpAddress of the PSU's authorisation, if known. Sent to the ASPSP as FAPI headers.
+	AuthDate          time.Time
+	CustomerIpAddress string
+}
+
+type FundsConfirmationResponse struct {
+	RequestId      string
+	ConsentId      string
+	FundsAvailable bool
 }
 
 type SubmitPaymentResponse struct {
@@ -71,11 +147,30 @@ type SubmitPaymentResponse struct {
 	ConsentCode  string
 	ConsentToken string
 	PaymentId    string
+	// Identifiers of the submitted payment
+	Identifiers PaymentIdentifiers
+	// InteractionId of the payment's submission, as echoed by the ASPSP
+	InteractionId string
 }
 
 type PaymentStatusResponse struct {
 	RequestId string
 	PaymentId string
+	Status    PaymentStatus
+}
+
+// OB domestic payment consent statuses.
+// `Expired` is not part of the v3.1 standard, but is returned by some ASPSPs for consents never authorised.
+const (
+	CONSENT_AWAITING_AUTHORISATION = "AwaitingAuthorisation"
+	CONSENT_AUTHORISED             = "Authorised"
+	CONSENT_REJECTED               = "Rejected"
+	CONSENT_CONSUMED               = "Consumed"
+	CONSENT_EXPIRED                = "Expired"
+)
+
+type PaymentConsentStatusResponse struct {
+	RequestId string
+	ConsentId string
 	Status    string
-	Settled   bool
 }
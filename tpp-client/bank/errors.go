@@ -0,0 +1,213 @@
+package bank
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorClass groups bank errors by what the caller can do about them
+type ErrorClass string
+
+const (
+	// RETRYABLE the same call may succeed later, e.g. ASPSP unavailable or network failure
+	RETRYABLE ErrorClass = "Retryable"
+	// TERMINAL the call will never succeed as is, e.g. invalid payload or rejected consent
+	TERMINAL ErrorClass = "Terminal"
+	// AUTH_EXPIRED the access token is no longer valid. The call may succeed with a fresh one.
+	AUTH_EXPIRED ErrorClass = "AuthExpired"
+	// RATE_LIMITED the ASPSP is throttling us. The call may succeed after `RetryAfter`.
+	RATE_LIMITED ErrorClass = "RateLimited"
+	// UNAVAILABLE the ASPSP is considered down after repeated failures. Calls fail fast until it recovers.
+	UNAVAILABLE ErrorClass = "Unavailable"
+)
+
+// ObErrorResponse is the OB error response body (OBErrorResponse1).
+// It also captures the `error` fields of an Oauth2 error response.
+type ObErrorResponse struct {
+	Code             string    `json:"Code"`
+	Id               string    `json:"Id"`
+	Message          string    `json:"Message"`
+	Errors           []ObError `json:"Errors"`
+	OauthError       string    `json:"error"`
+	OauthDescription string    `json:"error_description"`
+}
+
+// ObError is a single error entry of an OB error response (OBError1)
+type ObError struct {
+	ErrorCode string `json:"ErrorCode"`
+	Message   string `json:"Message"`
+	Path      string `json:"Path"`
+	Url       string `json:"Url"`
+}
+
+// BankError is a failed call to the ASPSP
+type BankError struct {
+	Operation string
+	// Status is the HTTP status code, 0 if the call did not complete
+	Status int
+	Class  ErrorClass
+	// Body is the parsed error response, nil if the ASPSP did not return one
+	Body *ObErrorResponse
+	// RetryAfter is the wait requested by the ASPSP, if any
+	RetryAfter time.Duration
+	// Cause is the underlying error for calls that did not complete
+	Cause error
+}
+
+func (e *BankError) Error() string {
+	var b strings.Builder
+	b.WriteString("Failed " + e.Operation)
+	if e.Cause != nil {
+		b.WriteString(": " + e.Cause.Error())
+	} else {
+		b.WriteString(fmt.Sprintf(". Status %d", e.Status))
+	}
+	if e.Body != nil {
+		if e.Body.Code != "" {
+			b.WriteString(". Code " + e.Body.Code)
+		}
+		if e.Body.OauthError != "" {
+			b.WriteString(". Error " + e.Body.OauthError)
+		}
+		for _, ob := range e.Body.Errors {
+			b.WriteString(fmt.Sprintf(". %s", ob.ErrorCode))
+			if ob.Path != "" {
+				b.WriteString(" at " + ob.Path)
+			}
+			if ob.Message != "" {
+				b.WriteString(": " + ob.Message)
+			}
+		}
+	}
+	b.WriteString(fmt.Sprintf(" (%s)", e.Class))
+	return b.String()
+}
+
+func (e *BankError) Unwrap() error {
+	return e.Cause
+}
+
+// NewBankError creates an error from an unsuccessful ASPSP response, parsing the OB error body if present
+func NewBankError(operation string, status int, body []byte, header http.Header) *BankError {
+
+	var obErr *ObErrorResponse
+	var tmp ObErrorResponse
+	if len(body) > 0 && json.Unmarshal(body, &tmp) == nil {
+		obErr = &tmp
+	}
+
+	e := &BankError{
+		Operation: operation,
+		Status:    status,
+		Body:      obErr,
+		Class:     ClassifyError(status, obErr),
+	}
+	if header != nil {
+		e.RetryAfter = parseRetryAfter(header.Get("Retry-After"))
+	}
+	return e
+}
+
+// NewTransportError creates an error for a call which did not receive a response, e.g. timeout or connection refused
+func NewTransportError(operation string, cause error) *BankError {
+	return &BankError{
+		Operation: operation,
+		Class:     RETRYABLE,
+		Cause:     cause,
+	}
+}
+
+// NewUnavailableError creates an error for a call which was not made, as the ASPSP is considered down
+func NewUnavailableError(operation string, institution string) *BankError {
+	return &BankError{
+		Operation: operation,
+		Class:     UNAVAILABLE,
+		Cause:     errors.New("Bank " + institution + " unavailable"),
+	}
+}
+
+// ClassifyError maps an HTTP status and OB error body to an error class
+func ClassifyError(status int, obErr *ObErrorResponse) ErrorClass {
+
+	switch {
+	case status == http.StatusTooManyRequests:
+		return RATE_LIMITED
+	case status == http.StatusUnauthorized:
+		return AUTH_EXPIRED
+	case status == http.StatusRequestTimeout || status >= 500:
+		return RETRYABLE
+	}
+
+	if obErr != nil {
+		// an unexpected error may go away; every other OB error code is about the request itself
+		for _, e := range obErr.Errors {
+			if e.ErrorCode == "UK.OBIE.UnexpectedError" {
+				return RETRYABLE
+			}
+		}
+		if obErr.OauthError == "invalid_token" {
+			return AUTH_EXPIRED
+		}
+	}
+	return TERMINAL
+}
+
+// ClassOf returns the class of a bank error, or false if the error did not come from the bank client
+func ClassOf(err error) (ErrorClass, bool) {
+	var be *BankError
+	if errors.As(err, &be) {
+		return be.Class, true
+	}
+	return "", false
+}
+
+// IsRetryable returns true if the call may succeed if repeated as is, possibly after waiting
+func IsRetryable(err error) bool {
+	c, ok := ClassOf(err)
+	return ok && (c == RETRYABLE || c == RATE_LIMITED)
+}
+
+// IsTerminal returns true if the call will never succeed
+func IsTerminal(err error) bool {
+	c, ok := ClassOf(err)
+	return ok && c == TERMINAL
+}
+
+// IsAuthExpired returns true if the call may succeed with a fresh access token
+func IsAuthExpired(err error) bool {
+	c, ok := ClassOf(err)
+	return ok && c == AUTH_EXPIRED
+}
+
+// IsRateLimited returns true if the ASPSP is throttling us
+func IsRateLimited(err error) bool {
+	c, ok := ClassOf(err)
+	return ok && c == RATE_LIMITED
+}
+
+// IsUnavailable returns true if the call was not made, as the ASPSP is considered down
+func IsUnavailable(err error) bool {
+	c, ok := ClassOf(err)
+	return ok && c == UNAVAILABLE
+}
+
+// parseRetryAfter parses a Retry-After header, either in seconds or as an HTTP date
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,81 @@
+package bank_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewBankError_Classification(t *testing.T) {
+	cases := []struct {
+		status int
+		body   string
+		exp    bank.ErrorClass
+	}{
+		{status: 500, body: "", exp: bank.RETRYABLE},
+		{status: 503, body: "<html>down</html>", exp: bank.RETRYABLE},
+		{status: 408, body: "", exp: bank.RETRYABLE},
+		{status: 429, body: "", exp: bank.RATE_LIMITED},
+		{status: 401, body: "", exp: bank.AUTH_EXPIRED},
+		{status: 400, body: `{"error":"invalid_token"}`, exp: bank.AUTH_EXPIRED},
+		{status: 400, body: `{"error":"invalid_grant"}`, exp: bank.TERMINAL},
+		{status: 404, body: "", exp: bank.TERMINAL},
+		{status: 400, body: `{"Code":"400","Errors":[{"ErrorCode":"UK.OBIE.Field.Invalid","Path":"Data.Initiation"}]}`, exp: bank.TERMINAL},
+		{status: 400, body: `{"Code":"400","Errors":[{"ErrorCode":"UK.OBIE.UnexpectedError"}]}`, exp: bank.RETRYABLE},
+	}
+	for _, c := range cases {
+		err := bank.NewBankError("op", c.status, []byte(c.body), nil)
+		assert.Equal(t, c.exp, err.Class, "%d %s", c.status, c.body)
+	}
+}
+
+func TestNewBankError_ParsesObBody(t *testing.T) {
+
+	body := `{
+		"Code": "400 BadRequest",
+		"Id": "abc",
+		"Message": "Bad request",
+		"Errors": [{"ErrorCode": "UK.OBIE.Field.Missing", "Message": "Missing field", "Path": "Data.Initiation.CreditorAccount"}]
+	}`
+
+	err := bank.NewBankError("submit payment", 400, []byte(body), nil)
+
+	require.NotNil(t, err.Body)
+	assert.Equal(t, "400 BadRequest", err.Body.Code)
+	require.Len(t, err.Body.Errors, 1)
+	assert.Equal(t, "UK.OBIE.Field.Missing", err.Body.Errors[0].ErrorCode)
+	assert.Equal(t, "Data.Initiation.CreditorAccount", err.Body.Errors[0].Path)
+	assert.Contains(t, err.Error(), "Failed submit payment. Status 400")
+	assert.Contains(t, err.Error(), "UK.OBIE.Field.Missing at Data.Initiation.CreditorAccount")
+}
+
+func TestNewBankError_RetryAfter(t *testing.T) {
+	h := http.Header{}
+	h.Set("Retry-After", "7")
+
+	err := bank.NewBankError("op", 429, nil, h)
+
+	assert.Equal(t, 7*time.Second, err.RetryAfter)
+}
+
+func TestClassOf_Wrapped(t *testing.T) {
+	err := fmt.Errorf("handler: %w", bank.NewTransportError("op", errors.New("connection refused")))
+
+	assert.True(t, bank.IsRetryable(err))
+	assert.False(t, bank.IsTerminal(err))
+	_, ok := bank.ClassOf(errors.New("not a bank error"))
+	assert.False(t, ok)
+}
+
+func TestNewUnavailableError(t *testing.T) {
+	err := bank.NewUnavailableError("op", "natwest")
+
+	assert.True(t, bank.IsUnavailable(err))
+	assert.False(t, bank.IsRetryable(err))
+	assert.Equal(t, "Failed op: Bank natwest unavailable (Unavailable)", err.Error())
+}
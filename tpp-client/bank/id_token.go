@@ -0,0 +1,110 @@
+package bank
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// ID_TOKEN_LEEWAY is the clock skew tolerated when checking an ID token's expiry
+const ID_TOKEN_LEEWAY = 1 * time.Minute
+
+// IdTokenValidator checks the ID tokens an ASPSP returns along with the consent codes
+type IdTokenValidator struct {
+	// Issuer of the ID tokens, i.e. the ASPSP's authorisation server
+	Issuer string
+	// Audience of the ID tokens, i.e. the TPP's client ID
+	Audience string
+	Resolve  JwsKeyResolver
+	Leeway   time.Duration
+}
+
+// IdTokenExpectations are the values of the authorisation an ID token must be bound to
+type IdTokenExpectations struct {
+	Nonce string
+	// Code and State as received along with the ID token (hybrid flow)
+	Code  string
+	State string
+}
+
+func NewIdTokenValidator(issuer string, clientId string, resolve JwsKeyResolver) *IdTokenValidator {
+	return &IdTokenValidator{
+		Issuer:   issuer,
+		Audience: clientId,
+		Resolve:  resolve,
+		Leeway:   ID_TOKEN_LEEWAY,
+	}
+}
+
+// Validate verifies the signature of an ID token, its issuer, audience and expiry and its binding to the
+// authorisation, i.e. the nonce and the hashes of the code (c_hash) and state (s_hash). It returns the token's claims.
+func (v *IdTokenValidator) Validate(idToken string, expected *IdTokenExpectations) (map[string]interface{}, error) {
+
+	claims, err := VerifyJwt(idToken, v.Resolve)
+	if err != nil {
+		return nil, errors.New("Invalid ID token: " + err.Error())
+	}
+
+	if err = checkClaim(claims, "iss", v.Issuer); err != nil {
+		return nil, err
+	}
+	if !hasAudience(claims["aud"], v.Audience) {
+		return nil, errors.New("ID token audience mismatch")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("ID token is missing exp")
+	}
+	if time.Now().Add(-v.Leeway).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("ID token expired")
+	}
+
+	if err = checkClaim(claims, "nonce", expected.Nonce); err != nil {
+		return nil, err
+	}
+	if expected.Code != "" {
+		if err = checkClaim(claims, "c_hash", HalfHash(expected.Code)); err != nil {
+			return nil, err
+		}
+	}
+	if expected.State != "" {
+		if err = checkClaim(claims, "s_hash", HalfHash(expected.State)); err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+// HalfHash returns the base64url left half of the SHA-256 hash of a value, as used in c_hash and s_hash for PS256
+func HalfHash(v string) string {
+	h := sha256.Sum256([]byte(v))
+	return base64.RawURLEncoding.EncodeToString(h[:len(h)/2])
+}
+
+func checkClaim(claims map[string]interface{}, name string, expected string) error {
+	v, _ := claims[name].(string)
+	if v == "" {
+		return errors.New("ID token is missing " + name)
+	}
+	if subtle.ConstantTimeCompare([]byte(v), []byte(expected)) != 1 {
+		return errors.New("ID token " + name + " mismatch")
+	}
+	return nil
+}
+
+// hasAudience checks the `aud` claim, which may be a string or an array of strings
+func hasAudience(aud interface{}, audience string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == audience
+	case []interface{}:
+		for _, v := range a {
+			if s, _ := v.(string); s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package bank_test
+
+import (
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	test_util "github.com/sgerogia/sol-stablecoin/tpp-client/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+const (
+	TEST_ISSUER    = "https://aspsp.test"
+	TEST_CLIENT_ID = "tpp-client"
+)
+
+func idTokenClaims(nonce, code, state string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":    TEST_ISSUER,
+		"aud":    TEST_CLIENT_ID,
+		"exp":    time.Now().Add(5 * time.Minute).Unix(),
+		"nonce":  nonce,
+		"c_hash": bank.HalfHash(code),
+		"s_hash": bank.HalfHash(state),
+	}
+}
+
+func TestIdTokenValidator_Validate(t *testing.T) {
+
+	// arrange
+	cert, err := test_util.NewSelfSignedCert("aspsp.test")
+	require.NoError(t, err)
+	signer, err := bank.NewJwsSigner(cert, "aspsp-kid", "aspsp")
+	require.NoError(t, err)
+	other, err := test_util.NewSelfSignedCert("other.test")
+	require.NoError(t, err)
+	otherSigner, err := bank.NewJwsSigner(other, "aspsp-kid", "aspsp")
+	require.NoError(t, err)
+
+	jwk := signer.Jwk()
+	key, err := jwk.PublicKey()
+	require.NoError(t, err)
+	validator := bank.NewIdTokenValidator(TEST_ISSUER, TEST_CLIENT_ID, bank.StaticKeyResolver(key))
+	expected := &bank.IdTokenExpectations{Nonce: "n-1", Code: "code-1", State: "s-1"}
+
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := idTokenClaims("n-1", "code-1", "s-1")
+		claims[name] = value
+		return claims
+	}
+	cases := []struct {
+		name   string
+		signer *bank.JwsSigner
+		claims map[string]interface{}
+		err    string
+	}{
+		{name: "valid", claims: idTokenClaims("n-1", "code-1", "s-1")},
+		{name: "audience array", claims: with("aud", []string{"other", TEST_CLIENT_ID})},
+		{name: "wrong issuer", claims: with("iss", "https://evil.test"), err: "iss mismatch"},
+		{name: "wrong audience", claims: with("aud", "other"), err: "audience mismatch"},
+		{name: "expired", claims: with("exp", time.Now().Add(-5*time.Minute).Unix()), err: "expired"},
+		{name: "wrong nonce", claims: idTokenClaims("n-2", "code-1", "s-1"), err: "nonce mismatch"},
+		{name: "swapped code", claims: idTokenClaims("n-1", "code-2", "s-1"), err: "c_hash mismatch"},
+		{name: "wrong state", claims: idTokenClaims("n-1", "code-1", "s-2"), err: "s_hash mismatch"},
+		{name: "foreign key", signer: otherSigner, claims: idTokenClaims("n-1", "code-1", "s-1"), err: "Invalid ID token"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := signer
+			if c.signer != nil {
+				s = c.signer
+			}
+			token, err := s.SignJwt(c.claims)
+			require.NoError(t, err)
+
+			// act
+			_, err = validator.Validate(token, expected)
+
+			// assert
+			if c.err == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), c.err)
+			}
+		})
+	}
+}
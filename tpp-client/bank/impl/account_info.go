@@ -0,0 +1,321 @@
+package bank_impl
+
+import (
+	"encoding/json"
+	"errors"
+	resty "github.com/go-resty/resty/v2"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const ACCOUNTS_SCOPE = "accounts"
+
+// ACCOUNT_ACCESS is the key of the account access token, kept along with the payment tokens
+const ACCOUNT_ACCESS = "account-access"
+
+// MAX_TRANSACTION_PAGES limits the pages of transactions followed in one call
+const MAX_TRANSACTION_PAGES = 20
+
+// OB_DATE_TIME is the format of the AISP date-time query parameters
+const OB_DATE_TIME = "2006-01-02T15:04:05"
+
+const ACCOUNT_ACCESS_CONSENT_PAYLOAD = `
+{
+	"Data": {
+		"Permissions": [
+			"ReadAccountsBasic",
+			"ReadAccountsDetail",
+			"ReadTransactionsBasic",
+			"ReadTransactionsCredits",
+			"ReadTransactionsDetail"
+		]
+	},
+	"Risk": {}
+}
+`
+
+var errNoAccountAccess = errors.New("Account access has not been authorised")
+
+// SetAccountAccessRefreshToken sets the refresh token of an account access consent, authorised by the account owner
+func (c *NatwestSandboxClient) SetAccountAccessRefreshToken(refreshToken string) {
+	c.paymentTokens.put(ACCOUNT_ACCESS, &AccessTokenResponse{RefreshToken: refreshToken})
+}
+
+// AuthoriseAccountAccess creates an account access consent and approves it as the given user.
+// Only works against the sandbox, which approves consents without the user's interaction.
+func (c *NatwestSandboxClient) AuthoriseAccountAccess(username string) error {
+
+	c.l.Infow("Authorising account access",
+		"username", username)
+
+	at, err := c.tokens.Get(c.clientCreds.ClientId, ACCOUNTS_SCOPE, func() (*bank.AccessToken, error) {
+		return c.fetchClientCredentialsToken(ACCOUNT_ACCESS, ACCOUNTS_SCOPE)
+	})
+	if err != nil {
+		return err
+	}
+
+	// 1) create the consent
+	sig, err := c.jwsSignature(ACCOUNT_ACCESS_CONSENT_PAYLOAD)
+	if err != nil {
+		return err
+	}
+	var resp *resty.Response
+	err = c.retry.Do("account access consent", c.l, func() error {
+		var err error
+		resp, err = c.client.R().
+			SetHeader("Accept", "application/json").
+			SetHeader("Content-Type", "application/json").
+			SetHeader("Authorization", "Bearer "+at.Token).
+			SetHeader(bank.JWS_SIGNATURE_HDR, sig).
+			SetBody(ACCOUNT_ACCESS_CONSENT_PAYLOAD).
+			Post(c.endpoints.AccountAccessConsent)
+
+		if err != nil {
+			return bank.NewTransportError("account access consent", err)
+		}
+		if resp.StatusCode() != http.StatusCreated {
+			return bank.NewBankError("account access consent", resp.StatusCode(), resp.Body(), resp.Header())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var cResp struct {
+		Data struct {
+			ConsentId string
+		}
+	}
+	if err = json.Unmarshal(resp.Body(), &cResp); err != nil {
+		return err
+	}
+	consentId := cResp.Data.ConsentId
+	if consentId == "" {
+		return malformed("account access consent", "Data.ConsentId")
+	}
+
+	// 2) approve it
+	resp, err = c.client.R().
+		SetHeader("Accept", "application/json").
+		SetQueryParamsFromValues(url.Values{
+			"client_id":              {c.clientCreds.ClientId},
+			"client_secret":          {c.clientCreds.ClientSecret},
+			"response_type":          {"code id_token"},
+			"redirect_uri":           {c.clientCreds.RedirectionUrl},
+			"scope":                  {"openid accounts"},
+			"request":                {consentId},
+			"authorization_mode":     {"AUTO_POSTMAN"},
+			"authorization_result":   {"APPROVED"},
+			"authorization_username": {username},
+		}).
+		Get(c.endpoints.CreateAuthUrl)
+	if err != nil {
+		return bank.NewTransportError("approve account access", err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return bank.NewBankError("approve account access", resp.StatusCode(), resp.Body(), resp.Header())
+	}
+	var aResp map[string]interface{}
+	if err = json.Unmarshal(resp.Body(), &aResp); err != nil {
+		return err
+	}
+	uri, _ := aResp["redirectUri"].(string)
+	rs := regexp.MustCompile(`.*?\#code\=([\w|-]+)\&.+`).FindStringSubmatch(uri)
+	if len(rs) != 2 {
+		return errors.New("Failed to parse account access code. Redirect uri: " + uri)
+	}
+
+	// 3) exchange the code. The token is kept for the account calls.
+	_, err = c.exchangeConsentCode(&bank.PaymentAuthGranted{
+		RequestId:   ACCOUNT_ACCESS,
+		ConsentId:   consentId,
+		ConsentCode: rs[1],
+	})
+	return err
+}
+
+// GetAccountCredits returns the booked credits of one of our accounts
+func (c *NatwestSandboxClient) GetAccountCredits(account *bank.AccountDetails, since time.Time) ([]bank.AccountTransaction, error) {
+
+	token, err := c.accountToken()
+	if err != nil {
+		return nil, err
+	}
+	accountId, err := c.accountId(token, account)
+	if err != nil {
+		return nil, err
+	}
+
+	var credits []bank.AccountTransaction
+	resource := c.endpoints.Accounts + "/" + accountId + "/transactions"
+	query := url.Values{"fromBookingDateTime": {since.UTC().Format(OB_DATE_TIME)}}
+	for page := 1; ; page++ {
+		var tResp struct {
+			Data struct {
+				Transaction []struct {
+					TransactionId        string
+					TransactionReference string
+					CreditDebitIndicator string
+					Status               string
+					BookingDateTime      string
+					Amount               struct {
+						Amount   string
+						Currency string
+					}
+				}
+			}
+			Links struct {
+				Next string
+			}
+		}
+		if err = c.getAccountResource(token, resource, query, &tResp); err != nil {
+			return nil, err
+		}
+
+		for _, t := range tResp.Data.Transaction {
+			if t.CreditDebitIndicator != "Credit" || t.Status != "Booked" {
+				continue
+			}
+			booked, _ := time.Parse(time.RFC3339, t.BookingDateTime)
+			credits = append(credits, bank.AccountTransaction{
+				TransactionId: t.TransactionId,
+				Amount:        t.Amount.Amount,
+				Currency:      t.Amount.Currency,
+				Reference:     t.TransactionReference,
+				BookedAt:      booked,
+			})
+		}
+
+		next := tResp.Links.Next
+		if next == "" || next == resource {
+			return credits, nil
+		}
+		if page >= MAX_TRANSACTION_PAGES {
+			return nil, &bank.BankError{
+				Operation: "get account resource",
+				Status:    http.StatusOK,
+				Class:     bank.TERMINAL,
+				Cause:     errors.New("More than " + strconv.Itoa(MAX_TRANSACTION_PAGES) + " pages of transactions"),
+			}
+		}
+		// the link carries the query, and the access token must not leave the ASPSP
+		if err = sameOrigin(next, c.endpoints.Accounts); err != nil {
+			return nil, err
+		}
+		resource, query = next, nil
+	}
+}
+
+// sameOrigin checks that a link returned by the ASPSP points back to it
+func sameOrigin(link string, endpoint string) error {
+	l, err := url.Parse(link)
+	if err != nil {
+		return malformed("get account resource", "valid Links.Next")
+	}
+	e, err := url.Parse(endpoint)
+	if err != nil || l.Scheme != e.Scheme || l.Host != e.Host {
+		return &bank.BankError{
+			Operation: "get account resource",
+			Status:    http.StatusOK,
+			Class:     bank.TERMINAL,
+			Cause:     errors.New("Links.Next outside the ASPSP: " + link),
+		}
+	}
+	return nil
+}
+
+// accountToken returns the access token of the account access consent, refreshing it when needed
+func (c *NatwestSandboxClient) accountToken() (string, error) {
+
+	t := c.paymentTokens.get(ACCOUNT_ACCESS)
+	if t == nil {
+		return "", &bank.BankError{Operation: "account access", Status: http.StatusUnauthorized, Class: bank.TERMINAL, Cause: errNoAccountAccess}
+	}
+	if t.access != "" && (t.expiry.IsZero() || time.Now().Add(TOKEN_EXPIRY_MARGIN).Before(t.expiry)) {
+		return t.access, nil
+	}
+	if t.refresh == "" {
+		return "", &bank.BankError{Operation: "account access", Status: http.StatusUnauthorized, Class: bank.TERMINAL, Cause: errNoAccountAccess}
+	}
+	refreshed, err := c.refreshPaymentToken(ACCOUNT_ACCESS, t.refresh)
+	if err != nil {
+		return "", err
+	}
+	return refreshed.access, nil
+}
+
+// accountId looks up the ASPSP's ID of one of our accounts
+func (c *NatwestSandboxClient) accountId(token string, account *bank.AccountDetails) (string, error) {
+
+	identification := account.Identification()
+	if id, ok := c.accountIds.Load(identification); ok {
+		return id.(string), nil
+	}
+
+	var aResp struct {
+		Data struct {
+			Account []struct {
+				AccountId string
+				Account   []struct {
+					SchemeName     string
+					Identification string
+				}
+			}
+		}
+	}
+	if err := c.getAccountResource(token, c.endpoints.Accounts, nil, &aResp); err != nil {
+		return "", err
+	}
+	for _, a := range aResp.Data.Account {
+		for _, id := range a.Account {
+			if id.Identification == identification {
+				c.accountIds.Store(identification, a.AccountId)
+				return a.AccountId, nil
+			}
+		}
+	}
+	return "", &bank.BankError{
+		Operation: "get accounts",
+		Status:    http.StatusNotFound,
+		Class:     bank.TERMINAL,
+		Cause:     errors.New("Account " + identification + " is not accessible"),
+	}
+}
+
+// getAccountResource GETs an AISP resource into `result`
+func (c *NatwestSandboxClient) getAccountResource(token string, resource string, query url.Values, result interface{}) error {
+
+	var resp *resty.Response
+	err := c.retry.Do("get account resource", c.l, func() error {
+		var err error
+		resp, err = c.client.R().
+			SetHeader("Accept", "application/json").
+			SetHeader("Authorization", "Bearer "+token).
+			SetQueryParamsFromValues(query).
+			Get(resource)
+
+		if err != nil {
+			return bank.NewTransportError("get account resource", err)
+		}
+		if resp.StatusCode() != http.StatusOK {
+			return bank.NewBankError("get account resource", resp.StatusCode(), resp.Body(), resp.Header())
+		}
+		return nil
+	})
+	if bank.IsAuthExpired(err) {
+		// drop the rejected access token, so that the next call refreshes it
+		c.paymentTokens.put(ACCOUNT_ACCESS, &AccessTokenResponse{})
+	}
+	if err != nil {
+		return err
+	}
+	if err = c.verifyResponseSignature(resp); err != nil {
+		return err
+	}
+	return json.Unmarshal(resp.Body(), result)
+}
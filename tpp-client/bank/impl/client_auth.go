@@ -0,0 +1,72 @@
+package bank_impl
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const CLIENT_ASSERTION_TYPE = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// CLIENT_ASSERTION_LIFETIME is the validity of a private_key_jwt client assertion
+const CLIENT_ASSERTION_LIFETIME = 5 * time.Minute
+
+// NewTlsTransport returns an HTTP transport for the ASPSP connection.
+// It presents the client's transport certificate (MTLS), if present, and trusts the configured root CAs.
+func NewTlsTransport(creds *bank.OauthClientCreds) *http.Transport {
+
+	tlsConf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    creds.RootCAs,
+	}
+	if len(creds.TransportCert.Certificate) > 0 {
+		tlsConf.Certificates = []tls.Certificate{creds.TransportCert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConf
+	return transport
+}
+
+// addClientAuth adds the client authentication parameters of a token request, according to the configured method:
+//   - client_secret_post: the client ID and secret
+//   - tls_client_auth: just the client ID, the client is identified by its transport certificate
+//   - private_key_jwt: a client assertion signed with the signing key
+func addClientAuth(form url.Values, creds *bank.OauthClientCreds, signer *bank.JwsSigner, tokenUrl string) error {
+
+	form.Set("client_id", creds.ClientId)
+
+	switch creds.TokenAuthMethod {
+	case "", bank.CLIENT_SECRET_POST:
+		form.Set("client_secret", creds.ClientSecret)
+	case bank.TLS_CLIENT_AUTH:
+		if len(creds.TransportCert.Certificate) == 0 {
+			return errors.New("tls_client_auth requires a transport certificate")
+		}
+	case bank.PRIVATE_KEY_JWT:
+		if signer == nil {
+			return errors.New("private_key_jwt requires a signing key")
+		}
+		now := time.Now()
+		assertion, err := signer.SignJwt(map[string]interface{}{
+			"iss": creds.ClientId,
+			"sub": creds.ClientId,
+			"aud": tokenUrl,
+			"jti": uuid.New().String(),
+			"iat": now.Unix(),
+			"exp": now.Add(CLIENT_ASSERTION_LIFETIME).Unix(),
+		})
+		if err != nil {
+			return err
+		}
+		form.Set("client_assertion_type", CLIENT_ASSERTION_TYPE)
+		form.Set("client_assertion", assertion)
+	default:
+		return errors.New("Unsupported token endpoint auth method: " + creds.TokenAuthMethod)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package bank_impl_test
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	bank_mock "github.com/sgerogia/sol-stablecoin/tpp-client/bank/mock"
+	test_util "github.com/sgerogia/sol-stablecoin/tpp-client/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestNatwestClient_Mtls_TlsClientAuth(t *testing.T) {
+
+	// arrange
+	transportCert, err := test_util.NewSelfSignedCert("tpp.test")
+	require.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(transportCert.Leaf)
+
+	aspsp := bank_mock.NewAspspTlsServer(clientCAs)
+	defer aspsp.Close()
+	aspsp.ClientId = "tpp-client"
+
+	creds := bank.OauthClientCreds{
+		ClientId:        "tpp-client",
+		TransportCert:   *transportCert,
+		RootCAs:         aspsp.RootCAs(),
+		TokenAuthMethod: bank.TLS_CLIENT_AUTH,
+	}
+	client := bank_impl.NewNatwestClient(5, aspsp.Endpoints(), &creds, zap.NewExample().Sugar())
+
+	// act
+	token, err := client.GetPaymentAuthAccessToken("req")
+
+	// assert
+	require.NoError(t, err)
+	assert.NotEmpty(t, token.Token)
+}
+
+func TestNatwestClient_Mtls_NoClientCertRejected(t *testing.T) {
+
+	// arrange
+	transportCert, err := test_util.NewSelfSignedCert("tpp.test")
+	require.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(transportCert.Leaf)
+
+	aspsp := bank_mock.NewAspspTlsServer(clientCAs)
+	defer aspsp.Close()
+
+	creds := bank.OauthClientCreds{
+		ClientId:     "tpp-client",
+		ClientSecret: "secret",
+		RootCAs:      aspsp.RootCAs(),
+	}
+	client := bank_impl.NewNatwestClient(5, aspsp.Endpoints(), &creds, zap.NewExample().Sugar())
+
+	// act
+	_, err = client.GetPaymentAuthAccessToken("req")
+
+	// assert
+	assert.Error(t, err)
+}
+
+func TestNatwestClient_PrivateKeyJwt(t *testing.T) {
+
+	// arrange
+	signingCert, err := test_util.NewSelfSignedCert("tpp.test")
+	require.NoError(t, err)
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.ClientId = "tpp-client"
+	aspsp.ClientSecret = "not-sent"
+	aspsp.TppSigningKey = &signingCert.PrivateKey.(*rsa.PrivateKey).PublicKey
+
+	creds := bank.OauthClientCreds{
+		ClientId:        "tpp-client",
+		SigningCert:     *signingCert,
+		SigningKeyId:    "tpp-kid",
+		TokenAuthMethod: bank.PRIVATE_KEY_JWT,
+	}
+	client := bank_impl.NewNatwestClient(5, aspsp.Endpoints(), &creds, zap.NewExample().Sugar())
+
+	// act
+	token, err := client.GetPaymentAuthAccessToken("req")
+
+	// assert
+	require.NoError(t, err)
+	assert.NotEmpty(t, token.Token)
+}
+
+func TestNatwestClient_PrivateKeyJwt_WrongKeyRejected(t *testing.T) {
+
+	// arrange
+	signingCert, err := test_util.NewSelfSignedCert("tpp.test")
+	require.NoError(t, err)
+	registeredCert, err := test_util.NewSelfSignedCert("registered.test")
+	require.NoError(t, err)
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.TppSigningKey = &registeredCert.PrivateKey.(*rsa.PrivateKey).PublicKey
+
+	creds := bank.OauthClientCreds{
+		ClientId:        "tpp-client",
+		SigningCert:     *signingCert,
+		TokenAuthMethod: bank.PRIVATE_KEY_JWT,
+	}
+	client := bank_impl.NewNatwestClient(5, aspsp.Endpoints(), &creds, zap.NewExample().Sugar())
+
+	// act
+	_, err = client.GetPaymentAuthAccessToken("req")
+
+	// assert
+	assert.ErrorContains(t, err, "401")
+}
@@ -0,0 +1,114 @@
+package bank_impl
+
+import (
+	"encoding/json"
+	resty "github.com/go-resty/resty/v2"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// COP_ACCOUNT_TYPE of the payers. Business accounts come back as close matches (BANM).
+const COP_ACCOUNT_TYPE = "Personal"
+
+type copRequest struct {
+	Data copRequestData `json:"Data"`
+}
+
+type copRequestData struct {
+	SchemeName              string `json:"SchemeName"`
+	AccountType             string `json:"AccountType"`
+	Identification          string `json:"Identification"`
+	SecondaryIdentification string `json:"SecondaryIdentification,omitempty"`
+	Name                    string `json:"Name"`
+}
+
+type copResponse struct {
+	Data struct {
+		VerificationReport struct {
+			Matched    bool
+			ReasonCode string
+			Name       string
+		}
+	}
+}
+
+// CopClient checks account names against a Confirmation of Payee style API
+type CopClient struct {
+	client *resty.Client
+	url    string
+	retry  *RetryPolicy
+	l      *zap.SugaredLogger
+}
+
+// NewCopClient returns a client of the name verification endpoint at `url`, connecting with the client's
+// transport certificate, if any
+func NewCopClient(
+	timeout int,
+	url string,
+	creds *bank.OauthClientCreds,
+	_l *zap.SugaredLogger) bank.NameVerificationClient {
+
+	cl := http.Client{
+		Timeout:   time.Duration(timeout) * time.Second,
+		Transport: DefaultMiddleware(_l)(NewTlsTransport(creds)),
+	}
+	return &CopClient{
+		client: resty.NewWithClient(&cl),
+		url:    url,
+		retry:  DefaultRetryPolicy(),
+		l:      _l,
+	}
+}
+
+// SetRetryPolicy overrides the default retry policy of the name checks
+func (c *CopClient) SetRetryPolicy(policy *RetryPolicy) {
+	c.retry = policy
+}
+
+func (c *CopClient) VerifyName(account *bank.AccountDetails) (*bank.NameVerificationResult, error) {
+
+	body, err := json.Marshal(&copRequest{Data: copRequestData{
+		SchemeName:              account.Scheme(),
+		AccountType:             COP_ACCOUNT_TYPE,
+		Identification:          account.Identification(),
+		SecondaryIdentification: account.SecondaryIdentification,
+		Name:                    account.Name,
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *resty.Response
+	err = c.retry.Do("name verification", c.l, func() error {
+		var err error
+		resp, err = c.client.R().
+			SetHeader("Accept", "application/json").
+			SetHeader("Content-Type", "application/json").
+			SetBody(body).
+			Post(c.url)
+
+		if err != nil {
+			return bank.NewTransportError("name verification", err)
+		}
+		if resp.StatusCode() != http.StatusOK {
+			return bank.NewBankError("name verification", resp.StatusCode(), resp.Body(), resp.Header())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var cResp copResponse
+	if err = json.Unmarshal(resp.Body(), &cResp); err != nil {
+		return nil, err
+	}
+	report := cResp.Data.VerificationReport
+	return &bank.NameVerificationResult{
+		Match:       bank.NameMatchOf(report.Matched, report.ReasonCode),
+		ReasonCode:  report.ReasonCode,
+		AccountName: report.Name,
+	}, nil
+}
@@ -0,0 +1,71 @@
+package bank_impl_test
+
+import (
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	bank_mock "github.com/sgerogia/sol-stablecoin/tpp-client/bank/mock"
+	test_util "github.com/sgerogia/sol-stablecoin/tpp-client/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"net/http"
+	"testing"
+)
+
+func newCopClient(aspsp *bank_mock.AspspServer) bank.NameVerificationClient {
+	client := bank_impl.NewCopClient(5, aspsp.NameVerificationUrl(), &bank.OauthClientCreds{}, zap.NewExample().Sugar())
+	client.(*bank_impl.CopClient).SetRetryPolicy(fastRetries())
+	return client
+}
+
+func TestCopClient_MockAspsp_VerifyName(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.SetAccountName(test_util.Payer(), "John Doe")
+	client := newCopClient(aspsp)
+
+	cases := []struct {
+		name        string
+		account     *bank.AccountDetails
+		match       bank.NameMatch
+		accountName string
+	}{
+		{name: "exact", account: test_util.Payer(), match: bank.NAME_EXACT},
+		{name: "close", account: &bank.AccountDetails{SortCode: "500000", AccountNumber: "12345601", Name: "J Doe"},
+			match: bank.NAME_CLOSE, accountName: "John Doe"},
+		{name: "none", account: &bank.AccountDetails{SortCode: "500000", AccountNumber: "12345601", Name: "Jane Smith"},
+			match: bank.NAME_NONE},
+		{name: "unknown account", account: test_util.Receiver(), match: bank.NAME_NONE},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			result, err := client.VerifyName(c.account)
+
+			// assert
+			require.NoError(t, err)
+			assert.Equal(t, c.match, result.Match)
+			assert.Equal(t, c.accountName, result.AccountName)
+		})
+	}
+}
+
+func TestCopClient_MockAspsp_RetriesUnavailable(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.SetAccountName(test_util.Payer(), "John Doe")
+	aspsp.SetBehaviour(bank_mock.NAME_VERIFICATION, bank_mock.Behaviour{FailStatus: http.StatusServiceUnavailable, FailTimes: 1})
+	client := newCopClient(aspsp)
+
+	// act
+	result, err := client.VerifyName(test_util.Payer())
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, bank.NAME_EXACT, result.Match)
+	assert.Equal(t, 2, aspsp.CallCount(bank_mock.NAME_VERIFICATION))
+}
@@ -0,0 +1,65 @@
+package bank_impl
+
+import (
+	resty "github.com/go-resty/resty/v2"
+	"github.com/google/uuid"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"net"
+	"net/http"
+)
+
+// FAPI headers of the OB resource calls
+const (
+	// FAPI_FINANCIAL_ID is the ASPSP's OB organisation ID. Required by some ASPSPs only.
+	FAPI_FINANCIAL_ID = "x-fapi-financial-id"
+	// FAPI_INTERACTION_ID correlates a call with the ASPSP's logs. The ASPSP echoes it in the response.
+	FAPI_INTERACTION_ID = "x-fapi-interaction-id"
+	// FAPI_AUTH_DATE and FAPI_CUSTOMER_IP_ADDRESS are about the PSU, sent only if they took part in the call's flow
+	FAPI_AUTH_DATE           = "x-fapi-auth-date"
+	FAPI_CUSTOMER_IP_ADDRESS = "x-fapi-customer-ip-address"
+)
+
+// INTERACTION_NAMESPACE namespaces the name-based UUIDs used as interaction IDs
+var INTERACTION_NAMESPACE = uuid.MustParse("3b0c8a6e-5d7f-4c1e-b2a4-9e6d1f0c7a35")
+
+// InteractionId returns the interaction ID of all the calls of a mint request.
+// It is a name-based (v5) UUID of the request ID, so the ASPSP's support can trace a request from its ID alone.
+func InteractionId(requestId string) string {
+	return uuid.NewSHA1(INTERACTION_NAMESPACE, []byte(requestId)).String()
+}
+
+// PsuHeaders returns the FAPI headers about the PSU of a granted consent, skipping the ones not known or invalid
+func PsuHeaders(granted *bank.PaymentAuthGranted) map[string]string {
+	headers := make(map[string]string)
+	if !granted.AuthDate.IsZero() {
+		headers[FAPI_AUTH_DATE] = granted.AuthDate.UTC().Format(http.TimeFormat)
+	}
+	if ip := net.ParseIP(granted.CustomerIpAddress); ip != nil {
+		headers[FAPI_CUSTOMER_IP_ADDRESS] = ip.String()
+	}
+	return headers
+}
+
+// fapiRequest returns a request of the mint request's flow, with its FAPI headers
+func (c *NatwestSandboxClient) fapiRequest(requestId string) *resty.Request {
+	r := c.client.R().
+		SetHeader(FAPI_INTERACTION_ID, InteractionId(requestId))
+	if c.financialId != "" {
+		r.SetHeader(FAPI_FINANCIAL_ID, c.financialId)
+	}
+	return r
+}
+
+// psuRequest returns a request made on behalf of the PSU who granted the consent
+func (c *NatwestSandboxClient) psuRequest(granted *bank.PaymentAuthGranted) *resty.Request {
+	return c.fapiRequest(granted.RequestId).
+		SetHeaders(PsuHeaders(granted))
+}
+
+// echoedInteractionId returns the interaction ID of the ASPSP's response, i.e. the one to quote to their support
+func echoedInteractionId(resp *resty.Response) string {
+	if id := resp.Header().Get(FAPI_INTERACTION_ID); id != "" {
+		return id
+	}
+	return resp.Request.Header.Get(FAPI_INTERACTION_ID)
+}
@@ -0,0 +1,150 @@
+package bank_impl_test
+
+import (
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	bank_mock "github.com/sgerogia/sol-stablecoin/tpp-client/bank/mock"
+	test_util "github.com/sgerogia/sol-stablecoin/tpp-client/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNatwestClient_MockAspsp_FapiHeaders(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	client := newMockClient(aspsp)
+	client.(*bank_impl.NatwestSandboxClient).SetFinancialId("0015800000jfwxXAAQ")
+	authReq := newAuthRequest()
+	receiver := test_util.Receiver()
+	authDate := time.Date(2023, 3, 14, 9, 26, 53, 0, time.UTC)
+
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, receiver)
+	require.NoError(t, err)
+	granted, err := client.(*bank_impl.NatwestSandboxClient).ApproveConsent(authResp, test_util.MockAspspInfo().CustomerUsername)
+	require.NoError(t, err)
+	granted.AuthDate = authDate
+	granted.CustomerIpAddress = "203.0.113.7"
+
+	// act
+	_, err = client.ConfirmFunds(granted)
+	require.NoError(t, err)
+	paymResp, err := client.SubmitPayment(granted, authReq, receiver)
+	require.NoError(t, err)
+	_, err = client.GetPaymentStatus(paymResp)
+	require.NoError(t, err)
+	_, err = client.GetPaymentConsentStatus(authResp, token)
+	require.NoError(t, err)
+
+	// assert
+	interactionId := bank_impl.InteractionId(authReq.RequestId)
+	assert.Equal(t, interactionId, authResp.InteractionId)
+	assert.Equal(t, interactionId, paymResp.InteractionId)
+	for _, endpoint := range []string{
+		bank_mock.CONSENT,
+		bank_mock.FUNDS_CONFIRMATION,
+		bank_mock.PAYMENT,
+		bank_mock.PAYMENT_STATUS,
+		bank_mock.CONSENT_STATUS} {
+
+		headers := aspsp.LastHeaders(endpoint)
+		require.NotNil(t, headers, endpoint)
+		assert.Equal(t, interactionId, headers.Get(bank_impl.FAPI_INTERACTION_ID), endpoint)
+		assert.Equal(t, "0015800000jfwxXAAQ", headers.Get(bank_impl.FAPI_FINANCIAL_ID), endpoint)
+	}
+	// PSU headers only on the calls made on the payer's behalf
+	for _, endpoint := range []string{bank_mock.FUNDS_CONFIRMATION, bank_mock.PAYMENT} {
+		headers := aspsp.LastHeaders(endpoint)
+		assert.Equal(t, "Tue, 14 Mar 2023 09:26:53 GMT", headers.Get(bank_impl.FAPI_AUTH_DATE), endpoint)
+		assert.Equal(t, "203.0.113.7", headers.Get(bank_impl.FAPI_CUSTOMER_IP_ADDRESS), endpoint)
+	}
+	for _, endpoint := range []string{bank_mock.CONSENT, bank_mock.PAYMENT_STATUS, bank_mock.CONSENT_STATUS} {
+		headers := aspsp.LastHeaders(endpoint)
+		assert.Empty(t, headers.Get(bank_impl.FAPI_AUTH_DATE), endpoint)
+		assert.Empty(t, headers.Get(bank_impl.FAPI_CUSTOMER_IP_ADDRESS), endpoint)
+	}
+}
+
+func TestNatwestClient_MockAspsp_NoFinancialIdByDefault(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	client := newMockClient(aspsp)
+	authReq := newAuthRequest()
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+
+	// act
+	_, err = client.CreatePaymentAuthRequest(authReq, token, test_util.Receiver())
+
+	// assert
+	require.NoError(t, err)
+	headers := aspsp.LastHeaders(bank_mock.CONSENT)
+	assert.Empty(t, headers.Get(bank_impl.FAPI_FINANCIAL_ID))
+	assert.Equal(t, bank_impl.InteractionId(authReq.RequestId), headers.Get(bank_impl.FAPI_INTERACTION_ID))
+}
+
+func TestInteractionId(t *testing.T) {
+
+	// act
+	id := bank_impl.InteractionId("request-1")
+
+	// assert
+	assert.Equal(t, id, bank_impl.InteractionId("request-1"))
+	assert.NotEqual(t, id, bank_impl.InteractionId("request-2"))
+	assert.Len(t, id, 36)
+}
+
+func TestPsuHeaders(t *testing.T) {
+
+	authDate := time.Date(2023, 3, 14, 10, 26, 53, 0, time.FixedZone("CET", 3600))
+
+	tests := []struct {
+		name     string
+		granted  *bank.PaymentAuthGranted
+		expected map[string]string
+	}{
+		{
+			name:    "both known",
+			granted: &bank.PaymentAuthGranted{AuthDate: authDate, CustomerIpAddress: "2001:db8::1"},
+			expected: map[string]string{
+				bank_impl.FAPI_AUTH_DATE:           "Tue, 14 Mar 2023 09:26:53 GMT",
+				bank_impl.FAPI_CUSTOMER_IP_ADDRESS: "2001:db8::1",
+			},
+		},
+		{
+			name:     "unknown auth date",
+			granted:  &bank.PaymentAuthGranted{CustomerIpAddress: "203.0.113.7"},
+			expected: map[string]string{bank_impl.FAPI_CUSTOMER_IP_ADDRESS: "203.0.113.7"},
+		},
+		{
+			name:     "invalid IP address",
+			granted:  &bank.PaymentAuthGranted{AuthDate: authDate, CustomerIpAddress: "203.0.113.7\r\nX-Injected: 1"},
+			expected: map[string]string{bank_impl.FAPI_AUTH_DATE: "Tue, 14 Mar 2023 09:26:53 GMT"},
+		},
+		{
+			name:     "none known",
+			granted:  &bank.PaymentAuthGranted{},
+			expected: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// act
+			headers := bank_impl.PsuHeaders(tt.granted)
+
+			// assert
+			assert.Equal(t, tt.expected, headers)
+			_, err := http.ParseTime(headers[bank_impl.FAPI_AUTH_DATE])
+			assert.Equal(t, tt.granted.AuthDate.IsZero(), err != nil)
+		})
+	}
+}
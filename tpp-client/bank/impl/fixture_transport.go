@@ -0,0 +1,233 @@
+package bank_impl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// TransportDecorator wraps the HTTP transport of a bank client, e.g. to record its traffic
+type TransportDecorator func(next http.RoundTripper) http.RoundTripper
+
+// REDACTED replaces the secrets of the recorded interactions
+const REDACTED = "REDACTED"
+
+// redactedHeaders are replaced as a whole
+var redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+
+var (
+	// secret parameters of forms, query strings and URL fragments, e.g. the redirect URI of an approved consent.
+	// Parameters may be embedded in JSON bodies, where & is escaped as \u0026.
+	secretParams = regexp.MustCompile(`(^|[^A-Za-z_])(client_secret|client_assertion|code|id_token|access_token|refresh_token)=[^&#\s"\\]+`)
+	// secret fields of JSON bodies
+	secretFields = regexp.MustCompile(`"(client_secret|access_token|refresh_token|id_token|registration_access_token)"(\s*):(\s*)"[^"]*"`)
+)
+
+// Interaction is a recorded request and its response
+type Interaction struct {
+	Request  RecordedRequest
+	Response RecordedResponse
+}
+
+type RecordedRequest struct {
+	Method string
+	Url    string
+	Header http.Header `json:",omitempty"`
+	Body   string      `json:",omitempty"`
+}
+
+type RecordedResponse struct {
+	Status int
+	Header http.Header `json:",omitempty"`
+	Body   string      `json:",omitempty"`
+}
+
+// Fixture is the file format of the recorded interactions
+type Fixture struct {
+	Interactions []Interaction
+}
+
+// LoadFixture reads the recorded interactions of a fixture file
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f Fixture
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, errors.New("Invalid fixture " + path + ": " + err.Error())
+	}
+	return &f, nil
+}
+
+// RecordingTransport saves the redacted interactions of the next transport to a fixture file
+type RecordingTransport struct {
+	next    http.RoundTripper
+	path    string
+	fixture Fixture
+	lock    sync.Mutex
+}
+
+// RecordTo returns a decorator recording to the fixture file at `path`, overwriting it
+func RecordTo(path string) TransportDecorator {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &RecordingTransport{next: next, path: path}
+	}
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	req = req.Clone(req.Context())
+	reqBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := readBody(&resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.fixture.Interactions = append(t.fixture.Interactions, Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			Url:    redact(req.URL.String()),
+			Header: redactHeader(req.Header),
+			Body:   redact(string(reqBody)),
+		},
+		Response: RecordedResponse{
+			Status: resp.StatusCode,
+			Header: redactHeader(resp.Header),
+			Body:   redact(string(respBody)),
+		},
+	})
+	// save as we go, so that a failed run still leaves its interactions behind
+	data, err := json.MarshalIndent(&t.fixture, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(t.path, data, 0600); err != nil {
+		return nil, errors.New("Unable to save fixture: " + err.Error())
+	}
+	return resp, nil
+}
+
+// ReplayTransport serves recorded interactions, without any network access.
+// Requests are matched on their method and path, in the order of the recording, so that a flow replays against
+// any host. Bodies and query strings are not compared, as they carry per-run values like request IDs and timestamps.
+type ReplayTransport struct {
+	interactions []Interaction
+	served       []bool
+	lock         sync.Mutex
+}
+
+// NewReplayTransport returns a transport serving the recorded interactions of the fixture file at `path`
+func NewReplayTransport(path string) (*ReplayTransport, error) {
+	f, err := LoadFixture(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplayTransport{
+		interactions: f.Interactions,
+		served:       make([]bool, len(f.Interactions)),
+	}, nil
+}
+
+// Replace is the TransportDecorator substituting the replay for the client's transport
+func (t *ReplayTransport) Replace(_ http.RoundTripper) http.RoundTripper {
+	return t
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for i, in := range t.interactions {
+		if t.served[i] || in.Request.Method != req.Method || recordedPath(in.Request.Url) != req.URL.Path {
+			continue
+		}
+		t.served[i] = true
+		header := in.Response.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{
+			Status:        http.StatusText(in.Response.Status),
+			StatusCode:    in.Response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewBufferString(in.Response.Body)),
+			ContentLength: int64(len(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, errors.New("No recorded interaction for " + req.Method + " " + req.URL.Path)
+}
+
+// Pending returns the number of recorded interactions not yet served
+func (t *ReplayTransport) Pending() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	n := 0
+	for _, s := range t.served {
+		if !s {
+			n++
+		}
+	}
+	return n
+}
+
+// readBody reads the body and puts back an unread copy of it
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	data, err := io.ReadAll(*body)
+	_ = (*body).Close()
+	if err != nil {
+		return nil, err
+	}
+	*body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+func redact(s string) string {
+	s = secretParams.ReplaceAllString(s, "${1}${2}="+REDACTED)
+	return secretFields.ReplaceAllString(s, `"${1}"${2}:${3}"`+REDACTED+`"`)
+}
+
+func redactHeader(h http.Header) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	r := h.Clone()
+	for _, k := range redactedHeaders {
+		if r.Get(k) != "" {
+			r.Set(k, REDACTED)
+		}
+	}
+	return r
+}
+
+func recordedPath(u string) string {
+	if parsed, err := url.Parse(u); err == nil {
+		return parsed.Path
+	}
+	return u
+}
@@ -0,0 +1,130 @@
+package bank_impl_test
+
+import (
+	"flag"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	bank_mock "github.com/sgerogia/sol-stablecoin/tpp-client/bank/mock"
+	test_util "github.com/sgerogia/sol-stablecoin/tpp-client/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	f "path/filepath"
+	"testing"
+)
+
+// PAYMENT_FLOW_FIXTURE is the recording of a payment against the mock ASPSP, from token to settlement.
+// It tests the recording and replay of the client's traffic, not the responses of the Natwest sandbox;
+// these are recorded by the sandbox E2E test, with NATWEST_SANDBOX_RECORD.
+// Re-record it with `go test ./bank/impl -run MockAspspFixture -record`.
+const PAYMENT_FLOW_FIXTURE = "testdata/mock_aspsp_payment_flow.json"
+
+var record = flag.Bool("record", false, "re-record the fixtures against the mock ASPSP")
+
+type paymentFlow struct {
+	authResp *bank.PaymentAuthResponse
+	granted  *bank.PaymentAuthGranted
+	paymResp *bank.SubmitPaymentResponse
+	status   *bank.PaymentStatusResponse
+}
+
+// runPaymentFlow runs a payment through the client, from token to settlement
+func runPaymentFlow(t *testing.T, client bank.OpenBankingClient) *paymentFlow {
+	authReq := newAuthRequest()
+	receiver := test_util.Receiver()
+
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, receiver)
+	require.NoError(t, err)
+	granted, err := client.(*bank_impl.NatwestSandboxClient).ApproveConsent(authResp, test_util.MockAspspInfo().CustomerUsername)
+	require.NoError(t, err)
+	paymResp, err := client.SubmitPayment(granted, authReq, receiver)
+	require.NoError(t, err)
+	status, err := client.GetPaymentStatus(paymResp)
+	require.NoError(t, err)
+	return &paymentFlow{authResp: authResp, granted: granted, paymResp: paymResp, status: status}
+}
+
+func newSandboxClient() bank.OpenBankingClient {
+	info := test_util.MockAspspInfo()
+	creds := bank.OauthClientCreds{
+		ClientId:        info.ClientId,
+		ClientSecret:    info.ClientSecret,
+		RedirectionUrl:  info.RedirectUrl,
+		ReducedSecurity: true,
+	}
+	return bank_impl.NewNatwestSandboxClient(5, &creds, zap.NewExample().Sugar())
+}
+
+func TestNatwestClient_MockAspspFixture_RecordsRedacted(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.SetPaymentStatuses(bank.PAYMENT_ACCEPTED_SETTLEMENT_COMPLETED)
+	client := newMockClient(aspsp)
+	file := f.Join(t.TempDir(), "flow.json")
+	if *record {
+		file = PAYMENT_FLOW_FIXTURE
+	}
+	client.(*bank_impl.NatwestSandboxClient).SetTransport(bank_impl.RecordTo(file))
+
+	// act
+	flow := runPaymentFlow(t, client)
+
+	// assert: all calls are recorded, without their secrets
+	fixture, err := bank_impl.LoadFixture(file)
+	require.NoError(t, err)
+	assert.Len(t, fixture.Interactions, 7)
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), test_util.MockAspspInfo().ClientSecret)
+	assert.NotContains(t, string(data), flow.granted.ConsentCode)
+	assert.NotContains(t, string(data), flow.paymResp.ConsentToken)
+	assert.Contains(t, string(data), flow.paymResp.PaymentId)
+	for _, in := range fixture.Interactions {
+		if auth := in.Request.Header.Get("Authorization"); auth != "" {
+			assert.Equal(t, bank_impl.REDACTED, auth)
+		}
+	}
+}
+
+func TestNatwestClient_MockAspspFixture_Replay(t *testing.T) {
+
+	// arrange: the sandbox endpoints are never reached, the mock's recorded responses are returned instead
+	replay, err := bank_impl.NewReplayTransport(PAYMENT_FLOW_FIXTURE)
+	require.NoError(t, err)
+	client := newSandboxClient()
+	client.(*bank_impl.NatwestSandboxClient).SetTransport(replay.Replace)
+
+	// act
+	flow := runPaymentFlow(t, client)
+
+	// assert: the recorded responses are parsed
+	assert.NotEmpty(t, flow.authResp.ConsentId)
+	assert.Contains(t, flow.authResp.Url, "request=")
+	assert.Equal(t, flow.authResp.ConsentId, flow.granted.ConsentId)
+	assert.Equal(t, bank_impl.REDACTED, flow.granted.ConsentCode)
+	assert.NotEmpty(t, flow.paymResp.PaymentId)
+	assert.Equal(t, bank_impl.REDACTED, flow.paymResp.ConsentToken)
+	assert.True(t, flow.status.Status.IsSuccess())
+	assert.Zero(t, replay.Pending())
+}
+
+func TestNatwestClient_MockAspspFixture_ReplayUnrecorded(t *testing.T) {
+
+	// arrange
+	replay, err := bank_impl.NewReplayTransport(PAYMENT_FLOW_FIXTURE)
+	require.NoError(t, err)
+	client := newSandboxClient()
+	client.(*bank_impl.NatwestSandboxClient).SetTransport(replay.Replace)
+	client.(*bank_impl.NatwestSandboxClient).SetRetryPolicy(fastRetries())
+
+	// act: the recording has a single payment status
+	_, err = client.GetPaymentStatus(&bank.SubmitPaymentResponse{PaymentId: "unknown", ConsentToken: "token"})
+
+	// assert
+	assert.ErrorContains(t, err, "No recorded interaction")
+}
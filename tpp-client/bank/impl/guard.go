@@ -0,0 +1,334 @@
+package bank_impl
+
+import (
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"go.uber.org/zap"
+	"math"
+	"sync"
+	"time"
+)
+
+// endpoints guarded by GuardedClient, one rate limit each. Named after the operations of the bank errors.
+const (
+	GUARD_ACCESS_TOKEN   = "access token request"
+	GUARD_PAYMENT_AUTH   = "payment auth request"
+	GUARD_SUBMIT_PAYMENT = "submit payment"
+	GUARD_CONFIRM_FUNDS  = "confirm funds"
+	GUARD_CHECK_PAYMENT  = "check payment"
+	GUARD_CHECK_CONSENT  = "check consent"
+)
+
+// GuardPolicy protects the TPP from a throttling or failing ASPSP
+type GuardPolicy struct {
+	// RatePerSecond of the calls to each endpoint, with bursts of up to Burst calls. 0 disables rate limiting.
+	RatePerSecond float64
+	Burst         int
+	// MaxWait for the rate limit. Calls which would wait longer fail as rate limited.
+	MaxWait time.Duration
+	// FailureThreshold consecutive failures open the circuit breaker for OpenTimeout. 0 disables the breaker.
+	FailureThreshold int
+	OpenTimeout      time.Duration
+}
+
+func DefaultGuardPolicy() *GuardPolicy {
+	return &GuardPolicy{
+		RatePerSecond:    5,
+		Burst:            10,
+		MaxWait:          5 * time.Second,
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+	}
+}
+
+// GuardedClient decorates the client of an ASPSP with a token bucket rate limit per endpoint and a circuit breaker.
+// While the breaker is open, calls fail fast with an UNAVAILABLE error instead of waiting for the ASPSP to time out.
+type GuardedClient struct {
+	next        bank.OpenBankingClient
+	institution string
+	policy      *GuardPolicy
+	buckets     map[string]*TokenBucket
+	breaker     *CircuitBreaker
+	l           *zap.SugaredLogger
+}
+
+// NewGuardedClient returns `next` guarded as per the policy. `institution` identifies the ASPSP in errors and logs.
+func NewGuardedClient(
+	next bank.OpenBankingClient,
+	institution string,
+	policy *GuardPolicy,
+	_l *zap.SugaredLogger) bank.OpenBankingClient {
+
+	c := &GuardedClient{
+		next:        next,
+		institution: institution,
+		policy:      policy,
+		buckets:     make(map[string]*TokenBucket),
+		l:           _l,
+	}
+	if policy.RatePerSecond > 0 {
+		for _, op := range []string{GUARD_ACCESS_TOKEN, GUARD_PAYMENT_AUTH, GUARD_SUBMIT_PAYMENT, GUARD_CONFIRM_FUNDS, GUARD_CHECK_PAYMENT, GUARD_CHECK_CONSENT} {
+			c.buckets[op] = NewTokenBucket(policy.RatePerSecond, policy.Burst)
+		}
+	}
+	if policy.FailureThreshold > 0 {
+		c.breaker = NewCircuitBreaker(institution, policy.FailureThreshold, policy.OpenTimeout, _l)
+	}
+	return c
+}
+
+func (c *GuardedClient) GetPaymentAuthAccessToken(requestId string) (*bank.AccessToken, error) {
+	var token *bank.AccessToken
+	err := c.guard(GUARD_ACCESS_TOKEN, func() error {
+		var err error
+		token, err = c.next.GetPaymentAuthAccessToken(requestId)
+		return err
+	})
+	return token, err
+}
+
+func (c *GuardedClient) CreatePaymentAuthRequest(
+	payer *bank.PaymentAuthRequest,
+	access *bank.AccessToken,
+	beneficiary *bank.AccountDetails) (*bank.PaymentAuthResponse, error) {
+
+	var resp *bank.PaymentAuthResponse
+	err := c.guard(GUARD_PAYMENT_AUTH, func() error {
+		var err error
+		resp, err = c.next.CreatePaymentAuthRequest(payer, access, beneficiary)
+		return err
+	})
+	return resp, err
+}
+
+func (c *GuardedClient) SubmitPayment(
+	data *bank.PaymentAuthGranted,
+	paymentAuthRequest *bank.PaymentAuthRequest,
+	beneficiary *bank.AccountDetails) (*bank.SubmitPaymentResponse, error) {
+
+	var resp *bank.SubmitPaymentResponse
+	err := c.guard(GUARD_SUBMIT_PAYMENT, func() error {
+		var err error
+		resp, err = c.next.SubmitPayment(data, paymentAuthRequest, beneficiary)
+		return err
+	})
+	return resp, err
+}
+
+func (c *GuardedClient) ConfirmFunds(data *bank.PaymentAuthGranted) (*bank.FundsConfirmationResponse, error) {
+	var resp *bank.FundsConfirmationResponse
+	err := c.guard(GUARD_CONFIRM_FUNDS, func() error {
+		var err error
+		resp, err = c.next.ConfirmFunds(data)
+		return err
+	})
+	return resp, err
+}
+
+func (c *GuardedClient) GetPaymentStatus(data *bank.SubmitPaymentResponse) (*bank.PaymentStatusResponse, error) {
+	var resp *bank.PaymentStatusResponse
+	err := c.guard(GUARD_CHECK_PAYMENT, func() error {
+		var err error
+		resp, err = c.next.GetPaymentStatus(data)
+		return err
+	})
+	return resp, err
+}
+
+func (c *GuardedClient) ForgetPayment(requestId string) {
+	c.next.ForgetPayment(requestId)
+}
+
+func (c *GuardedClient) GetPaymentConsentStatus(
+	consent *bank.PaymentAuthResponse,
+	access *bank.AccessToken) (*bank.PaymentConsentStatusResponse, error) {
+
+	var resp *bank.PaymentConsentStatusResponse
+	err := c.guard(GUARD_CHECK_CONSENT, func() error {
+		var err error
+		resp, err = c.next.GetPaymentConsentStatus(consent, access)
+		return err
+	})
+	return resp, err
+}
+
+// guard calls `fn` within the operation's rate limit, unless the breaker is open
+func (c *GuardedClient) guard(operation string, fn func() error) error {
+
+	if c.breaker != nil && !c.breaker.Allow() {
+		return bank.NewUnavailableError(operation, c.institution)
+	}
+	if bucket, ok := c.buckets[operation]; ok {
+		wait, ok := bucket.Reserve(c.policy.MaxWait)
+		if !ok {
+			if c.breaker != nil {
+				c.breaker.Cancel()
+			}
+			return &bank.BankError{
+				Operation:  operation,
+				Class:      bank.RATE_LIMITED,
+				RetryAfter: wait,
+			}
+		}
+		if wait > 0 {
+			c.l.Debugw("Rate limited bank call",
+				"institution", c.institution,
+				"operation", operation,
+				"wait", wait)
+			time.Sleep(wait)
+		}
+	}
+
+	err := fn()
+	if c.breaker != nil {
+		c.breaker.Record(err)
+	}
+	return err
+}
+
+// TokenBucket allows `rate` calls per second on average, in bursts of up to `burst` calls
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	lock   sync.Mutex
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Reserve takes a token and returns how long to wait for it to be valid.
+// If that is longer than `maxWait`, no token is taken and false is returned.
+func (b *TokenBucket) Reserve(maxWait time.Duration) (time.Duration, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	if wait > maxWait {
+		return wait, false
+	}
+	b.tokens--
+	return wait, true
+}
+
+// BreakerState of a circuit breaker
+type BreakerState string
+
+const (
+	// BREAKER_CLOSED calls go through
+	BREAKER_CLOSED BreakerState = "closed"
+	// BREAKER_OPEN calls fail fast
+	BREAKER_OPEN BreakerState = "open"
+	// BREAKER_HALF_OPEN a single trial call goes through, to find out whether the ASPSP has recovered
+	BREAKER_HALF_OPEN BreakerState = "half-open"
+)
+
+// CircuitBreaker opens after consecutive failures of an ASPSP.
+// Only retryable failures count, i.e. transport errors, 5xx and rate limits. Others mean the ASPSP is up.
+type CircuitBreaker struct {
+	name        string
+	threshold   int
+	openTimeout time.Duration
+	state       BreakerState
+	failures    int
+	openedAt    time.Time
+	// trial is true while the half-open trial call is in flight
+	trial bool
+	lock  sync.Mutex
+	l     *zap.SugaredLogger
+}
+
+func NewCircuitBreaker(name string, threshold int, openTimeout time.Duration, _l *zap.SugaredLogger) *CircuitBreaker {
+	return &CircuitBreaker{
+		name:        name,
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		state:       BREAKER_CLOSED,
+		l:           _l,
+	}
+}
+
+// State returns the current state, half-open once the open timeout has passed
+func (b *CircuitBreaker) State() BreakerState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state == BREAKER_OPEN && time.Since(b.openedAt) >= b.openTimeout {
+		return BREAKER_HALF_OPEN
+	}
+	return b.state
+}
+
+// Allow returns whether a call may go through. Every allowed call must be followed by `Record` or `Cancel`.
+func (b *CircuitBreaker) Allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == BREAKER_OPEN && time.Since(b.openedAt) >= b.openTimeout {
+		b.state = BREAKER_HALF_OPEN
+	}
+	switch b.state {
+	case BREAKER_OPEN:
+		return false
+	case BREAKER_HALF_OPEN:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Cancel releases an allowed call which was not made
+func (b *CircuitBreaker) Cancel() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.trial = false
+}
+
+// Record the outcome of an allowed call
+func (b *CircuitBreaker) Record(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.trial = false
+
+	if err == nil || !bank.IsRetryable(err) {
+		if b.state != BREAKER_CLOSED {
+			b.l.Infow("Circuit breaker closed",
+				"institution", b.name)
+		}
+		b.state = BREAKER_CLOSED
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == BREAKER_HALF_OPEN || b.failures >= b.threshold {
+		if b.state != BREAKER_OPEN {
+			b.l.Warnw("Circuit breaker opened",
+				"institution", b.name,
+				"failures", b.failures,
+				"openFor", b.openTimeout,
+				"error", err)
+		}
+		b.state = BREAKER_OPEN
+		b.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,131 @@
+package bank_impl_test
+
+import (
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	bank_mock "github.com/sgerogia/sol-stablecoin/tpp-client/bank/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newGuardedClient(aspsp *bank_mock.AspspServer, policy *bank_impl.GuardPolicy) bank.OpenBankingClient {
+	client := newMockClient(aspsp)
+	client.(*bank_impl.NatwestSandboxClient).SetRetryPolicy(&bank_impl.RetryPolicy{MaxAttempts: 1})
+	return bank_impl.NewGuardedClient(client, "mock-aspsp", policy, zap.NewExample().Sugar())
+}
+
+func TestGuardedClient_MockAspsp_BreakerOpensAndRecovers(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.SetBehaviour(bank_mock.TOKEN, bank_mock.Behaviour{
+		FailStatus: http.StatusServiceUnavailable,
+		FailTimes:  -1,
+	})
+	client := newGuardedClient(aspsp, &bank_impl.GuardPolicy{
+		FailureThreshold: 2,
+		OpenTimeout:      100 * time.Millisecond,
+	})
+
+	// act: fail until the breaker opens
+	for i := 0; i < 2; i++ {
+		_, err := client.GetPaymentAuthAccessToken("req")
+		require.True(t, bank.IsRetryable(err))
+	}
+	_, err := client.GetPaymentAuthAccessToken("req")
+
+	// assert: the ASPSP is not called while open
+	assert.True(t, bank.IsUnavailable(err))
+	assert.ErrorContains(t, err, "mock-aspsp unavailable")
+	assert.Equal(t, 2, aspsp.CallCount(bank_mock.TOKEN))
+
+	// act: the trial call fails and re-opens the breaker
+	time.Sleep(150 * time.Millisecond)
+	_, err = client.GetPaymentAuthAccessToken("req")
+	assert.True(t, bank.IsRetryable(err))
+	_, err = client.GetPaymentAuthAccessToken("req")
+	assert.True(t, bank.IsUnavailable(err))
+	assert.Equal(t, 3, aspsp.CallCount(bank_mock.TOKEN))
+
+	// act: the ASPSP recovers
+	aspsp.SetBehaviour(bank_mock.TOKEN, bank_mock.Behaviour{})
+	time.Sleep(150 * time.Millisecond)
+	_, err = client.GetPaymentAuthAccessToken("req")
+
+	// assert: closed again
+	assert.NoError(t, err)
+	_, err = client.GetPaymentAuthAccessToken("req")
+	assert.NoError(t, err)
+}
+
+func TestGuardedClient_MockAspsp_TerminalErrorsKeepBreakerClosed(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.SetBehaviour(bank_mock.TOKEN, bank_mock.Behaviour{
+		FailStatus: http.StatusBadRequest,
+		FailTimes:  -1,
+	})
+	client := newGuardedClient(aspsp, &bank_impl.GuardPolicy{
+		FailureThreshold: 1,
+		OpenTimeout:      time.Minute,
+	})
+
+	// act
+	for i := 0; i < 3; i++ {
+		_, err := client.GetPaymentAuthAccessToken("req")
+
+		// assert
+		assert.True(t, bank.IsTerminal(err))
+	}
+	assert.Equal(t, 3, aspsp.CallCount(bank_mock.TOKEN))
+}
+
+func TestGuardedClient_MockAspsp_RateLimit(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	client := newGuardedClient(aspsp, &bank_impl.GuardPolicy{
+		RatePerSecond: 1,
+		Burst:         1,
+	})
+
+	// act
+	_, err := client.GetPaymentAuthAccessToken("req")
+	require.NoError(t, err)
+	_, err = client.GetPaymentAuthAccessToken("req")
+
+	// assert: not called, with the wait for the next token
+	assert.True(t, bank.IsRateLimited(err))
+	assert.Positive(t, err.(*bank.BankError).RetryAfter)
+	assert.Equal(t, 1, aspsp.CallCount(bank_mock.TOKEN))
+}
+
+func TestTokenBucket_Reserve(t *testing.T) {
+
+	// arrange
+	bucket := bank_impl.NewTokenBucket(10, 2)
+
+	// act & assert: the burst goes through, then calls wait for the rate
+	for i := 0; i < 2; i++ {
+		wait, ok := bucket.Reserve(0)
+		assert.True(t, ok)
+		assert.Zero(t, wait)
+	}
+	wait, ok := bucket.Reserve(0)
+	assert.False(t, ok)
+	assert.InDelta(t, 100*time.Millisecond, wait, float64(10*time.Millisecond))
+	wait, ok = bucket.Reserve(time.Second)
+	assert.True(t, ok)
+	assert.InDelta(t, 100*time.Millisecond, wait, float64(10*time.Millisecond))
+	wait, ok = bucket.Reserve(time.Second)
+	assert.True(t, ok)
+	assert.InDelta(t, 200*time.Millisecond, wait, float64(10*time.Millisecond))
+}
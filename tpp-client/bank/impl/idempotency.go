@@ -0,0 +1,21 @@
+package bank_impl
+
+import (
+	"github.com/google/uuid"
+)
+
+// Bank operations with side effects, i.e. the ones sent with an idempotency key
+const (
+	OP_CREATE_CONSENT = "create-consent"
+	OP_SUBMIT_PAYMENT = "submit-payment"
+)
+
+// IDEMPOTENCY_NAMESPACE namespaces the name-based UUIDs used as idempotency keys
+var IDEMPOTENCY_NAMESPACE = uuid.MustParse("6f1f5e0e-2b2c-4d4e-9a57-0d0c3c9a1b10")
+
+// IdempotencyKey derives the idempotency key of an operation from the request ID, a name-based (v5) UUID.
+// A retried call, even after a restart, is thus sent with the same key and recognised by the ASPSP as a duplicate.
+// The result is 36 chars, within the OB limit of 40.
+func IdempotencyKey(requestId string, operation string) string {
+	return uuid.NewSHA1(IDEMPOTENCY_NAMESPACE, []byte(requestId+":"+operation)).String()
+}
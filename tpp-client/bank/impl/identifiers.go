@@ -0,0 +1,101 @@
+package bank_impl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"strings"
+)
+
+// IDENTIFIER_PREFIX starts the instruction and end-to-end identifiers of our payments
+const IDENTIFIER_PREFIX = "PGBP"
+
+// OB_MAX_IDENTIFICATION is the maximum length of InstructionIdentification, EndToEndIdentification and Reference
+const OB_MAX_IDENTIFICATION = 35
+
+// OB_MAX_UNSTRUCTURED is the maximum length of the unstructured remittance information
+const OB_MAX_UNSTRUCTURED = 140
+
+// Remittance placeholders
+const (
+	// PH_REQUEST_ID is the full request ID, in hex
+	PH_REQUEST_ID = "{requestId}"
+	// PH_SHORT_ID is the first 8 characters of the request ID
+	PH_SHORT_ID = "{shortId}"
+	// PH_END_TO_END_ID is the payment's end-to-end identification
+	PH_END_TO_END_ID = "{endToEndId}"
+	// PH_AMOUNT is the payment's amount
+	PH_AMOUNT = "{amount}"
+)
+
+const (
+	DEFAULT_REMITTANCE_REFERENCE    = "PGBP " + PH_SHORT_ID
+	DEFAULT_REMITTANCE_UNSTRUCTURED = "Provable GBP mint " + PH_REQUEST_ID
+)
+
+// Remittance holds the templates of the remittance information, with placeholders such as `{shortId}`
+type Remittance struct {
+	Reference    string
+	Unstructured string
+}
+
+func DefaultRemittance() *Remittance {
+	return &Remittance{
+		Reference:    DEFAULT_REMITTANCE_REFERENCE,
+		Unstructured: DEFAULT_REMITTANCE_UNSTRUCTURED,
+	}
+}
+
+// Validate expands the templates for a sample request and checks the result against the OB field rules
+func (r *Remittance) Validate() error {
+	sample := &bank.PaymentAuthRequest{RequestId: strings.Repeat("0", 64), Amount: "1000000.00"}
+	ids := DeriveIdentifiers(sample.RequestId)
+	if err := checkSchemeText("remittance reference", r.expand(r.Reference, sample, ids.EndToEndId), 1, OB_MAX_IDENTIFICATION); err != nil {
+		return errors.New(err.Error() + ". Shorten the template or use fewer placeholders.")
+	}
+	return checkText("remittance information", r.expand(r.Unstructured, sample, ids.EndToEndId), 0, OB_MAX_UNSTRUCTURED)
+}
+
+// Identifiers returns the identifiers and remittance information of a request's payment.
+// They are derived from the request, so the consent and the payment always carry the same ones.
+func (r *Remittance) Identifiers(authRequest *bank.PaymentAuthRequest) (bank.PaymentIdentifiers, string) {
+	ids := DeriveIdentifiers(authRequest.RequestId)
+	ids.Reference = truncate(r.expand(r.Reference, authRequest, ids.EndToEndId), OB_MAX_IDENTIFICATION)
+	return ids, truncate(r.expand(r.Unstructured, authRequest, ids.EndToEndId), OB_MAX_UNSTRUCTURED)
+}
+
+func (r *Remittance) expand(template string, authRequest *bank.PaymentAuthRequest, endToEndId string) string {
+	shortId := authRequest.RequestId
+	if len(shortId) > 8 {
+		shortId = shortId[:8]
+	}
+	return strings.NewReplacer(
+		PH_REQUEST_ID, authRequest.RequestId,
+		PH_SHORT_ID, shortId,
+		PH_END_TO_END_ID, endToEndId,
+		PH_AMOUNT, authRequest.Amount,
+	).Replace(template)
+}
+
+// DeriveIdentifiers returns the instruction and end-to-end identifiers of a request's payment,
+// i.e. the prefix and a truncated hash of the request ID, within the OB length limit
+func DeriveIdentifiers(requestId string) bank.PaymentIdentifiers {
+	return bank.PaymentIdentifiers{
+		InstructionId: deriveIdentifier("instruction", requestId),
+		EndToEndId:    deriveIdentifier("end-to-end", requestId),
+	}
+}
+
+func deriveIdentifier(kind string, requestId string) string {
+	h := sha256.Sum256([]byte(kind + "|" + requestId))
+	return truncate(IDENTIFIER_PREFIX+hex.EncodeToString(h[:]), OB_MAX_IDENTIFICATION)
+}
+
+func truncate(v string, max int) string {
+	r := []rune(v)
+	if len(r) > max {
+		return string(r[:max])
+	}
+	return v
+}
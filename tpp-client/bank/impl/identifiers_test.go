@@ -0,0 +1,77 @@
+package bank_impl_test
+
+import (
+	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestDeriveIdentifiers_UniquePerRequest(t *testing.T) {
+
+	// arrange
+	reqA := strings.Repeat("a", 64)
+	reqB := strings.Repeat("b", 64)
+
+	// act
+	idsA := bank_impl.DeriveIdentifiers(reqA)
+	idsB := bank_impl.DeriveIdentifiers(reqB)
+
+	// assert
+	assert.Equal(t, idsA, bank_impl.DeriveIdentifiers(reqA))
+	assert.NotEqual(t, idsA.InstructionId, idsB.InstructionId)
+	assert.NotEqual(t, idsA.EndToEndId, idsB.EndToEndId)
+	assert.NotEqual(t, idsA.InstructionId, idsA.EndToEndId)
+	assert.Len(t, idsA.EndToEndId, bank_impl.OB_MAX_IDENTIFICATION)
+	assert.True(t, strings.HasPrefix(idsA.EndToEndId, bank_impl.IDENTIFIER_PREFIX))
+}
+
+func TestRemittance_Identifiers(t *testing.T) {
+
+	// arrange
+	authReq := newAuthRequest()
+	remittance := &bank_impl.Remittance{
+		Reference:    "Mint {shortId}",
+		Unstructured: "Mint of {amount} GBP, request {requestId}",
+	}
+
+	// act
+	ids, unstructured := remittance.Identifiers(authReq)
+
+	// assert
+	assert.Equal(t, "Mint "+authReq.RequestId[:8], ids.Reference)
+	assert.Equal(t, "Mint of "+authReq.Amount+" GBP, request "+authReq.RequestId, unstructured)
+	assert.Equal(t, bank_impl.DeriveIdentifiers(authReq.RequestId).EndToEndId, ids.EndToEndId)
+	initiation := newInitiation(authReq)
+	assert.NoError(t, initiation.Validate())
+}
+
+func TestRemittance_Validate(t *testing.T) {
+	cases := []struct {
+		name       string
+		remittance bank_impl.Remittance
+		err        string
+	}{
+		{name: "default", remittance: *bank_impl.DefaultRemittance()},
+		{name: "end-to-end id", remittance: bank_impl.Remittance{Reference: "{endToEndId}"}},
+		{name: "reference too long", remittance: bank_impl.Remittance{Reference: "Provable GBP {requestId}"}, err: "remittance reference"},
+		{name: "reference charset", remittance: bank_impl.Remittance{Reference: "Mint #{shortId}"}, err: "remittance reference"},
+		{name: "empty reference", remittance: bank_impl.Remittance{}, err: "remittance reference"},
+		{name: "unstructured too long", remittance: bank_impl.Remittance{Reference: "{shortId}", Unstructured: strings.Repeat("{requestId}", 3)}, err: "remittance information"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			err := c.remittance.Validate()
+
+			// assert
+			if c.err == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), c.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package bank_impl
+
+import (
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// roundTripFunc adapts a function to an http.RoundTripper
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// ChainTransport applies the decorators in order, the first one being the outermost
+func ChainTransport(decorators ...TransportDecorator) TransportDecorator {
+	return func(next http.RoundTripper) http.RoundTripper {
+		for i := len(decorators) - 1; i >= 0; i-- {
+			next = decorators[i](next)
+		}
+		return next
+	}
+}
+
+// DefaultMiddleware of the bank clients: interaction IDs and request logging
+func DefaultMiddleware(l *zap.SugaredLogger) TransportDecorator {
+	return ChainTransport(PropagateInteractionId(l), LogRequests(l))
+}
+
+// PropagateInteractionId sets an interaction ID on the requests without one, and warns if the ASPSP echoes another
+func PropagateInteractionId(l *zap.SugaredLogger) TransportDecorator {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			id := req.Header.Get(FAPI_INTERACTION_ID)
+			if id == "" {
+				id = uuid.New().String()
+				req = req.Clone(req.Context())
+				req.Header.Set(FAPI_INTERACTION_ID, id)
+			}
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				return nil, err
+			}
+			if echoed := resp.Header.Get(FAPI_INTERACTION_ID); echoed != "" && echoed != id {
+				l.Warnw("Bank returned a different interaction ID",
+					"url", redact(req.URL.String()),
+					"interactionId", id,
+					"echoedInteractionId", echoed)
+			}
+			return resp, nil
+		})
+	}
+}
+
+// LogRequests logs every bank call at debug level, with its secrets redacted, i.e. tokens, client credentials and
+// consent codes in headers, query strings and bodies. Bodies are only read if debug logging is enabled.
+func LogRequests(l *zap.SugaredLogger) TransportDecorator {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if !l.Desugar().Core().Enabled(zapcore.DebugLevel) {
+				return next.RoundTrip(req)
+			}
+
+			req = req.Clone(req.Context())
+			reqBody, err := readBody(&req.Body)
+			if err != nil {
+				return nil, err
+			}
+			url := redact(req.URL.String())
+			l.Debugw("Bank request",
+				"method", req.Method,
+				"url", url,
+				"interactionId", req.Header.Get(FAPI_INTERACTION_ID),
+				"headers", redactHeader(req.Header),
+				"body", redact(string(reqBody)))
+
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			latency := time.Since(start)
+			if err != nil {
+				l.Debugw("Bank request failed",
+					"method", req.Method,
+					"url", url,
+					"latency", latency,
+					"error", err)
+				return nil, err
+			}
+			respBody, err := readBody(&resp.Body)
+			if err != nil {
+				return nil, err
+			}
+			l.Debugw("Bank response",
+				"method", req.Method,
+				"url", url,
+				"status", resp.StatusCode,
+				"latency", latency,
+				"interactionId", resp.Header.Get(FAPI_INTERACTION_ID),
+				"headers", redactHeader(resp.Header),
+				"body", redact(string(respBody)))
+			return resp, nil
+		})
+	}
+}
+
+// CallMetrics records the outcome and latency of the bank calls
+type CallMetrics interface {
+
+	// Observe a call to the endpoint. Status is 0 for calls without a response.
+	Observe(endpoint string, status int, latency time.Duration)
+}
+
+// RecordMetrics observes every bank call, by method and path. IDs in the path are replaced by `{id}`.
+func RecordMetrics(m CallMetrics) TransportDecorator {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			status := 0
+			if err == nil {
+				status = resp.StatusCode
+			}
+			m.Observe(req.Method+" "+EndpointOf(req.URL.Path), status, time.Since(start))
+			return resp, err
+		})
+	}
+}
+
+// idSegment matches the path segments which are resource IDs, rather than names or versions like `v3.1`
+var idSegment = regexp.MustCompile(`^[A-Za-z0-9_-]*[0-9][A-Za-z0-9_-]*$`)
+
+// EndpointOf returns the path with its resource IDs replaced, so that calls to the same endpoint are grouped
+func EndpointOf(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if len(s) >= 8 && idSegment.MatchString(s) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// LatencyStats of the calls to an endpoint
+type LatencyStats struct {
+	Calls int
+	// Errors are the calls without a response or with an error status
+	Errors int
+	Total  time.Duration
+	Max    time.Duration
+}
+
+// Mean latency of the calls
+func (s LatencyStats) Mean() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Calls)
+}
+
+// InMemoryMetrics non-persistent implementation of CallMetrics, keeping totals per endpoint
+type InMemoryMetrics struct {
+	lock  sync.Mutex
+	stats map[string]LatencyStats
+}
+
+func NewInMemoryMetrics() *InMemoryMetrics {
+	return &InMemoryMetrics{stats: make(map[string]LatencyStats)}
+}
+
+func (m *InMemoryMetrics) Observe(endpoint string, status int, latency time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s := m.stats[endpoint]
+	s.Calls++
+	if status == 0 || status >= 400 {
+		s.Errors++
+	}
+	s.Total += latency
+	if latency > s.Max {
+		s.Max = latency
+	}
+	m.stats[endpoint] = s
+}
+
+// Snapshot returns a copy of the stats, by endpoint
+func (m *InMemoryMetrics) Snapshot() map[string]LatencyStats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	snapshot := make(map[string]LatencyStats, len(m.stats))
+	for k, v := range m.stats {
+		snapshot[k] = v
+	}
+	return snapshot
+}
@@ -0,0 +1,185 @@
+package bank_impl_test
+
+import (
+	"fmt"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	bank_mock "github.com/sgerogia/sol-stablecoin/tpp-client/bank/mock"
+	test_util "github.com/sgerogia/sol-stablecoin/tpp-client/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLogRequests_MockAspsp_Redacted(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.SetPaymentStatuses(bank.PAYMENT_ACCEPTED_SETTLEMENT_COMPLETED)
+	info := test_util.MockAspspInfo()
+	aspsp.ClientId = info.ClientId
+	aspsp.ClientSecret = info.ClientSecret
+	core, logs := observer.New(zap.DebugLevel)
+	client := bank_impl.NewNatwestClient(5, aspsp.Endpoints(), &bank.OauthClientCreds{
+		ClientId:        info.ClientId,
+		ClientSecret:    info.ClientSecret,
+		RedirectionUrl:  info.RedirectUrl,
+		ReducedSecurity: true,
+	}, zap.New(core).Sugar())
+
+	// act
+	flow := runPaymentFlow(t, client)
+	token, err := client.GetPaymentAuthAccessToken("req")
+	require.NoError(t, err)
+
+	// assert: every call is logged, without its secrets
+	assert.Len(t, logs.FilterMessage("Bank request").All(), 7)
+	responses := logs.FilterMessage("Bank response").All()
+	assert.Len(t, responses, 7)
+	for _, r := range responses {
+		assert.Contains(t, r.ContextMap(), "latency")
+		assert.NotEmpty(t, r.ContextMap()["interactionId"])
+	}
+	all := fmt.Sprint(logs.AllUntimed())
+	assert.NotContains(t, all, info.ClientSecret)
+	assert.NotContains(t, all, token.Token)
+	assert.NotContains(t, all, flow.granted.ConsentCode)
+	assert.NotContains(t, all, flow.paymResp.ConsentToken)
+	assert.Contains(t, all, flow.paymResp.PaymentId)
+}
+
+func TestLogRequests_SkipsBodiesWithoutDebug(t *testing.T) {
+
+	// arrange
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	core, logs := observer.New(zap.InfoLevel)
+	cl := http.Client{Transport: bank_impl.LogRequests(zap.New(core).Sugar())(http.DefaultTransport)}
+
+	// act
+	resp, err := cl.Get(server.URL)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Zero(t, logs.Len())
+}
+
+func TestPropagateInteractionId(t *testing.T) {
+	cases := []struct {
+		name    string
+		set     string
+		echo    func(id string) string
+		expWarn int
+	}{
+		{name: "generated and echoed", echo: func(id string) string { return id }},
+		{name: "caller's and echoed", set: "caller-id", echo: func(id string) string { return id }},
+		{name: "not echoed", echo: func(id string) string { return "" }},
+		{name: "echoed another", echo: func(id string) string { return "other-id" }, expWarn: 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			var received string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = r.Header.Get(bank_impl.FAPI_INTERACTION_ID)
+				if echo := c.echo(received); echo != "" {
+					w.Header().Set(bank_impl.FAPI_INTERACTION_ID, echo)
+				}
+			}))
+			defer server.Close()
+			core, logs := observer.New(zap.InfoLevel)
+			cl := http.Client{Transport: bank_impl.PropagateInteractionId(zap.New(core).Sugar())(http.DefaultTransport)}
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			require.NoError(t, err)
+			if c.set != "" {
+				req.Header.Set(bank_impl.FAPI_INTERACTION_ID, c.set)
+			}
+
+			// act
+			_, err = cl.Do(req)
+
+			// assert
+			require.NoError(t, err)
+			assert.NotEmpty(t, received)
+			if c.set != "" {
+				assert.Equal(t, c.set, received)
+			}
+			assert.Equal(t, c.expWarn, logs.FilterMessage("Bank returned a different interaction ID").Len())
+		})
+	}
+}
+
+func TestRecordMetrics_MockAspsp(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.SetPaymentStatuses(bank.PAYMENT_ACCEPTED_SETTLEMENT_COMPLETED)
+	client := newMockClient(aspsp)
+	metrics := bank_impl.NewInMemoryMetrics()
+	client.(*bank_impl.NatwestSandboxClient).SetTransport(bank_impl.RecordMetrics(metrics))
+
+	// act
+	runPaymentFlow(t, client)
+
+	// assert: calls are grouped by endpoint
+	stats := metrics.Snapshot()
+	assert.Equal(t, 2, stats["POST "+bank_mock.TOKEN_PATH].Calls)
+	assert.Equal(t, 2, stats["GET "+bank_mock.AUTHORIZE_PATH].Calls)
+	status := stats["GET "+bank_mock.PAYMENT_PATH+"/{id}"]
+	assert.Equal(t, 1, status.Calls)
+	assert.Zero(t, status.Errors)
+	assert.Positive(t, status.Max)
+	assert.Equal(t, status.Total, status.Mean())
+}
+
+func TestEndpointOf(t *testing.T) {
+	cases := []struct {
+		path string
+		exp  string
+	}{
+		{path: "/token", exp: "/token"},
+		{path: "/open-banking/v3.1/pisp/domestic-payments", exp: "/open-banking/v3.1/pisp/domestic-payments"},
+		{path: "/open-banking/v3.1/pisp/domestic-payments/payment-03ecb4d9", exp: "/open-banking/v3.1/pisp/domestic-payments/{id}"},
+		{path: "/open-banking/v3.1/aisp/accounts/12345678/transactions", exp: "/open-banking/v3.1/aisp/accounts/{id}/transactions"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.exp, bank_impl.EndpointOf(c.path), c.path)
+	}
+}
+
+func TestChainTransport_Order(t *testing.T) {
+
+	// arrange
+	var order []string
+	tag := func(name string) bank_impl.TransportDecorator {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTrip(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	cl := http.Client{Transport: bank_impl.ChainTransport(tag("outer"), tag("inner"))(http.DefaultTransport)}
+
+	// act
+	_, err := cl.Get(server.URL)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"outer", "inner"}, order)
+}
+
+type roundTrip func(req *http.Request) (*http.Response, error)
+
+func (f roundTrip) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -3,15 +3,14 @@ package bank_impl
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	resty "github.com/go-resty/resty/v2"
-	"github.com/google/uuid"
 	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
 	"go.uber.org/zap"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
-	tmpl "text/template"
+	"sync"
 	"time"
 )
 
@@ -20,62 +19,163 @@ const CREATE_PAYMENT_CONSENT = "https://ob.sandbox.natwest.com/open-banking/v3.1
 const CREATE_AUTH_URL = "https://api.sandbox.natwest.com/authorize"
 const EXECUTE_PAYMENT = "https://api.sandbox.natwest.com/open-banking/v3.1/pisp/domestic-payments"
 const CHECK_PAYMENT = "https://api.sandbox.natwest.com/open-banking/v3.1/pisp/domestic-payments/"
+const AUTH_ISSUER = "https://api.sandbox.natwest.com"
+const ACCOUNT_ACCESS_CONSENT = "https://ob.sandbox.natwest.com/open-banking/v3.1/aisp/account-access-consents"
+const ACCOUNTS = "https://ob.sandbox.natwest.com/open-banking/v3.1/aisp/accounts"
+
+// NatwestEndpoints are the ASPSP URLs used by the client.
+// Pointing them to a different host allows the client to talk to any ASPSP following the Natwest API layout,
+// e.g. a local mock server.
+type NatwestEndpoints struct {
+	OauthToken           string
+	CreatePaymentConsent string
+	CreateAuthUrl        string
+	ExecutePayment       string
+	// CheckPayment is the prefix to which the payment ID is appended
+	CheckPayment string
+	// Issuer of the authorisation server, i.e. the audience of the request objects
+	Issuer string
+	// AccountAccessConsent and Accounts are the AISP endpoints
+	AccountAccessConsent string
+	Accounts             string
+}
+
+// NatwestSandboxEndpoints returns the endpoints of the Natwest OB sandbox
+func NatwestSandboxEndpoints() *NatwestEndpoints {
+	return &NatwestEndpoints{
+		OauthToken:           OAUTH_TOKEN,
+		CreatePaymentConsent: CREATE_PAYMENT_CONSENT,
+		CreateAuthUrl:        CREATE_AUTH_URL,
+		ExecutePayment:       EXECUTE_PAYMENT,
+		CheckPayment:         CHECK_PAYMENT,
+		Issuer:               AUTH_ISSUER,
+		AccountAccessConsent: ACCOUNT_ACCESS_CONSENT,
+		Accounts:             ACCOUNTS,
+	}
+}
+
+const PAYMENTS_SCOPE = "payments"
 
-const NATWEST_SETTLED_STATUS = "AcceptedSettlementCompleted"
+// REDUCED_SECURITY_SIGNATURE is accepted by the Natwest sandbox in place of a JWS
+const REDUCED_SECURITY_SIGNATURE = "IGNORED_DUE_TO_REDUCED_SECURITY"
 
 type NatwestSandboxClient struct {
 	client           *resty.Client
 	noRedirectClient *resty.Client
 	clientCreds      *bank.OauthClientCreds
-	l                *zap.SugaredLogger
-	consentTmpl      *tmpl.Template
-	paymentTmpl      *tmpl.Template
+	endpoints        *NatwestEndpoints
+	tokens           *TokenCache
+	paymentTokens    *paymentTokens
+	// statusClientCredentials allows checking payment statuses with a client_credentials token
+	statusClientCredentials bool
+	retry                   *RetryPolicy
+	signer                  *bank.JwsSigner
+	// signerErr fails the requests needing a signature, if there is no usable signing key
+	signerErr    error
+	responseKeys bank.JwsKeyResolver
+	remittance   *Remittance
+	// financialId of the ASPSP, sent as x-fapi-financial-id if set
+	financialId string
+	l           *zap.SugaredLogger
+	// accountIds caches the ASPSP's IDs of our accounts, keyed by sort code and account number
+	accountIds sync.Map
 }
 
+// NewNatwestSandboxClient returns a client connected to the Natwest OB sandbox
 func NewNatwestSandboxClient(
 	timeout int,
 	creds *bank.OauthClientCreds,
 	_l *zap.SugaredLogger) bank.OpenBankingClient {
 
+	return NewNatwestClient(timeout, NatwestSandboxEndpoints(), creds, _l)
+}
+
+// NewNatwestClient returns a client connected to the given endpoints
+func NewNatwestClient(
+	timeout int,
+	endpoints *NatwestEndpoints,
+	creds *bank.OauthClientCreds,
+	_l *zap.SugaredLogger) bank.OpenBankingClient {
+
+	transport := DefaultMiddleware(_l)(NewTlsTransport(creds))
 	clRedir := http.Client{
-		Timeout: time.Duration(timeout) * time.Second,
+		Timeout:   time.Duration(timeout) * time.Second,
+		Transport: transport,
 	}
 	clNoRedir := http.Client{
-		Timeout: time.Duration(timeout) * time.Second,
+		Timeout:   time.Duration(timeout) * time.Second,
+		Transport: transport,
 	}
+	// sign requests if we have a signing key. Without one, requests fail unless in the sandbox's reduced security mode.
+	var signer *bank.JwsSigner
+	var signerErr error
+	if creds.SigningCert.PrivateKey != nil {
+		if signer, signerErr = bank.NewJwsSigner(&creds.SigningCert, creds.SigningKeyId, creds.JwsIssuer); signerErr != nil {
+			signerErr = errors.New("Invalid signing certificate: " + signerErr.Error())
+			_l.Errorw("Invalid signing certificate. Signed requests will fail", "error", signerErr)
+		}
+	} else if !creds.ReducedSecurity {
+		signerErr = errors.New("No signing certificate, and reduced security is not enabled")
+	}
+
 	return &NatwestSandboxClient{
 		clientCreds:      creds,
+		signer:           signer,
+		signerErr:        signerErr,
+		endpoints:        endpoints,
+		tokens:           NewTokenCache(TOKEN_EXPIRY_MARGIN),
+		paymentTokens:    newPaymentTokens(),
+		retry:            DefaultRetryPolicy(),
+		remittance:       DefaultRemittance(),
 		client:           resty.NewWithClient(&clRedir).SetRedirectPolicy(resty.FlexibleRedirectPolicy(15)),
 		noRedirectClient: resty.NewWithClient(&clNoRedir).SetRedirectPolicy(resty.NoRedirectPolicy()),
 		l:                _l,
-		consentTmpl:      tmpl.Must(tmpl.New("consent").Parse(CONSENT_PAYLOAD)),
-		paymentTmpl:      tmpl.Must(tmpl.New("payment").Parse(PAYMENT_PAYLOAD)),
 	}
 }
 
-// GetPaymentAuthAccessToken generates an Oauth2 token based on the client's credentials
+// GetPaymentAuthAccessToken returns an Oauth2 token based on the client's credentials.
+// Tokens are cached per client and scope until shortly before they expire.
 func (c *NatwestSandboxClient) GetPaymentAuthAccessToken(requestId string) (*bank.AccessToken, error) {
 
 	c.l.Infow("PaymentAuthAccess token request",
 		"reqId", requestId)
-	resp, err := c.client.R().
-		SetHeader("Accept", "application/json").
-		SetHeader("Content-Type", "application/x-www-form-urlencoded").
-		SetBody(fmt.Sprintf("grant_type=client_credentials&client_id=%s&client_secret=%s&scope=payments",
-			c.clientCreds.ClientId,
-			c.clientCreds.ClientSecret)).
-		Post(OAUTH_TOKEN)
 
-	if err != nil {
+	return c.tokens.Get(c.clientCreds.ClientId, PAYMENTS_SCOPE, func() (*bank.AccessToken, error) {
+		return c.fetchClientCredentialsToken(requestId, PAYMENTS_SCOPE)
+	})
+}
+
+// fetchClientCredentialsToken requests a new client_credentials token from the ASPSP
+func (c *NatwestSandboxClient) fetchClientCredentialsToken(requestId string, scope string) (*bank.AccessToken, error) {
+
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"scope":      {scope},
+	}
+	if err := addClientAuth(form, c.clientCreds, c.signer, c.endpoints.OauthToken); err != nil {
 		return nil, err
 	}
 
-	c.l.Debugw("Access token response",
-		"reqId", requestId,
-		"status", resp.StatusCode())
+	var resp *resty.Response
+	err := c.retry.Do("access token request", c.l, func() error {
+		var err error
+		resp, err = c.client.R().
+			SetHeader("Accept", "application/json").
+			SetHeader("Content-Type", "application/x-www-form-urlencoded").
+			SetBody(form.Encode()).
+			Post(c.endpoints.OauthToken)
+
+		if err != nil {
+			return bank.NewTransportError("access token request", err)
+		}
 
-	if resp.StatusCode() != http.StatusOK {
-		return nil, errors.New(fmt.Sprintf("Failed access token request. Status %d", resp.StatusCode()))
+		if resp.StatusCode() != http.StatusOK {
+			return bank.NewBankError("access token request", resp.StatusCode(), resp.Body(), resp.Header())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	var atResp AccessTokenResponse
@@ -83,11 +183,6 @@ func (c *NatwestSandboxClient) GetPaymentAuthAccessToken(requestId string) (*ban
 		return nil, err
 	}
 
-	c.l.Debugw("Access token",
-		"reqId", requestId,
-		"accessToken", atResp.AccessToken,
-		"expires", atResp.ExpiresIn)
-
 	var at = bank.AccessToken{
 		Token:     atResp.AccessToken,
 		ExpiresIn: atResp.ExpiresIn,
@@ -105,40 +200,54 @@ func (c *NatwestSandboxClient) CreatePaymentAuthRequest(
 		"reqId", authRequest.RequestId)
 
 	// build the payload
-	var p strings.Builder
-	d := consentData{
-		AuthRequest: authRequest,
-		Beneficiary: beneficiary,
-	}
-	if err := c.consentTmpl.Execute(&p, d); err != nil {
+	ids, unstructured := c.remittance.Identifiers(authRequest)
+	body, err := marshalValid("payment auth request", &OBWriteDomesticConsent{
+		Data: OBWriteDomesticConsentData{
+			Initiation: NewDomesticInitiation(authRequest, beneficiary, ids, unstructured),
+		},
+		Risk: OBRisk{PaymentContextCode: PAYMENT_CONTEXT_SERVICES},
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	c.l.Debugw("Payment auth payload",
-		"reqId", authRequest.RequestId,
-		"body", p.String())
-
-	// make the call
-	resp, err := c.client.R().
-		SetHeader("Accept", "application/json").
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Authorization", "Bearer "+access.Token).
-		SetHeader("x-jws-signature", "IGNORED_DUE_TO_REDUCED_SECURITY").
-		SetHeader("x-idempotency-key", uuid.New().String()).
-		SetBody(p.String()).
-		Post(CREATE_PAYMENT_CONSENT)
-
+	sig, err := c.jwsSignature(body)
 	if err != nil {
 		return nil, err
 	}
 
-	c.l.Debugw("Payment auth response",
-		"reqId", authRequest.RequestId,
-		"status", resp.StatusCode())
+	// make the call. Retries reuse the idempotency key, so the ASPSP creates a single consent.
+	idempotencyKey := IdempotencyKey(authRequest.RequestId, OP_CREATE_CONSENT)
+	var resp *resty.Response
+	err = c.retry.Do("payment auth request", c.l, func() error {
+		var err error
+		resp, err = c.fapiRequest(authRequest.RequestId).
+			SetHeader("Accept", "application/json").
+			SetHeader("Content-Type", "application/json").
+			SetHeader("Authorization", "Bearer "+access.Token).
+			SetHeader(bank.JWS_SIGNATURE_HDR, sig).
+			SetHeader("x-idempotency-key", idempotencyKey).
+			SetBody(body).
+			Post(c.endpoints.CreatePaymentConsent)
+
+		if err != nil {
+			return bank.NewTransportError("payment auth request", err)
+		}
 
-	if resp.StatusCode() != http.StatusCreated {
-		return nil, errors.New(fmt.Sprintf("Failed payment auth request. Status %d. Body: %s",
-			resp.StatusCode(), string(resp.Body())))
+		if resp.StatusCode() == http.StatusUnauthorized {
+			// the cached token was revoked or expired early. Next call fetches a new one.
+			c.tokens.Invalidate(c.clientCreds.ClientId, PAYMENTS_SCOPE)
+		}
+		if resp.StatusCode() != http.StatusCreated {
+			return bank.NewBankError("payment auth request", resp.StatusCode(), resp.Body(), resp.Header())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = c.verifyResponseSignature(resp); err != nil {
+		return nil, err
 	}
 
 	// extract consent
@@ -149,40 +258,38 @@ func (c *NatwestSandboxClient) CreatePaymentAuthRequest(
 
 	data := pauthResp["Data"].(map[string]interface{})
 	consent := data["ConsentId"].(string)
-	c.l.Debugw("Consent received",
-		"reqId", authRequest.RequestId,
-		"consent", consent)
-
+	interactionId := echoedInteractionId(resp)
 	// generate authorisation URL
+	params, state, nonce, err := c.authorizeParams(consent)
+	if err != nil {
+		return nil, err
+	}
 	resp, err = c.noRedirectClient.R().
 		SetHeader("Accept", "application/json").
 		SetHeader("Content-Type", "application/json").
 		SetHeader("Authorization", "Bearer "+access.Token).
-		SetHeader("x-jws-signature", "IGNORED_DUE_TO_REDUCED_SECURITY").
-		SetHeader("x-idempotency-key", uuid.New().String()).
-		SetQueryParam("client_id", c.clientCreds.ClientId).
-		SetQueryParam("response_type", "code id_token").
-		SetQueryParam("scope", "openid payments").
-		SetQueryParam("redirect_uri", "https://display-parameters.com/").
-		SetQueryParam("request", consent).
-		Get(CREATE_AUTH_URL)
+		SetQueryParamsFromValues(params).
+		Get(c.endpoints.CreateAuthUrl)
 
 	if err != nil && !strings.Contains(err.Error(), "auto redirect is disabled") {
-		return nil, err
+		return nil, bank.NewTransportError("create auth URL request", err)
 	}
 
-	c.l.Debugw("Create auth URL response",
-		"reqId", authRequest.RequestId,
-		"status", resp.StatusCode())
-
 	if resp.StatusCode() != http.StatusFound {
-		return nil, errors.New(fmt.Sprintf("Failed create auth URL request. Status %d. Body: %s",
-			resp.StatusCode(), string(resp.Body())))
+		return nil, bank.NewBankError("create auth URL request", resp.StatusCode(), resp.Body(), resp.Header())
 	}
 
 	location := resp.Header().Get("location")
 
-	return &bank.PaymentAuthResponse{RequestId: authRequest.RequestId, Url: location, ConsentId: consent}, nil
+	return &bank.PaymentAuthResponse{
+		RequestId:     authRequest.RequestId,
+		Url:           location,
+		ConsentId:     consent,
+		State:         state,
+		Nonce:         nonce,
+		Identifiers:   ids,
+		InteractionId: interactionId,
+	}, nil
 }
 
 // SubmitPayment exchanges the approved consent code for a token and submits the authorised payment to the bank
@@ -191,70 +298,58 @@ func (c *NatwestSandboxClient) SubmitPayment(
 	paymentAuthRequest *bank.PaymentAuthRequest,
 	beneficiary *bank.AccountDetails) (*bank.SubmitPaymentResponse, error) {
 
-	c.l.Debugw("Exchange consent code",
-		"requestId", authGranted.RequestId)
-
-	// 1) exchange consent code for token
-	resp, err := c.client.R().
-		SetHeader("Accept", "application/json").
-		SetHeader("Content-Type", "application/x-www-form-urlencoded").
-		SetBody(fmt.Sprintf("client_id=%s&client_secret=%s&redirect_uri=%s&grant_type=authorization_code&code=%s",
-			c.clientCreds.ClientId,
-			c.clientCreds.ClientSecret,
-			c.clientCreds.RedirectionUrl,
-			authGranted.ConsentCode)).
-		Post(OAUTH_TOKEN)
-
+	// build the payload. An invalid one fails before the consent code is used.
+	ids, unstructured := c.remittance.Identifiers(paymentAuthRequest)
+	body, err := marshalValid("submit payment", &OBWriteDomestic{
+		Data: OBWriteDomesticData{
+			ConsentId:  authGranted.ConsentId,
+			Initiation: NewDomesticInitiation(paymentAuthRequest, beneficiary, ids, unstructured),
+		},
+		Risk: OBRisk{PaymentContextCode: PAYMENT_CONTEXT_SERVICES},
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	c.l.Debugw("Exchange consent code response",
-		"reqId", paymentAuthRequest.RequestId,
-		"status", resp.StatusCode())
-
-	if resp.StatusCode() != http.StatusOK {
-		return nil, errors.New(fmt.Sprintf("Failed exchange consent code. Status %d. Body %s", resp.StatusCode(), resp.Body()))
-	}
-
-	// extract acc. token
-	var exchResp map[string]interface{}
-	if err = json.Unmarshal(resp.Body(), &exchResp); err != nil {
+	// 1) get the token of the granted consent
+	accessToken, err := c.consentToken(authGranted)
+	if err != nil {
 		return nil, err
 	}
-	accessToken := exchResp["access_token"].(string)
 
-	// build the payload
-	var p strings.Builder
-	d := paymentData{
-		Consent:            authGranted.ConsentId,
-		PaymentAuthRequest: paymentAuthRequest,
-		Beneficiary:        beneficiary,
-	}
-	if err := c.paymentTmpl.Execute(&p, d); err != nil {
+	sig, err := c.jwsSignature(body)
+	if err != nil {
 		return nil, err
 	}
 
-	// 2) submit the payment
-	resp, err = c.client.R().
-		SetHeader("Accept", "application/json").
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Authorization", "Bearer "+accessToken).
-		SetHeader("x-jws-signature", "IGNORED_DUE_TO_REDUCED_SECURITY").
-		SetHeader("x-idempotency-key", uuid.New().String()).
-		SetBody(p.String()).
-		Post(EXECUTE_PAYMENT)
+	// 2) submit the payment. Retries reuse the idempotency key, so the ASPSP executes it only once.
+	idempotencyKey := IdempotencyKey(paymentAuthRequest.RequestId, OP_SUBMIT_PAYMENT)
+	var resp *resty.Response
+	err = c.retry.Do("submit payment", c.l, func() error {
+		var err error
+		resp, err = c.psuRequest(authGranted).
+			SetHeader("Accept", "application/json").
+			SetHeader("Content-Type", "application/json").
+			SetHeader("Authorization", "Bearer "+accessToken).
+			SetHeader(bank.JWS_SIGNATURE_HDR, sig).
+			SetHeader("x-idempotency-key", idempotencyKey).
+			SetBody(body).
+			Post(c.endpoints.ExecutePayment)
+
+		if err != nil {
+			return bank.NewTransportError("submit payment", err)
+		}
 
+		if resp.StatusCode() != http.StatusCreated {
+			return bank.NewBankError("submit payment", resp.StatusCode(), resp.Body(), resp.Header())
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	c.l.Debugw("Submit payment response",
-		"reqId", paymentAuthRequest.RequestId,
-		"status", resp.StatusCode())
-
-	if resp.StatusCode() != http.StatusCreated {
-		return nil, errors.New(fmt.Sprintf("Failed submit payment. Status %d. Body %s", resp.StatusCode(), resp.Body()))
+	if err = c.verifyResponseSignature(resp); err != nil {
+		return nil, err
 	}
 
 	// extract payment id
@@ -269,75 +364,296 @@ func (c *NatwestSandboxClient) SubmitPayment(
 		"paymentId", paymentId)
 
 	return &bank.SubmitPaymentResponse{
-		RequestId:    paymentAuthRequest.RequestId,
-		ConsentCode:  authGranted.ConsentCode,
-		ConsentToken: accessToken,
-		PaymentId:    paymentId,
+		RequestId:     paymentAuthRequest.RequestId,
+		ConsentCode:   authGranted.ConsentCode,
+		ConsentToken:  accessToken,
+		PaymentId:     paymentId,
+		Identifiers:   ids,
+		InteractionId: echoedInteractionId(resp),
+	}, nil
+}
+
+// ConfirmFunds checks the availability of funds for a granted consent, using the consent's access token
+func (c *NatwestSandboxClient) ConfirmFunds(authGranted *bank.PaymentAuthGranted) (*bank.FundsConfirmationResponse, error) {
+
+	c.l.Debugw("Confirm funds",
+		"requestId", authGranted.RequestId,
+		"consent", authGranted.ConsentId)
+
+	accessToken, err := c.consentToken(authGranted)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *resty.Response
+	err = c.retry.Do("confirm funds", c.l, func() error {
+		var err error
+		resp, err = c.psuRequest(authGranted).
+			SetHeader("Accept", "application/json").
+			SetHeader("Authorization", "Bearer "+accessToken).
+			Get(c.endpoints.CreatePaymentConsent + "/" + authGranted.ConsentId + "/funds-confirmation")
+
+		if err != nil {
+			return bank.NewTransportError("confirm funds", err)
+		}
+		if resp.StatusCode() != http.StatusOK {
+			return bank.NewBankError("confirm funds", resp.StatusCode(), resp.Body(), resp.Header())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = c.verifyResponseSignature(resp); err != nil {
+		return nil, err
+	}
+
+	var fResp struct {
+		Data struct {
+			FundsAvailableResult *struct {
+				FundsAvailable *bool
+			}
+		}
+	}
+	if err = json.Unmarshal(resp.Body(), &fResp); err != nil {
+		return nil, err
+	}
+	// a missing answer is not a "no"
+	result := fResp.Data.FundsAvailableResult
+	if result == nil || result.FundsAvailable == nil {
+		return nil, malformed("confirm funds", "Data.FundsAvailableResult.FundsAvailable")
+	}
+
+	return &bank.FundsConfirmationResponse{
+		RequestId:      authGranted.RequestId,
+		ConsentId:      authGranted.ConsentId,
+		FundsAvailable: *result.FundsAvailable,
 	}, nil
 }
 
+// ForgetPayment drops the payment's tokens
+func (c *NatwestSandboxClient) ForgetPayment(requestId string) {
+	c.paymentTokens.drop(requestId)
+}
+
 // GetPaymentStatus retrieves the status of a submitted payment
 func (c *NatwestSandboxClient) GetPaymentStatus(data *bank.SubmitPaymentResponse) (*bank.PaymentStatusResponse, error) {
 
 	c.l.Debugw("Check payment status",
 		"requestId", data.RequestId)
 
-	resp, err := c.client.R().
+	token, err := c.statusToken(data, false)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.fapiRequest(data.RequestId).
 		SetHeader("Accept", "application/json").
 		SetHeader("Content-Type", "application/json").
-		SetHeader("Authorization", "Bearer "+data.ConsentToken).
-		Get(CHECK_PAYMENT + data.PaymentId)
+		SetHeader("Authorization", "Bearer "+token).
+		Get(c.endpoints.CheckPayment + data.PaymentId)
 
+	if err == nil && resp.StatusCode() == http.StatusUnauthorized {
+		// the token expired before we expected. Try once more with a new one.
+		if token, err = c.statusToken(data, true); err != nil {
+			return nil, err
+		}
+		resp, err = c.fapiRequest(data.RequestId).
+			SetHeader("Accept", "application/json").
+			SetHeader("Content-Type", "application/json").
+			SetHeader("Authorization", "Bearer "+token).
+			Get(c.endpoints.CheckPayment + data.PaymentId)
+	}
 	if err != nil {
-		return nil, err
+		return nil, bank.NewTransportError("check payment", err)
 	}
 
 	if resp.StatusCode() != http.StatusOK {
-		return nil, errors.New(fmt.Sprintf("Failed check payment. Status %d", resp.StatusCode()))
+		return nil, bank.NewBankError("check payment", resp.StatusCode(), resp.Body(), resp.Header())
+	}
+	if err = c.verifyResponseSignature(resp); err != nil {
+		return nil, err
 	}
 
-	var pResp map[string]interface{}
+	var pResp struct {
+		Data struct {
+			Status string
+		}
+	}
 	if err = json.Unmarshal(resp.Body(), &pResp); err != nil {
 		return nil, err
 	}
-	d := pResp["Data"].(map[string]interface{})
-	paymentStatus := d["Status"].(string)
+	if pResp.Data.Status == "" {
+		return nil, malformed("check payment", "Data.Status")
+	}
+	paymentStatus := bank.PaymentStatus(pResp.Data.Status)
+	if !paymentStatus.IsKnown() {
+		c.l.Warnw("Unknown payment status. Treating it as pending",
+			"requestId", data.RequestId,
+			"paymentId", data.PaymentId,
+			"status", paymentStatus)
+	}
 
 	return &bank.PaymentStatusResponse{
 		RequestId: data.RequestId,
 		PaymentId: data.PaymentId,
 		Status:    paymentStatus,
-		Settled:   NATWEST_SETTLED_STATUS == paymentStatus,
 	}, nil
 }
 
-// ApproveConsent utility function to approve the consent request. Only used for testing.
+// GetPaymentConsentStatus retrieves the status of a payment consent
+func (c *NatwestSandboxClient) GetPaymentConsentStatus(
+	consent *bank.PaymentAuthResponse,
+	access *bank.AccessToken) (*bank.PaymentConsentStatusResponse, error) {
+
+	c.l.Debugw("Check consent status",
+		"requestId", consent.RequestId,
+		"consent", consent.ConsentId)
+
+	resp, err := c.fapiRequest(consent.RequestId).
+		SetHeader("Accept", "application/json").
+		SetHeader("Authorization", "Bearer "+access.Token).
+		Get(c.endpoints.CreatePaymentConsent + "/" + consent.ConsentId)
+
+	if err != nil {
+		return nil, bank.NewTransportError("check consent", err)
+	}
+
+	if resp.StatusCode() == http.StatusUnauthorized {
+		c.tokens.Invalidate(c.clientCreds.ClientId, PAYMENTS_SCOPE)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, bank.NewBankError("check consent", resp.StatusCode(), resp.Body(), resp.Header())
+	}
+	if err = c.verifyResponseSignature(resp); err != nil {
+		return nil, err
+	}
+
+	var cResp struct {
+		Data struct {
+			Status string
+		}
+	}
+	if err = json.Unmarshal(resp.Body(), &cResp); err != nil {
+		return nil, err
+	}
+	if cResp.Data.Status == "" {
+		return nil, malformed("check consent", "Data.Status")
+	}
+
+	return &bank.PaymentConsentStatusResponse{
+		RequestId: consent.RequestId,
+		ConsentId: consent.ConsentId,
+		Status:    cResp.Data.Status,
+	}, nil
+}
+
+// SetResponseKeyResolver sets the source of the ASPSP's public keys, used to verify the response signatures.
+// If not set, response signatures are not verified.
+func (c *NatwestSandboxClient) SetResponseKeyResolver(resolver bank.JwsKeyResolver) {
+	c.responseKeys = resolver
+}
+
+// SetStatusClientCredentials allows checking payment statuses with a client_credentials token,
+// once the payment's own token has expired and cannot be refreshed. Only enable it if the ASPSP accepts them.
+func (c *NatwestSandboxClient) SetStatusClientCredentials(allow bool) {
+	c.statusClientCredentials = allow
+}
+
+// SetRetryPolicy overrides the default retry policy of the calls to the ASPSP
+func (c *NatwestSandboxClient) SetRetryPolicy(policy *RetryPolicy) {
+	c.retry = policy
+}
+
+// SetRemittance overrides the default templates of the payments' remittance information.
+// The templates are checked against the OB field rules.
+func (c *NatwestSandboxClient) SetRemittance(remittance *Remittance) error {
+	if err := remittance.Validate(); err != nil {
+		return err
+	}
+	c.remittance = remittance
+	return nil
+}
+
+// SetTransport decorates the HTTP transport of the client, e.g. to record or replay its traffic
+func (c *NatwestSandboxClient) SetTransport(decorate TransportDecorator) {
+	transport := decorate(c.client.GetClient().Transport)
+	c.client.SetTransport(transport)
+	c.noRedirectClient.SetTransport(transport)
+}
+
+// SetFinancialId sets the ASPSP's OB organisation ID, sent as x-fapi-financial-id
+func (c *NatwestSandboxClient) SetFinancialId(financialId string) {
+	c.financialId = financialId
+}
+
+// jwsSignature returns the detached JWS of a request body.
+// Without a signing key, it returns the reduced security placeholder if allowed (i.e. in the sandbox).
+func (c *NatwestSandboxClient) jwsSignature(body string) (string, error) {
+	if c.signerErr != nil {
+		return "", c.signerErr
+	}
+	if c.signer == nil {
+		return REDUCED_SECURITY_SIGNATURE, nil
+	}
+	return c.signer.SignDetached([]byte(body))
+}
+
+// verifyResponseSignature verifies the ASPSP's detached JWS of the response body, if one was provided
+func (c *NatwestSandboxClient) verifyResponseSignature(resp *resty.Response) error {
+	sig := resp.Header().Get(bank.JWS_SIGNATURE_HDR)
+	if sig == "" {
+		return nil
+	}
+	if c.responseKeys == nil {
+		c.l.Debugw("Response signature not verified, no ASPSP keys configured",
+			"url", resp.Request.URL)
+		return nil
+	}
+	if err := bank.VerifyDetached(sig, resp.Body(), c.responseKeys); err != nil {
+		return &bank.BankError{
+			Operation: "response signature verification",
+			Status:    resp.StatusCode(),
+			Class:     bank.TERMINAL,
+			Cause:     err,
+		}
+	}
+	return nil
+}
+
+// ApproveConsent approves the consent request headlessly. Sandbox only, used by the tests and the auto-approval mode.
 func (c *NatwestSandboxClient) ApproveConsent(data *bank.PaymentAuthResponse, username string) (*bank.PaymentAuthGranted, error) {
 
 	c.l.Debugw("Approve consent",
 		"requestId", data.RequestId)
 
+	// replay the authorisation request of the URL, as the PSU's browser would. Rebuild it if the URL does not carry it.
+	params := url.Values{
+		"client_id":     {c.clientCreds.ClientId},
+		"response_type": {"code id_token"},
+		"redirect_uri":  {c.clientCreds.RedirectionUrl},
+		"scope":         {"openid payments"},
+		"request":       {data.ConsentId},
+	}
+	if authUrl, err := url.Parse(data.Url); err == nil && authUrl.Query().Get("request") != "" {
+		params = authUrl.Query()
+	}
+
 	resp, err := c.client.R().
 		SetHeader("Accept", "application/json").
 		SetHeader("Content-Type", "application/json").
-		SetQueryParam("client_id", c.clientCreds.ClientId).
+		SetQueryParamsFromValues(params).
 		SetQueryParam("client_secret", c.clientCreds.ClientSecret).
-		SetQueryParam("response_type", "code id_token").
-		SetQueryParam("redirect_uri", c.clientCreds.RedirectionUrl).
-		SetQueryParam("scope", "openid payments").
-		SetQueryParam("request", data.ConsentId).
 		SetQueryParam("authorization_mode", "AUTO_POSTMAN").
 		SetQueryParam("authorization_result", "APPROVED").
 		SetQueryParam("authorization_username", username).
-		Get(CREATE_AUTH_URL)
+		Get(c.endpoints.CreateAuthUrl)
 
 	if err != nil {
-		return nil, err
+		return nil, bank.NewTransportError("approve consent", err)
 	}
 
 	if resp.StatusCode() != http.StatusOK {
-		return nil, errors.New(fmt.Sprintf("Failed approve consent. Status %d. Body %s",
-			resp.StatusCode(), string(resp.Body())))
+		return nil, bank.NewBankError("approve consent", resp.StatusCode(), resp.Body(), resp.Header())
 	}
 
 	var pResp map[string]interface{}
@@ -352,98 +668,23 @@ func (c *NatwestSandboxClient) ApproveConsent(data *bank.PaymentAuthResponse, us
 		return nil, errors.New("Failed to parse consent code. Redirect uri: " + uri)
 	}
 	consentCode := rs[1]
+	var fragment url.Values
+	if redirect, err := url.Parse(uri); err == nil {
+		fragment, _ = url.ParseQuery(redirect.Fragment)
+	}
 
 	return &bank.PaymentAuthGranted{
 		ConsentId:   data.ConsentId,
 		RequestId:   data.RequestId,
 		ConsentCode: consentCode,
+		State:       fragment.Get("state"),
+		IdToken:     fragment.Get("id_token"),
 	}, nil
 }
 
 type AccessTokenResponse struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
-	ExpiresIn   int    `json:"expires_in"`
-}
-
-type consentData struct {
-	AuthRequest *bank.PaymentAuthRequest
-	Beneficiary *bank.AccountDetails
-}
-
-const CONSENT_PAYLOAD = `
-{
-	"Data": {
-		"Initiation": {
-			"InstructionIdentification": "instr-identification",
-			"EndToEndIdentification": "e2e-identification",
-			"DebtorAccount": {
-				"SchemeName": "SortCodeAccountNumber",
-				"Identification": "{{.AuthRequest.Payer.SortCode}}{{.AuthRequest.Payer.AccountNumber}}",
-				"Name": "{{.AuthRequest.Payer.Name}}"
-			},
-			"InstructedAmount": {
-				"Amount": "{{.AuthRequest.Amount}}",
-				"Currency": "GBP"
-			},
-			"CreditorAccount": {
-				"SchemeName": "SortCodeAccountNumber",
-				"Identification": "{{.Beneficiary.SortCode}}{{.Beneficiary.AccountNumber}}",
-				"Name": "{{.Beneficiary.Name}}"
-			},
-			"RemittanceInformation": {
-				"Unstructured": "Provable GBP mint",
-				"Reference": "Provable GBP mint"
-			}
-		}
-	},
-	"Risk": {
-		"PaymentContextCode": "Services",
-		"MerchantCategoryCode": null,
-		"MerchantCustomerIdentification": null,
-		"DeliveryAddress": null
-	}
-}
-`
-
-type paymentData struct {
-	Consent            string
-	PaymentAuthRequest *bank.PaymentAuthRequest
-	Beneficiary        *bank.AccountDetails
-}
-
-const PAYMENT_PAYLOAD = `
-{
-	"Data": {
-		"ConsentId": "{{.Consent}}",
-		"Initiation": {
-			"InstructionIdentification": "instr-identification",
-			"EndToEndIdentification": "e2e-identification",
-			"DebtorAccount": {
-				"SchemeName": "SortCodeAccountNumber",
-				"Identification": "{{.PaymentAuthRequest.Payer.SortCode}}{{.PaymentAuthRequest.Payer.AccountNumber}}",
-				"Name": "{{.PaymentAuthRequest.Payer.Name}}"
-			},
-			"InstructedAmount": {
-				"Amount": "{{.PaymentAuthRequest.Amount}}",
-				"Currency": "GBP"
-			},
-			"CreditorAccount": {
-				"SchemeName": "SortCodeAccountNumber",
-				"Identification": "{{.Beneficiary.SortCode}}{{.Beneficiary.AccountNumber}}",
-				"Name": "{{.Beneficiary.Name}}"
-			},
-			"RemittanceInformation": {
-				"Unstructured": "Provable GBP mint",
-				"Reference": "Provable GBP mint"
-			}
-		}
-	},
-	"Risk": {
-		"PaymentContextCode": "Services",
-		"MerchantCategoryCode": null,
-		"MerchantCustomerIdentification": null,
-		"DeliveryAddress": null
-	}
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
 }
-`
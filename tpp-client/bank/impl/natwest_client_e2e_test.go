@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"os"
 	"testing"
 	"time"
 )
@@ -18,6 +19,7 @@ import (
 // - NATWEST_SANDBOX_CLIENT_SECRET: the client secret of the Natwest sandbox app
 // - NATWEST_SANDBOX_REDIRECT_URL: the redirect url of the Natwest sandbox app
 // - NATWEST_SANDBOX_CUSTOMER_USERNAME: the username of the customer to use for the consent flow (format: CUSTOMER_ID_OR_CUSTOMER_NUMBER@domainof.your.app)
+// Optionally, NATWEST_SANDBOX_RECORD is the fixture file to record the redacted sandbox traffic to.
 func TestNatwestSandboxClient_E2E(t *testing.T) {
 
 	// skip if env vars are not there
@@ -28,12 +30,16 @@ func TestNatwestSandboxClient_E2E(t *testing.T) {
 
 	// arrange
 	creds := bank.OauthClientCreds{
-		ClientId:       info.ClientId,
-		ClientSecret:   info.ClientSecret,
-		RedirectionUrl: info.RedirectUrl,
+		ClientId:        info.ClientId,
+		ClientSecret:    info.ClientSecret,
+		RedirectionUrl:  info.RedirectUrl,
+		ReducedSecurity: true,
 	}
 	l := zap.NewExample().Sugar()
 	client := bank_impl.NewNatwestSandboxClient(30, &creds, l)
+	if fixture := os.Getenv("NATWEST_SANDBOX_RECORD"); fixture != "" {
+		client.(*bank_impl.NatwestSandboxClient).SetTransport(bank_impl.RecordTo(fixture))
+	}
 	reqId := uuid.New().String()
 	payer := test_util.Payer()
 	receiver := test_util.Receiver()
@@ -79,8 +85,8 @@ func TestNatwestSandboxClient_E2E(t *testing.T) {
 	for n < 10 {
 		checkResp, err := client.GetPaymentStatus(paymResp)
 		require.NoError(t, err)
-		if checkResp.Settled {
-			assert.Equal(t, bank_impl.NATWEST_SETTLED_STATUS, checkResp.Status)
+		if checkResp.Status.IsTerminal() {
+			assert.Equal(t, bank.PAYMENT_ACCEPTED_SETTLEMENT_COMPLETED, checkResp.Status)
 			break
 		}
 		time.Sleep(1 * time.Second)
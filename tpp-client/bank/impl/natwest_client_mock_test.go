@@ -0,0 +1,831 @@
+package bank_impl_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"github.com/google/uuid"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	bank_mock "github.com/sgerogia/sol-stablecoin/tpp-client/bank/mock"
+	test_util "github.com/sgerogia/sol-stablecoin/tpp-client/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newMockClient(aspsp *bank_mock.AspspServer) bank.OpenBankingClient {
+	info := test_util.MockAspspInfo()
+	aspsp.ClientId = info.ClientId
+	aspsp.ClientSecret = info.ClientSecret
+	creds := bank.OauthClientCreds{
+		ClientId:        info.ClientId,
+		ClientSecret:    info.ClientSecret,
+		RedirectionUrl:  info.RedirectUrl,
+		ReducedSecurity: true,
+	}
+	client := bank_impl.NewNatwestClient(5, aspsp.Endpoints(), &creds, zap.NewExample().Sugar())
+	client.(*bank_impl.NatwestSandboxClient).SetRetryPolicy(fastRetries())
+	return client
+}
+
+func fastRetries() *bank_impl.RetryPolicy {
+	return &bank_impl.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+	}
+}
+
+func newAuthRequest() *bank.PaymentAuthRequest {
+	return &bank.PaymentAuthRequest{
+		RequestId:     uuid.New().String(),
+		InstitutionId: test_util.INSTITUTION,
+		Amount:        test_util.AMOUNT,
+		Payer:         *test_util.Payer(),
+	}
+}
+
+func TestNatwestClient_MockAspsp_SettlesAfterPending(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.SetPaymentStatuses(bank.PAYMENT_PENDING, bank.PAYMENT_ACCEPTED_SETTLEMENT_IN_PROCESS, bank.PAYMENT_ACCEPTED_SETTLEMENT_COMPLETED)
+	client := newMockClient(aspsp)
+	authReq := newAuthRequest()
+	receiver := test_util.Receiver()
+
+	// act
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, receiver)
+	require.NoError(t, err)
+	granted, err := client.(*bank_impl.NatwestSandboxClient).ApproveConsent(authResp, test_util.MockAspspInfo().CustomerUsername)
+	require.NoError(t, err)
+	paymResp, err := client.SubmitPayment(granted, authReq, receiver)
+	require.NoError(t, err)
+
+	// assert
+	assert.Contains(t, authResp.Url, aspsp.URL())
+	assert.Equal(t, authResp.ConsentId, granted.ConsentId)
+	assert.NotEmpty(t, paymResp.PaymentId)
+	for _, exp := range []bank.PaymentStatus{bank.PAYMENT_PENDING, bank.PAYMENT_ACCEPTED_SETTLEMENT_IN_PROCESS} {
+		status, err := client.GetPaymentStatus(paymResp)
+		require.NoError(t, err)
+		assert.False(t, status.Status.IsTerminal())
+		assert.Equal(t, exp, status.Status)
+	}
+	status, err := client.GetPaymentStatus(paymResp)
+	require.NoError(t, err)
+	assert.True(t, status.Status.IsSuccess())
+	assert.Equal(t, 3, aspsp.CallCount(bank_mock.PAYMENT_STATUS))
+}
+
+func TestNatwestClient_MockAspsp_RejectedConsent(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.SetApproveConsents(false)
+	client := newMockClient(aspsp)
+	authReq := newAuthRequest()
+
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, test_util.Receiver())
+	require.NoError(t, err)
+
+	// act
+	_, err = client.(*bank_impl.NatwestSandboxClient).ApproveConsent(authResp, test_util.MockAspspInfo().CustomerUsername)
+
+	// assert
+	assert.ErrorContains(t, err, "Failed to parse consent code")
+}
+
+func TestNatwestClient_MockAspsp_ScriptedFailure(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.SetBehaviour(bank_mock.TOKEN, bank_mock.Behaviour{
+		FailStatus: http.StatusServiceUnavailable,
+		FailTimes:  -1,
+	})
+	client := newMockClient(aspsp)
+
+	// act
+	_, err := client.GetPaymentAuthAccessToken("req")
+
+	// assert
+	assert.ErrorContains(t, err, "503")
+	assert.Equal(t, 3, aspsp.CallCount(bank_mock.TOKEN))
+}
+
+func TestNatwestClient_MockAspsp_RetriesConsentCreation(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.SetBehaviour(bank_mock.CONSENT, bank_mock.Behaviour{
+		FailStatus: http.StatusServiceUnavailable,
+		FailTimes:  2,
+	})
+	client := newMockClient(aspsp)
+	authReq := newAuthRequest()
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+
+	// act
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, test_util.Receiver())
+
+	// assert
+	require.NoError(t, err)
+	assert.NotEmpty(t, authResp.ConsentId)
+	assert.Equal(t, 3, aspsp.CallCount(bank_mock.CONSENT))
+}
+
+func TestNatwestClient_MockAspsp_DoesNotRetryTerminalFailure(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.SetBehaviour(bank_mock.CONSENT, bank_mock.Behaviour{
+		FailStatus: http.StatusBadRequest,
+		FailTimes:  -1,
+		FailBody:   `{"Code":"400","Errors":[{"ErrorCode":"UK.OBIE.Field.Invalid"}]}`,
+	})
+	client := newMockClient(aspsp)
+	authReq := newAuthRequest()
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+
+	// act
+	_, err = client.CreatePaymentAuthRequest(authReq, token, test_util.Receiver())
+
+	// assert
+	assert.True(t, bank.IsTerminal(err))
+	assert.Equal(t, 1, aspsp.CallCount(bank_mock.CONSENT))
+}
+
+func TestNatwestClient_MockAspsp_LostPaymentResponseSubmittedOnce(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.SetBehaviour(bank_mock.PAYMENT, bank_mock.Behaviour{
+		FailStatus:   http.StatusGatewayTimeout,
+		FailTimes:    1,
+		LoseResponse: true,
+	})
+	client := newMockClient(aspsp)
+	authReq := newAuthRequest()
+	receiver := test_util.Receiver()
+
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, receiver)
+	require.NoError(t, err)
+	granted, err := client.(*bank_impl.NatwestSandboxClient).ApproveConsent(authResp, test_util.MockAspspInfo().CustomerUsername)
+	require.NoError(t, err)
+
+	// act
+	paymResp, err := client.SubmitPayment(granted, authReq, receiver)
+
+	// assert
+	require.NoError(t, err)
+	assert.NotEmpty(t, paymResp.PaymentId)
+	assert.Equal(t, 2, aspsp.CallCount(bank_mock.PAYMENT))
+	assert.Equal(t, 1, aspsp.PaymentCount())
+}
+
+func TestNatwestClient_MockAspsp_SignedRequestsAndResponses(t *testing.T) {
+
+	// arrange
+	tppCert, err := test_util.NewSelfSignedCert("tpp.test")
+	require.NoError(t, err)
+	aspspCert, err := test_util.NewSelfSignedCert("aspsp.test")
+	require.NoError(t, err)
+	aspspSigner, err := bank.NewJwsSigner(aspspCert, "aspsp-kid", "aspsp")
+	require.NoError(t, err)
+
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.TppSigningKey = &tppCert.PrivateKey.(*rsa.PrivateKey).PublicKey
+	aspsp.Signer = aspspSigner
+
+	info := test_util.MockAspspInfo()
+	creds := bank.OauthClientCreds{
+		ClientId:       info.ClientId,
+		ClientSecret:   info.ClientSecret,
+		RedirectionUrl: info.RedirectUrl,
+		SigningCert:    *tppCert,
+		SigningKeyId:   "tpp-kid",
+		JwsIssuer:      "org/ssa",
+	}
+	client := bank_impl.NewNatwestClient(5, aspsp.Endpoints(), &creds, zap.NewExample().Sugar())
+	client.(*bank_impl.NatwestSandboxClient).SetResponseKeyResolver(
+		bank.StaticKeyResolver(&aspspCert.PrivateKey.(*rsa.PrivateKey).PublicKey))
+	authReq := newAuthRequest()
+
+	// act
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, test_util.Receiver())
+
+	// assert
+	require.NoError(t, err)
+	assert.NotEmpty(t, authResp.ConsentId)
+}
+
+func TestNatwestClient_MockAspsp_RejectsForgedResponseSignature(t *testing.T) {
+
+	// arrange
+	aspspCert, err := test_util.NewSelfSignedCert("aspsp.test")
+	require.NoError(t, err)
+	forgerCert, err := test_util.NewSelfSignedCert("forger.test")
+	require.NoError(t, err)
+	forger, err := bank.NewJwsSigner(forgerCert, "aspsp-kid", "aspsp")
+	require.NoError(t, err)
+
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.Signer = forger
+	client := newMockClient(aspsp)
+	client.(*bank_impl.NatwestSandboxClient).SetResponseKeyResolver(
+		bank.StaticKeyResolver(&aspspCert.PrivateKey.(*rsa.PrivateKey).PublicKey))
+	authReq := newAuthRequest()
+
+	// act
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+	_, err = client.CreatePaymentAuthRequest(authReq, token, test_util.Receiver())
+
+	// assert
+	assert.ErrorContains(t, err, "signature verification")
+}
+
+func TestNatwestClient_MockAspsp_UnsignedRequestsRefused(t *testing.T) {
+	// an unusable signing key must not fall back to the sandbox's placeholder signature
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	cases := []struct {
+		name   string
+		cert   tls.Certificate
+		expErr string
+	}{
+		{name: "no signing key", expErr: "reduced security is not enabled"},
+		{name: "invalid signing key", cert: tls.Certificate{PrivateKey: ecKey}, expErr: "Invalid signing certificate"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			aspsp := bank_mock.NewAspspServer()
+			defer aspsp.Close()
+			info := test_util.MockAspspInfo()
+			client := bank_impl.NewNatwestClient(5, aspsp.Endpoints(), &bank.OauthClientCreds{
+				ClientId:       info.ClientId,
+				ClientSecret:   info.ClientSecret,
+				RedirectionUrl: info.RedirectUrl,
+				SigningCert:    c.cert,
+			}, zap.NewExample().Sugar())
+			authReq := newAuthRequest()
+
+			// act
+			token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+			require.NoError(t, err)
+			_, err = client.CreatePaymentAuthRequest(authReq, token, test_util.Receiver())
+
+			// assert
+			assert.ErrorContains(t, err, c.expErr)
+			assert.Equal(t, 0, aspsp.CallCount(bank_mock.CONSENT))
+		})
+	}
+}
+
+func TestNatwestClient_MockAspsp_ConsentStatus(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.SetApproveConsents(false)
+	client := newMockClient(aspsp)
+	authReq := newAuthRequest()
+
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, test_util.Receiver())
+	require.NoError(t, err)
+
+	// act
+	awaiting, errAwaiting := client.GetPaymentConsentStatus(authResp, token)
+	client.(*bank_impl.NatwestSandboxClient).ApproveConsent(authResp, test_util.MockAspspInfo().CustomerUsername)
+	rejected, errRejected := client.GetPaymentConsentStatus(authResp, token)
+	_, errUnknown := client.GetPaymentConsentStatus(&bank.PaymentAuthResponse{RequestId: "req", ConsentId: "unknown"}, token)
+
+	// assert
+	require.NoError(t, errAwaiting)
+	assert.Equal(t, bank.CONSENT_AWAITING_AUTHORISATION, awaiting.Status)
+	require.NoError(t, errRejected)
+	assert.Equal(t, bank.CONSENT_REJECTED, rejected.Status)
+	assert.Equal(t, authResp.ConsentId, rejected.ConsentId)
+	assert.True(t, bank.IsTerminal(errUnknown))
+}
+
+// emptyData replaces the body of the matching responses with an empty Data, as a misbehaving ASPSP might
+func emptyData(match func(r *http.Request) bool) bank_impl.TransportDecorator {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTrip(func(r *http.Request) (*http.Response, error) {
+			resp, err := next.RoundTrip(r)
+			if err != nil || !match(r) {
+				return resp, err
+			}
+			resp.Body.Close()
+			resp.Body = io.NopCloser(strings.NewReader(`{"Data":{}}`))
+			resp.ContentLength = -1
+			resp.Header.Del("Content-Length")
+			return resp, nil
+		})
+	}
+}
+
+func TestNatwestClient_MockAspsp_MalformedConsentStatus(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	client := newMockClient(aspsp)
+	authReq := newAuthRequest()
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, test_util.Receiver())
+	require.NoError(t, err)
+	client.(*bank_impl.NatwestSandboxClient).SetTransport(emptyData(func(r *http.Request) bool {
+		return r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/"+authResp.ConsentId)
+	}))
+
+	// act
+	_, err = client.GetPaymentConsentStatus(authResp, token)
+
+	// assert
+	assert.True(t, bank.IsTerminal(err))
+	assert.ErrorContains(t, err, "Data.Status")
+}
+
+// submitMockPayment runs a payment up to its submission
+func submitMockPayment(t *testing.T, client bank.OpenBankingClient) *bank.SubmitPaymentResponse {
+	authReq := newAuthRequest()
+	receiver := test_util.Receiver()
+
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, receiver)
+	require.NoError(t, err)
+	granted, err := client.(*bank_impl.NatwestSandboxClient).ApproveConsent(authResp, test_util.MockAspspInfo().CustomerUsername)
+	require.NoError(t, err)
+	paymResp, err := client.SubmitPayment(granted, authReq, receiver)
+	require.NoError(t, err)
+	return paymResp
+}
+
+func TestNatwestClient_MockAspsp_MalformedPaymentStatus(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	client := newMockClient(aspsp)
+	paymResp := submitMockPayment(t, client)
+	client.(*bank_impl.NatwestSandboxClient).SetTransport(emptyData(func(r *http.Request) bool {
+		return strings.HasSuffix(r.URL.Path, "/"+paymResp.PaymentId)
+	}))
+
+	// act
+	_, err := client.GetPaymentStatus(paymResp)
+
+	// assert
+	assert.True(t, bank.IsTerminal(err))
+	assert.ErrorContains(t, err, "Data.Status")
+}
+
+func TestNatwestClient_MockAspsp_StatusRefreshesExpiredToken(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	// shorter than the client's expiry margin, i.e. expired as soon as issued
+	aspsp.TokenLifetime = time.Second
+	client := newMockClient(aspsp)
+	paymResp := submitMockPayment(t, client)
+	tokenCalls := aspsp.CallCount(bank_mock.TOKEN)
+
+	// act
+	time.Sleep(1100 * time.Millisecond)
+	status, err := client.GetPaymentStatus(paymResp)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, bank.PAYMENT_PENDING, status.Status)
+	assert.Equal(t, tokenCalls+1, aspsp.CallCount(bank_mock.TOKEN))
+}
+
+func TestNatwestClient_MockAspsp_ForgottenPaymentNotRefreshed(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.TokenLifetime = time.Second
+	client := newMockClient(aspsp)
+	paymResp := submitMockPayment(t, client)
+	tokenCalls := aspsp.CallCount(bank_mock.TOKEN)
+
+	// act
+	client.ForgetPayment(paymResp.RequestId)
+	time.Sleep(1100 * time.Millisecond)
+	client.GetPaymentStatus(paymResp)
+
+	// assert
+	assert.Equal(t, tokenCalls, aspsp.CallCount(bank_mock.TOKEN))
+}
+
+func TestNatwestClient_MockAspsp_StatusFallsBackToClientCredentials(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.TokenLifetime = time.Second
+	aspsp.NoRefreshTokens = true
+	client := newMockClient(aspsp)
+	client.(*bank_impl.NatwestSandboxClient).SetStatusClientCredentials(true)
+	paymResp := submitMockPayment(t, client)
+
+	// act
+	time.Sleep(1100 * time.Millisecond)
+	status, err := client.GetPaymentStatus(paymResp)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, bank.PAYMENT_PENDING, status.Status)
+}
+
+func TestNatwestClient_MockAspsp_StatusTokenExpiredWithoutFallback(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.TokenLifetime = time.Second
+	aspsp.NoRefreshTokens = true
+	client := newMockClient(aspsp)
+	paymResp := submitMockPayment(t, client)
+
+	// act
+	time.Sleep(1100 * time.Millisecond)
+	_, err := client.GetPaymentStatus(paymResp)
+
+	// assert
+	assert.True(t, bank.IsTerminal(err))
+	assert.ErrorContains(t, err, "cannot be refreshed")
+	assert.Equal(t, 0, aspsp.CallCount(bank_mock.PAYMENT_STATUS))
+}
+
+func TestNatwestClient_MockAspsp_ConfirmFundsThenSubmit(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	client := newMockClient(aspsp)
+	authReq := newAuthRequest()
+	receiver := test_util.Receiver()
+
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, receiver)
+	require.NoError(t, err)
+	granted, err := client.(*bank_impl.NatwestSandboxClient).ApproveConsent(authResp, test_util.MockAspspInfo().CustomerUsername)
+	require.NoError(t, err)
+
+	// act
+	funds, errFunds := client.ConfirmFunds(granted)
+	paymResp, errSubmit := client.SubmitPayment(granted, authReq, receiver)
+
+	// assert
+	require.NoError(t, errFunds)
+	assert.True(t, funds.FundsAvailable)
+	require.NoError(t, errSubmit)
+	assert.NotEmpty(t, paymResp.PaymentId)
+	// client credentials + a single code exchange
+	assert.Equal(t, 2, aspsp.CallCount(bank_mock.TOKEN))
+}
+
+func TestNatwestClient_MockAspsp_InsufficientFunds(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.SetFundsAvailable(false)
+	client := newMockClient(aspsp)
+	authReq := newAuthRequest()
+
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, test_util.Receiver())
+	require.NoError(t, err)
+	granted, err := client.(*bank_impl.NatwestSandboxClient).ApproveConsent(authResp, test_util.MockAspspInfo().CustomerUsername)
+	require.NoError(t, err)
+
+	// act
+	funds, err := client.ConfirmFunds(granted)
+
+	// assert
+	require.NoError(t, err)
+	assert.False(t, funds.FundsAvailable)
+	assert.Equal(t, granted.ConsentId, funds.ConsentId)
+	assert.Equal(t, 1, aspsp.CallCount(bank_mock.FUNDS_CONFIRMATION))
+}
+
+func TestNatwestClient_MockAspsp_MalformedFundsConfirmation(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	client := newMockClient(aspsp)
+	authReq := newAuthRequest()
+
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, test_util.Receiver())
+	require.NoError(t, err)
+	granted, err := client.(*bank_impl.NatwestSandboxClient).ApproveConsent(authResp, test_util.MockAspspInfo().CustomerUsername)
+	require.NoError(t, err)
+	client.(*bank_impl.NatwestSandboxClient).SetTransport(emptyData(func(r *http.Request) bool {
+		return strings.HasSuffix(r.URL.Path, "/funds-confirmation")
+	}))
+
+	// act
+	funds, err := client.ConfirmFunds(granted)
+
+	// assert
+	assert.Nil(t, funds)
+	assert.True(t, bank.IsTerminal(err))
+	assert.ErrorContains(t, err, "FundsAvailable")
+}
+
+func TestNatwestClient_MockAspsp_StateAndNonceRoundTrip(t *testing.T) {
+
+	// arrange
+	tppCert, err := test_util.NewSelfSignedCert("tpp.test")
+	require.NoError(t, err)
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.TppSigningKey = &tppCert.PrivateKey.(*rsa.PrivateKey).PublicKey
+
+	info := test_util.MockAspspInfo()
+	creds := bank.OauthClientCreds{
+		ClientId:       info.ClientId,
+		ClientSecret:   info.ClientSecret,
+		RedirectionUrl: info.RedirectUrl,
+		SigningCert:    *tppCert,
+	}
+	client := bank_impl.NewNatwestClient(5, aspsp.Endpoints(), &creds, zap.NewExample().Sugar())
+	authReq := newAuthRequest()
+
+	// act
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, test_util.Receiver())
+	require.NoError(t, err)
+	granted, err := client.(*bank_impl.NatwestSandboxClient).ApproveConsent(authResp, info.CustomerUsername)
+	require.NoError(t, err)
+
+	// assert
+	assert.NotEmpty(t, authResp.State)
+	assert.NotEmpty(t, authResp.Nonce)
+	assert.NotContains(t, authResp.Url, "request="+authResp.ConsentId)
+	assert.Equal(t, authResp.State, granted.State)
+	claims, err := bank.ParseJwtClaims(granted.IdToken)
+	require.NoError(t, err)
+	assert.Equal(t, authResp.Nonce, claims["nonce"])
+	assert.Equal(t, authResp.ConsentId, claims[bank_impl.OB_INTENT_ID_CLAIM])
+	assert.Equal(t, info.ClientId, claims["aud"])
+}
+
+func TestNatwestClient_MockAspsp_IdTokenValidatesAgainstJwks(t *testing.T) {
+
+	// arrange
+	aspspCert, err := test_util.NewSelfSignedCert("aspsp.test")
+	require.NoError(t, err)
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.Signer, err = bank.NewJwsSigner(aspspCert, "aspsp-kid", "aspsp")
+	require.NoError(t, err)
+	client := newMockClient(aspsp)
+	authReq := newAuthRequest()
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, test_util.Receiver())
+	require.NoError(t, err)
+
+	// act
+	granted, err := client.(*bank_impl.NatwestSandboxClient).ApproveConsent(authResp, test_util.MockAspspInfo().CustomerUsername)
+	require.NoError(t, err)
+	validator := bank.NewIdTokenValidator(aspsp.Endpoints().Issuer, aspsp.ClientId, bank.JwksKeyResolver(http.DefaultClient, aspsp.JwksUrl()))
+	_, err = validator.Validate(granted.IdToken, &bank.IdTokenExpectations{
+		Nonce: authResp.Nonce,
+		Code:  granted.ConsentCode,
+		State: granted.State,
+	})
+
+	// assert
+	assert.NoError(t, err)
+}
+
+func TestNatwestClient_MockAspsp_AccountCredits(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	client := newMockClient(aspsp)
+	natwest := client.(*bank_impl.NatwestSandboxClient)
+	since := time.Now().Add(-time.Minute)
+	paymResp := submitMockPayment(t, client)
+
+	// act
+	err := natwest.AuthoriseAccountAccess(test_util.MockAspspInfo().CustomerUsername)
+	require.NoError(t, err)
+	credits, err := natwest.GetAccountCredits(test_util.Receiver(), since)
+
+	// assert
+	require.NoError(t, err)
+	matches := bank.MatchingCredits(credits, test_util.AMOUNT, paymResp.Identifiers.EndToEndId)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, 1, aspsp.CallCount(bank_mock.ACCOUNTS))
+}
+
+func TestNatwestClient_MockAspsp_AccountCreditsAcrossPages(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	aspsp.TransactionPageSize = 1
+	client := newMockClient(aspsp)
+	natwest := client.(*bank_impl.NatwestSandboxClient)
+	since := time.Now().Add(-time.Minute)
+	for i := 0; i < 3; i++ {
+		submitMockPayment(t, client)
+	}
+	require.NoError(t, natwest.AuthoriseAccountAccess(test_util.MockAspspInfo().CustomerUsername))
+
+	// act
+	credits, err := natwest.GetAccountCredits(test_util.Receiver(), since)
+
+	// assert
+	require.NoError(t, err)
+	assert.Len(t, credits, 3)
+	assert.Equal(t, 3, aspsp.CallCount(bank_mock.TRANSACTIONS))
+}
+
+func TestNatwestClient_MockAspsp_AccountCreditsLinkOutsideAspsp(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	client := newMockClient(aspsp)
+	natwest := client.(*bank_impl.NatwestSandboxClient)
+	submitMockPayment(t, client)
+	require.NoError(t, natwest.AuthoriseAccountAccess(test_util.MockAspspInfo().CustomerUsername))
+	natwest.SetTransport(func(next http.RoundTripper) http.RoundTripper {
+		return roundTrip(func(r *http.Request) (*http.Response, error) {
+			resp, err := next.RoundTrip(r)
+			if err != nil || !strings.HasSuffix(r.URL.Path, "/transactions") {
+				return resp, err
+			}
+			resp.Body.Close()
+			resp.Body = io.NopCloser(strings.NewReader(`{"Data":{},"Links":{"Next":"https://elsewhere.test/transactions?page=2"}}`))
+			resp.ContentLength = -1
+			resp.Header.Del("Content-Length")
+			return resp, nil
+		})
+	})
+
+	// act
+	_, err := natwest.GetAccountCredits(test_util.Receiver(), time.Now())
+
+	// assert
+	assert.True(t, bank.IsTerminal(err))
+	assert.ErrorContains(t, err, "outside the ASPSP")
+	assert.Equal(t, 1, aspsp.CallCount(bank_mock.TRANSACTIONS))
+}
+
+func TestNatwestClient_MockAspsp_AccountAccessNotAuthorised(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	natwest := newMockClient(aspsp).(*bank_impl.NatwestSandboxClient)
+
+	// act
+	_, err := natwest.GetAccountCredits(test_util.Receiver(), time.Now())
+
+	// assert
+	require.Error(t, err)
+	assert.True(t, bank.IsTerminal(err))
+	assert.Equal(t, 0, aspsp.CallCount(bank_mock.TRANSACTIONS))
+}
+
+func TestNatwestClient_MockAspsp_MalformedAccountAccessConsent(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	natwest := newMockClient(aspsp).(*bank_impl.NatwestSandboxClient)
+	natwest.SetTransport(emptyData(func(r *http.Request) bool {
+		return strings.HasSuffix(r.URL.Path, bank_mock.ACCOUNT_ACCESS_CONSENT_PATH)
+	}))
+
+	// act
+	err := natwest.AuthoriseAccountAccess(test_util.MockAspspInfo().CustomerUsername)
+
+	// assert
+	assert.True(t, bank.IsTerminal(err))
+	assert.ErrorContains(t, err, "Data.ConsentId")
+	assert.Equal(t, 0, aspsp.CallCount(bank_mock.AUTHORIZE))
+}
+
+func TestNatwestClient_MockAspsp_InvalidConsentIsNotSent(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	client := newMockClient(aspsp)
+	authReq := newAuthRequest()
+	authReq.Amount = "0.000000000000000001"
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+
+	// act
+	_, err = client.CreatePaymentAuthRequest(authReq, token, test_util.Receiver())
+
+	// assert
+	require.Error(t, err)
+	assert.True(t, bank.IsTerminal(err))
+	assert.Equal(t, 0, aspsp.CallCount(bank_mock.CONSENT))
+}
+
+func TestNatwestClient_MockAspsp_PaymentCarriesDerivedIdentifiers(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	client := newMockClient(aspsp)
+	authReq := newAuthRequest()
+	receiver := test_util.Receiver()
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+
+	// act
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, receiver)
+	require.NoError(t, err)
+	granted, err := client.(*bank_impl.NatwestSandboxClient).ApproveConsent(authResp, test_util.MockAspspInfo().CustomerUsername)
+	require.NoError(t, err)
+	paymResp, err := client.SubmitPayment(granted, authReq, receiver)
+
+	// assert
+	require.NoError(t, err)
+	derived := bank_impl.DeriveIdentifiers(authReq.RequestId)
+	assert.Equal(t, derived.InstructionId, paymResp.Identifiers.InstructionId)
+	assert.Equal(t, derived.EndToEndId, paymResp.Identifiers.EndToEndId)
+	assert.Equal(t, "PGBP "+authReq.RequestId[:8], paymResp.Identifiers.Reference)
+	assert.Equal(t, authResp.Identifiers, paymResp.Identifiers)
+}
+
+func TestNatwestClient_MockAspsp_ConsentApprover(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	client := newMockClient(aspsp)
+	var approver bank.ConsentApprover = client.(*bank_impl.NatwestSandboxClient)
+	authReq := newAuthRequest()
+	token, err := client.GetPaymentAuthAccessToken(authReq.RequestId)
+	require.NoError(t, err)
+	authResp, err := client.CreatePaymentAuthRequest(authReq, token, test_util.Receiver())
+	require.NoError(t, err)
+
+	// act
+	granted, err := approver.ApproveConsent(authResp, test_util.MockAspspInfo().CustomerUsername)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, authResp.State, granted.State)
+	assert.NotEmpty(t, granted.ConsentCode)
+	assert.NotEmpty(t, granted.IdToken)
+	status, err := client.GetPaymentConsentStatus(authResp, token)
+	require.NoError(t, err)
+	assert.Equal(t, bank.CONSENT_AUTHORISED, status.Status)
+}
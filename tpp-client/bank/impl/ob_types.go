@@ -0,0 +1,232 @@
+package bank_impl
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"net/http"
+	"regexp"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ----- OB v3.1 PISP request bodies -----
+// Only the fields we send are modelled. Optional ones are omitted when empty.
+
+// OBWriteDomesticConsent is the body of a domestic payment consent request
+type OBWriteDomesticConsent struct {
+	Data OBWriteDomesticConsentData `json:"Data"`
+	Risk OBRisk                     `json:"Risk"`
+}
+
+type OBWriteDomesticConsentData struct {
+	Initiation OBDomesticInitiation `json:"Initiation"`
+}
+
+// OBWriteDomestic is the body of a domestic payment request. Its initiation must match the consent's.
+type OBWriteDomestic struct {
+	Data OBWriteDomesticData `json:"Data"`
+	Risk OBRisk              `json:"Risk"`
+}
+
+type OBWriteDomesticData struct {
+	ConsentId  string               `json:"ConsentId"`
+	Initiation OBDomesticInitiation `json:"Initiation"`
+}
+
+type OBDomesticInitiation struct {
+	InstructionIdentification string                   `json:"InstructionIdentification"`
+	EndToEndIdentification    string                   `json:"EndToEndIdentification"`
+	DebtorAccount             *OBCashAccount           `json:"DebtorAccount,omitempty"`
+	InstructedAmount          OBAmount                 `json:"InstructedAmount"`
+	CreditorAccount           OBCashAccount            `json:"CreditorAccount"`
+	RemittanceInformation     *OBRemittanceInformation `json:"RemittanceInformation,omitempty"`
+}
+
+type OBCashAccount struct {
+	SchemeName              string `json:"SchemeName"`
+	Identification          string `json:"Identification"`
+	Name                    string `json:"Name,omitempty"`
+	SecondaryIdentification string `json:"SecondaryIdentification,omitempty"`
+}
+
+type OBAmount struct {
+	Amount   string `json:"Amount"`
+	Currency string `json:"Currency"`
+}
+
+type OBRemittanceInformation struct {
+	Unstructured string `json:"Unstructured,omitempty"`
+	Reference    string `json:"Reference,omitempty"`
+}
+
+type OBRisk struct {
+	PaymentContextCode string `json:"PaymentContextCode,omitempty"`
+}
+
+const (
+	SORT_CODE_ACCOUNT_NUMBER = bank.SCHEME_SORT_CODE_ACCOUNT_NUMBER
+	IBAN                     = bank.SCHEME_IBAN
+	CURRENCY_GBP             = "GBP"
+	PAYMENT_CONTEXT_SERVICES = "Services"
+)
+
+// OB field rules
+var (
+	obAmountPattern   = regexp.MustCompile(`^\d{1,13}$|^\d{1,13}\.\d{1,5}$`)
+	obCurrencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+	sortCodeAccountNo = regexp.MustCompile(`^\d{14}$`)
+	obIbanPattern     = regexp.MustCompile(`^[A-Z]{2}\d{2}[A-Z0-9]{11,30}$`)
+	// the character set of the UK payment schemes, for the fields passed on to the beneficiary
+	schemeCharset = regexp.MustCompile(`^[A-Za-z0-9/\-?:().,'+ ]*$`)
+)
+
+// NewDomesticInitiation returns the initiation of a payment from the payer to the beneficiary
+func NewDomesticInitiation(
+	authRequest *bank.PaymentAuthRequest,
+	beneficiary *bank.AccountDetails,
+	ids bank.PaymentIdentifiers,
+	unstructured string) OBDomesticInitiation {
+
+	return OBDomesticInitiation{
+		InstructionIdentification: ids.InstructionId,
+		EndToEndIdentification:    ids.EndToEndId,
+		DebtorAccount:             NewCashAccount(&authRequest.Payer),
+		InstructedAmount: OBAmount{
+			Amount:   authRequest.Amount,
+			Currency: CURRENCY_GBP,
+		},
+		CreditorAccount: *NewCashAccount(beneficiary),
+		RemittanceInformation: &OBRemittanceInformation{
+			Unstructured: unstructured,
+			Reference:    ids.Reference,
+		},
+	}
+}
+
+// NewCashAccount returns the OB identification of an account, in its scheme
+func NewCashAccount(account *bank.AccountDetails) *OBCashAccount {
+	return &OBCashAccount{
+		SchemeName:              account.Scheme(),
+		Identification:          account.Identification(),
+		Name:                    account.Name,
+		SecondaryIdentification: account.SecondaryIdentification,
+	}
+}
+
+// Validate checks the consent against the OB field rules
+func (c *OBWriteDomesticConsent) Validate() error {
+	return c.Data.Initiation.Validate()
+}
+
+// Validate checks the payment against the OB field rules
+func (p *OBWriteDomestic) Validate() error {
+	if err := checkText("ConsentId", p.Data.ConsentId, 1, 128); err != nil {
+		return err
+	}
+	return p.Data.Initiation.Validate()
+}
+
+// Validate checks the initiation against the OB field rules
+func (i *OBDomesticInitiation) Validate() error {
+
+	if err := checkSchemeText("InstructionIdentification", i.InstructionIdentification, 1, OB_MAX_IDENTIFICATION); err != nil {
+		return err
+	}
+	if err := checkSchemeText("EndToEndIdentification", i.EndToEndIdentification, 1, OB_MAX_IDENTIFICATION); err != nil {
+		return err
+	}
+	if !obAmountPattern.MatchString(i.InstructedAmount.Amount) {
+		return errors.New("Invalid InstructedAmount.Amount: " + i.InstructedAmount.Amount)
+	}
+	if !obCurrencyPattern.MatchString(i.InstructedAmount.Currency) {
+		return errors.New("Invalid InstructedAmount.Currency: " + i.InstructedAmount.Currency)
+	}
+	if i.DebtorAccount != nil {
+		if err := i.DebtorAccount.validate("DebtorAccount", false); err != nil {
+			return err
+		}
+	}
+	if err := i.CreditorAccount.validate("CreditorAccount", true); err != nil {
+		return err
+	}
+	if r := i.RemittanceInformation; r != nil {
+		if err := checkText("RemittanceInformation.Unstructured", r.Unstructured, 0, OB_MAX_UNSTRUCTURED); err != nil {
+			return err
+		}
+		if err := checkSchemeText("RemittanceInformation.Reference", r.Reference, 0, OB_MAX_IDENTIFICATION); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *OBCashAccount) validate(field string, nameRequired bool) error {
+	if err := checkText(field+".SchemeName", a.SchemeName, 1, 40); err != nil {
+		return err
+	}
+	if err := checkText(field+".Identification", a.Identification, 1, 256); err != nil {
+		return err
+	}
+	if a.SchemeName == SORT_CODE_ACCOUNT_NUMBER && !sortCodeAccountNo.MatchString(a.Identification) {
+		return errors.New("Invalid " + field + ".Identification: expected a 6-digit sort code and 8-digit account number")
+	}
+	if a.SchemeName == IBAN && !obIbanPattern.MatchString(a.Identification) {
+		return errors.New("Invalid " + field + ".Identification: expected an IBAN")
+	}
+	if err := checkText(field+".SecondaryIdentification", a.SecondaryIdentification, 0, 34); err != nil {
+		return err
+	}
+	minName := 0
+	if nameRequired {
+		minName = 1
+	}
+	return checkText(field+".Name", a.Name, minName, 70)
+}
+
+// checkText checks the length of a text field, in characters, and that it has no control characters
+func checkText(field string, v string, min int, max int) error {
+	if !utf8.ValidString(v) {
+		return errors.New("Invalid " + field + ": not UTF-8")
+	}
+	if n := utf8.RuneCountInString(v); n < min || n > max {
+		return errors.New("Invalid " + field + ": length must be between " + strconv.Itoa(min) + " and " + strconv.Itoa(max))
+	}
+	for _, r := range v {
+		if unicode.IsControl(r) {
+			return errors.New("Invalid " + field + ": control characters are not allowed")
+		}
+	}
+	return nil
+}
+
+// checkSchemeText checks a text field which the payment scheme passes on, so restricted to its character set
+func checkSchemeText(field string, v string, min int, max int) error {
+	if err := checkText(field, v, min, max); err != nil {
+		return err
+	}
+	if !schemeCharset.MatchString(v) {
+		return errors.New("Invalid " + field + ": characters outside the payment scheme's set")
+	}
+	return nil
+}
+
+// malformed returns the terminal error of a response without a mandatory field.
+// Asking again would return the same.
+func malformed(operation string, field string) error {
+	return &bank.BankError{Operation: operation, Status: http.StatusOK, Class: bank.TERMINAL, Cause: errors.New("Malformed response, no " + field)}
+}
+
+// marshalValid validates a request body and marshals it. Invalid bodies are terminal errors, as the ASPSP would
+// reject them on every attempt.
+func marshalValid(operation string, body interface{ Validate() error }) (string, error) {
+	if err := body.Validate(); err != nil {
+		return "", &bank.BankError{Operation: operation, Class: bank.TERMINAL, Cause: err}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,99 @@
+package bank_impl_test
+
+import (
+	"encoding/json"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	test_util "github.com/sgerogia/sol-stablecoin/tpp-client/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestOBWriteDomesticConsent_NameIsNotInjected(t *testing.T) {
+
+	// arrange
+	authReq := newAuthRequest()
+	authReq.Payer.Name = `Jo "Bob" \ Doe", "Injected": "x`
+	consent := bank_impl.OBWriteDomesticConsent{
+		Data: bank_impl.OBWriteDomesticConsentData{
+			Initiation: newInitiation(authReq),
+		},
+	}
+
+	// act
+	require.NoError(t, consent.Validate())
+	data, err := json.Marshal(&consent)
+	require.NoError(t, err)
+
+	// assert
+	var parsed map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &parsed))
+	initiation := parsed["Data"].(map[string]interface{})["Initiation"].(map[string]interface{})
+	assert.Equal(t, authReq.Payer.Name, initiation["DebtorAccount"].(map[string]interface{})["Name"])
+	assert.NotContains(t, initiation, "Injected")
+}
+
+func TestNewDomesticInitiation_Iban(t *testing.T) {
+
+	// arrange
+	authReq := newAuthRequest()
+	authReq.Payer = bank.AccountDetails{Iban: "gb29 nwbk 6016 1331 9268 19", SecondaryIdentification: "ROLL-1", Name: "Jo Doe"}
+
+	// act
+	initiation := newInitiation(authReq)
+
+	// assert
+	require.NoError(t, initiation.Validate())
+	assert.Equal(t, bank_impl.IBAN, initiation.DebtorAccount.SchemeName)
+	assert.Equal(t, "GB29NWBK60161331926819", initiation.DebtorAccount.Identification)
+	assert.Equal(t, "ROLL-1", initiation.DebtorAccount.SecondaryIdentification)
+	assert.Equal(t, bank_impl.SORT_CODE_ACCOUNT_NUMBER, initiation.CreditorAccount.SchemeName)
+}
+
+func TestOBDomesticInitiation_Validate(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(i *bank_impl.OBDomesticInitiation)
+		err    string
+	}{
+		{name: "valid", modify: func(i *bank_impl.OBDomesticInitiation) {}},
+		{name: "amount with 6 decimals", modify: func(i *bank_impl.OBDomesticInitiation) { i.InstructedAmount.Amount = "1.000001" }, err: "Amount"},
+		{name: "negative amount", modify: func(i *bank_impl.OBDomesticInitiation) { i.InstructedAmount.Amount = "-1" }, err: "Amount"},
+		{name: "lowercase currency", modify: func(i *bank_impl.OBDomesticInitiation) { i.InstructedAmount.Currency = "gbp" }, err: "Currency"},
+		{name: "short account number", modify: func(i *bank_impl.OBDomesticInitiation) { i.CreditorAccount.Identification = "5000008765430" }, err: "CreditorAccount.Identification"},
+		{name: "missing creditor name", modify: func(i *bank_impl.OBDomesticInitiation) { i.CreditorAccount.Name = "" }, err: "CreditorAccount.Name"},
+		{name: "long debtor name", modify: func(i *bank_impl.OBDomesticInitiation) { i.DebtorAccount.Name = strings.Repeat("a", 71) }, err: "DebtorAccount.Name"},
+		{name: "control character in name", modify: func(i *bank_impl.OBDomesticInitiation) { i.DebtorAccount.Name = "Jo\nDoe" }, err: "control"},
+		{name: "long end-to-end id", modify: func(i *bank_impl.OBDomesticInitiation) { i.EndToEndIdentification = strings.Repeat("a", 36) }, err: "EndToEndIdentification"},
+		{name: "malformed iban", modify: func(i *bank_impl.OBDomesticInitiation) {
+			i.DebtorAccount.SchemeName = bank_impl.IBAN
+			i.DebtorAccount.Identification = "GB29 NWBK"
+		}, err: "DebtorAccount.Identification"},
+		{name: "reference charset", modify: func(i *bank_impl.OBDomesticInitiation) { i.RemittanceInformation.Reference = "Mint #1" }, err: "Reference"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			initiation := newInitiation(newAuthRequest())
+			c.modify(&initiation)
+
+			// act
+			err := initiation.Validate()
+
+			// assert
+			if c.err == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), c.err)
+			}
+		})
+	}
+}
+
+func newInitiation(authReq *bank.PaymentAuthRequest) bank_impl.OBDomesticInitiation {
+	ids, unstructured := bank_impl.DefaultRemittance().Identifiers(authReq)
+	return bank_impl.NewDomesticInitiation(authReq, test_util.Receiver(), ids, unstructured)
+}
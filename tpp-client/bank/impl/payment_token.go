@@ -0,0 +1,224 @@
+package bank_impl
+
+import (
+	"encoding/json"
+	"errors"
+	resty "github.com/go-resty/resty/v2"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var errPaymentTokenExpired = errors.New("Payment access token expired and cannot be refreshed")
+
+// paymentToken is the authorization_code token of a submitted payment, used to check its status
+type paymentToken struct {
+	access  string
+	refresh string
+	// expiry is zero if the ASPSP did not say
+	expiry time.Time
+}
+
+// paymentTokens keeps the payment tokens by request ID
+type paymentTokens struct {
+	mu     sync.Mutex
+	tokens map[string]*paymentToken
+}
+
+func newPaymentTokens() *paymentTokens {
+	return &paymentTokens{tokens: make(map[string]*paymentToken)}
+}
+
+func (p *paymentTokens) get(requestId string) *paymentToken {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.tokens[requestId]
+}
+
+func (p *paymentTokens) put(requestId string, atResp *AccessTokenResponse) *paymentToken {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := &paymentToken{access: atResp.AccessToken, refresh: atResp.RefreshToken}
+	if atResp.ExpiresIn > 0 {
+		t.expiry = time.Now().Add(time.Duration(atResp.ExpiresIn) * time.Second)
+	}
+	// ASPSPs not rotating refresh tokens do not return them on refresh
+	if old := p.tokens[requestId]; old != nil && t.refresh == "" {
+		t.refresh = old.refresh
+	}
+	p.tokens[requestId] = t
+	return t
+}
+
+func (p *paymentTokens) drop(requestId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.tokens, requestId)
+}
+
+func (p *paymentTokens) dropRefresh(requestId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t := p.tokens[requestId]; t != nil {
+		t.refresh = ""
+	}
+}
+
+// consentToken returns the access token of a granted consent.
+// The consent code is single use, so it is exchanged once and the token kept for the following calls,
+// e.g. a funds confirmation followed by the payment submission.
+func (c *NatwestSandboxClient) consentToken(authGranted *bank.PaymentAuthGranted) (string, error) {
+
+	if t := c.paymentTokens.get(authGranted.RequestId); t != nil {
+		if t.expiry.IsZero() || time.Now().Add(TOKEN_EXPIRY_MARGIN).Before(t.expiry) {
+			return t.access, nil
+		}
+		if t.refresh != "" {
+			if refreshed, err := c.refreshPaymentToken(authGranted.RequestId, t.refresh); err == nil {
+				return refreshed.access, nil
+			}
+		}
+	}
+	return c.exchangeConsentCode(authGranted)
+}
+
+// exchangeConsentCode exchanges the consent code for an access token (authorization_code grant)
+func (c *NatwestSandboxClient) exchangeConsentCode(authGranted *bank.PaymentAuthGranted) (string, error) {
+
+	c.l.Debugw("Exchange consent code",
+		"requestId", authGranted.RequestId)
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"redirect_uri": {c.clientCreds.RedirectionUrl},
+		"code":         {authGranted.ConsentCode},
+	}
+	if err := addClientAuth(form, c.clientCreds, c.signer, c.endpoints.OauthToken); err != nil {
+		return "", err
+	}
+
+	var resp *resty.Response
+	err := c.retry.Do("exchange consent code", c.l, func() error {
+		var err error
+		resp, err = c.client.R().
+			SetHeader("Accept", "application/json").
+			SetHeader("Content-Type", "application/x-www-form-urlencoded").
+			SetBody(form.Encode()).
+			Post(c.endpoints.OauthToken)
+
+		if err != nil {
+			return bank.NewTransportError("exchange consent code", err)
+		}
+
+		if resp.StatusCode() != http.StatusOK {
+			return bank.NewBankError("exchange consent code", resp.StatusCode(), resp.Body(), resp.Header())
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// keep the refresh token for the status checks
+	var exchResp AccessTokenResponse
+	if err = json.Unmarshal(resp.Body(), &exchResp); err != nil {
+		return "", err
+	}
+	c.paymentTokens.put(authGranted.RequestId, &exchResp)
+	return exchResp.AccessToken, nil
+}
+
+// statusToken returns the access token to check the status of a payment with.
+// The payment's own token is used while valid, then refreshed. Failing that, a client_credentials token,
+// if the client is configured to use them for status checks.
+// `rejected` forces a new token, e.g. when the ASPSP has rejected the current one.
+func (c *NatwestSandboxClient) statusToken(data *bank.SubmitPaymentResponse, rejected bool) (string, error) {
+
+	t := c.paymentTokens.get(data.RequestId)
+	if t == nil && !rejected {
+		// submitted before a restart, we only have the original token
+		return data.ConsentToken, nil
+	}
+
+	if t != nil {
+		if !rejected && (t.expiry.IsZero() || time.Now().Add(TOKEN_EXPIRY_MARGIN).Before(t.expiry)) {
+			return t.access, nil
+		}
+		if t.refresh != "" {
+			refreshed, err := c.refreshPaymentToken(data.RequestId, t.refresh)
+			if err == nil {
+				return refreshed.access, nil
+			}
+			if !bank.IsTerminal(err) {
+				return "", err
+			}
+			// e.g. the refresh token has expired too
+			c.l.Warnw("Unable to refresh payment token",
+				"reqId", data.RequestId,
+				"error", err)
+			c.paymentTokens.dropRefresh(data.RequestId)
+		}
+	}
+
+	if !c.statusClientCredentials {
+		return "", &bank.BankError{
+			Operation: "check payment",
+			Status:    http.StatusUnauthorized,
+			Class:     bank.TERMINAL,
+			Cause:     errPaymentTokenExpired,
+		}
+	}
+
+	c.l.Debugw("Checking payment status with a client credentials token",
+		"reqId", data.RequestId)
+	if rejected {
+		c.tokens.Invalidate(c.clientCreds.ClientId, PAYMENTS_SCOPE)
+	}
+	at, err := c.tokens.Get(c.clientCreds.ClientId, PAYMENTS_SCOPE, func() (*bank.AccessToken, error) {
+		return c.fetchClientCredentialsToken(data.RequestId, PAYMENTS_SCOPE)
+	})
+	if err != nil {
+		return "", err
+	}
+	return at.Token, nil
+}
+
+// refreshPaymentToken exchanges a payment's refresh token for a new access token
+func (c *NatwestSandboxClient) refreshPaymentToken(requestId string, refreshToken string) (*paymentToken, error) {
+
+	c.l.Debugw("Refresh payment token",
+		"reqId", requestId)
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	}
+	if err := addClientAuth(form, c.clientCreds, c.signer, c.endpoints.OauthToken); err != nil {
+		return nil, err
+	}
+
+	var atResp AccessTokenResponse
+	err := c.retry.Do("refresh payment token", c.l, func() error {
+		resp, err := c.client.R().
+			SetHeader("Accept", "application/json").
+			SetHeader("Content-Type", "application/x-www-form-urlencoded").
+			SetBody(form.Encode()).
+			Post(c.endpoints.OauthToken)
+
+		if err != nil {
+			return bank.NewTransportError("refresh payment token", err)
+		}
+		if resp.StatusCode() != http.StatusOK {
+			return bank.NewBankError("refresh payment token", resp.StatusCode(), resp.Body(), resp.Header())
+		}
+		return json.Unmarshal(resp.Body(), &atResp)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.paymentTokens.put(requestId, &atResp), nil
+}
@@ -0,0 +1,171 @@
+package bank_impl
+
+import (
+	"encoding/json"
+	"errors"
+	resty "github.com/go-resty/resty/v2"
+	"github.com/google/uuid"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// REGISTRATION_LIFETIME of the registration request JWT
+const REGISTRATION_LIFETIME = 5 * time.Minute
+
+// DEFAULT_REGISTRATION_SCOPE requested for the client
+const DEFAULT_REGISTRATION_SCOPE = "openid payments accounts"
+
+// Registration holds the client metadata of an OB Dynamic Client Registration (v3.2)
+type Registration struct {
+	// SoftwareStatement is the SSA issued by the OB directory, as a signed JWT
+	SoftwareStatement string
+	// SoftwareId of the SSA. Read from the SSA if empty.
+	SoftwareId string
+	// Audience is the ASPSP's issuer, or its OB organisation ID
+	Audience        string
+	RedirectUris    []string
+	TokenAuthMethod string
+	// TlsClientAuthSubjectDn of the transport certificate, for tls_client_auth
+	TlsClientAuthSubjectDn string
+	// Scope requested. Defaults to DEFAULT_REGISTRATION_SCOPE.
+	Scope string
+}
+
+// RegistrationResponse holds the credentials issued by the ASPSP
+type RegistrationResponse struct {
+	ClientId                string `json:"client_id"`
+	ClientSecret            string `json:"client_secret,omitempty"`
+	ClientIdIssuedAt        int64  `json:"client_id_issued_at,omitempty"`
+	ClientSecretExpiresAt   int64  `json:"client_secret_expires_at,omitempty"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientUri   string `json:"registration_client_uri,omitempty"`
+}
+
+// RegistrationClient registers the TPP with an ASPSP
+type RegistrationClient struct {
+	client *resty.Client
+	url    string
+	signer *bank.JwsSigner
+	l      *zap.SugaredLogger
+}
+
+// NewRegistrationClient returns a client of the ASPSP's register endpoint at `url`.
+// The registration request is signed with the client's signing certificate, which is therefore required.
+func NewRegistrationClient(
+	timeout int,
+	url string,
+	creds *bank.OauthClientCreds,
+	_l *zap.SugaredLogger) (*RegistrationClient, error) {
+
+	if creds.SigningCert.PrivateKey == nil {
+		return nil, errors.New("Client registration requires a signing certificate")
+	}
+	signer, err := bank.NewJwsSigner(&creds.SigningCert, creds.SigningKeyId, creds.JwsIssuer)
+	if err != nil {
+		return nil, err
+	}
+	cl := http.Client{
+		Timeout:   time.Duration(timeout) * time.Second,
+		Transport: DefaultMiddleware(_l)(NewTlsTransport(creds)),
+	}
+	return &RegistrationClient{
+		client: resty.NewWithClient(&cl),
+		url:    url,
+		signer: signer,
+		l:      _l,
+	}, nil
+}
+
+// RequestJwt returns the signed registration request of the given metadata
+func (c *RegistrationClient) RequestJwt(reg *Registration) (string, error) {
+
+	if reg.SoftwareStatement == "" {
+		return "", errors.New("Missing software statement")
+	}
+	if len(reg.RedirectUris) == 0 {
+		return "", errors.New("Missing redirect URIs")
+	}
+	softwareId := reg.SoftwareId
+	if softwareId == "" {
+		ssa, err := bank.ParseJwtClaims(reg.SoftwareStatement)
+		if err != nil {
+			return "", errors.New("Invalid software statement: " + err.Error())
+		}
+		softwareId, _ = ssa["software_id"].(string)
+		if softwareId == "" {
+			return "", errors.New("Missing software_id in the software statement")
+		}
+	}
+	authMethod := reg.TokenAuthMethod
+	if authMethod == "" {
+		authMethod = bank.CLIENT_SECRET_POST
+	}
+	scope := reg.Scope
+	if scope == "" {
+		scope = DEFAULT_REGISTRATION_SCOPE
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":                          softwareId,
+		"aud":                          reg.Audience,
+		"iat":                          now.Unix(),
+		"exp":                          now.Add(REGISTRATION_LIFETIME).Unix(),
+		"jti":                          uuid.New().String(),
+		"software_id":                  softwareId,
+		"software_statement":           reg.SoftwareStatement,
+		"redirect_uris":                reg.RedirectUris,
+		"token_endpoint_auth_method":   authMethod,
+		"grant_types":                  []string{"authorization_code", "client_credentials", "refresh_token"},
+		"response_types":               []string{"code id_token"},
+		"application_type":             "web",
+		"scope":                        scope,
+		"id_token_signed_response_alg": bank.JWS_ALG,
+		"request_object_signing_alg":   bank.JWS_ALG,
+	}
+	switch authMethod {
+	case bank.PRIVATE_KEY_JWT:
+		claims["token_endpoint_auth_signing_alg"] = bank.JWS_ALG
+	case bank.TLS_CLIENT_AUTH:
+		if reg.TlsClientAuthSubjectDn == "" {
+			return "", errors.New("tls_client_auth requires the transport certificate's subject DN")
+		}
+		claims["tls_client_auth_subject_dn"] = reg.TlsClientAuthSubjectDn
+	}
+	return c.signer.SignJwt(claims)
+}
+
+// Register POSTs the signed registration request and returns the issued credentials
+func (c *RegistrationClient) Register(reg *Registration) (*RegistrationResponse, error) {
+
+	body, err := c.RequestJwt(reg)
+	if err != nil {
+		return nil, err
+	}
+
+	c.l.Infow("Client registration",
+		"url", c.url)
+
+	resp, err := c.client.R().
+		SetHeader("Accept", "application/json").
+		SetHeader("Content-Type", "application/jwt").
+		SetBody(body).
+		Post(c.url)
+	if err != nil {
+		return nil, bank.NewTransportError("client registration", err)
+	}
+	if resp.StatusCode() != http.StatusCreated {
+		return nil, bank.NewBankError("client registration", resp.StatusCode(), resp.Body(), resp.Header())
+	}
+
+	var rResp RegistrationResponse
+	if err = json.Unmarshal(resp.Body(), &rResp); err != nil {
+		return nil, err
+	}
+	if rResp.ClientId == "" {
+		return nil, errors.New("Missing client_id in the registration response")
+	}
+	return &rResp, nil
+}
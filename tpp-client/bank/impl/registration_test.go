@@ -0,0 +1,132 @@
+package bank_impl_test
+
+import (
+	"crypto/rsa"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	bank_mock "github.com/sgerogia/sol-stablecoin/tpp-client/bank/mock"
+	test_util "github.com/sgerogia/sol-stablecoin/tpp-client/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"testing"
+)
+
+// newRegistration returns the registration of a TPP signing with `creds`, with an SSA signed by the same key
+func newRegistration(t *testing.T, aspsp *bank_mock.AspspServer, creds *bank.OauthClientCreds) *bank_impl.Registration {
+	directory, err := bank.NewJwsSigner(&creds.SigningCert, "directory-kid", "OpenBanking Ltd")
+	require.NoError(t, err)
+	ssa, err := directory.SignJwt(map[string]interface{}{
+		"iss":         "OpenBanking Ltd",
+		"software_id": "software-1",
+	})
+	require.NoError(t, err)
+	return &bank_impl.Registration{
+		SoftwareStatement: ssa,
+		Audience:          aspsp.URL(),
+		RedirectUris:      []string{test_util.MockAspspInfo().RedirectUrl},
+	}
+}
+
+func newSigningCreds(t *testing.T) *bank.OauthClientCreds {
+	cert, err := test_util.NewSelfSignedCert("tpp.test")
+	require.NoError(t, err)
+	return &bank.OauthClientCreds{
+		RedirectionUrl: test_util.MockAspspInfo().RedirectUrl,
+		SigningCert:    *cert,
+		SigningKeyId:   "tpp-kid",
+		JwsIssuer:      "org/software-1",
+	}
+}
+
+func TestRegistrationClient_MockAspsp_Register(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	creds := newSigningCreds(t)
+	aspsp.TppSigningKey = &creds.SigningCert.PrivateKey.(*rsa.PrivateKey).PublicKey
+	client, err := bank_impl.NewRegistrationClient(5, aspsp.RegisterUrl(), creds, zap.NewExample().Sugar())
+	require.NoError(t, err)
+
+	// act
+	resp, err := client.Register(newRegistration(t, aspsp, creds))
+
+	// assert: the issued credentials get tokens
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.ClientId)
+	assert.NotEmpty(t, resp.ClientSecret)
+	creds.ClientId = resp.ClientId
+	creds.ClientSecret = resp.ClientSecret
+	natwest := bank_impl.NewNatwestClient(5, aspsp.Endpoints(), creds, zap.NewExample().Sugar())
+	_, err = natwest.GetPaymentAuthAccessToken("req")
+	assert.NoError(t, err)
+}
+
+func TestRegistrationClient_RequestJwt(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	creds := newSigningCreds(t)
+	client, err := bank_impl.NewRegistrationClient(5, aspsp.RegisterUrl(), creds, zap.NewExample().Sugar())
+	require.NoError(t, err)
+	reg := newRegistration(t, aspsp, creds)
+	reg.TokenAuthMethod = bank.PRIVATE_KEY_JWT
+
+	// act
+	jwt, err := client.RequestJwt(reg)
+
+	// assert
+	require.NoError(t, err)
+	claims, err := bank.VerifyJwt(jwt, bank.StaticKeyResolver(&creds.SigningCert.PrivateKey.(*rsa.PrivateKey).PublicKey))
+	require.NoError(t, err)
+	assert.Equal(t, "software-1", claims["iss"])
+	assert.Equal(t, "software-1", claims["software_id"])
+	assert.Equal(t, aspsp.URL(), claims["aud"])
+	assert.Equal(t, reg.SoftwareStatement, claims["software_statement"])
+	assert.Equal(t, bank.PRIVATE_KEY_JWT, claims["token_endpoint_auth_method"])
+	assert.Equal(t, bank.JWS_ALG, claims["token_endpoint_auth_signing_alg"])
+	assert.Equal(t, bank_impl.DEFAULT_REGISTRATION_SCOPE, claims["scope"])
+}
+
+func TestRegistrationClient_MockAspsp_Rejected(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(reg *bank_impl.Registration)
+		err    string
+	}{
+		{name: "wrong audience", modify: func(reg *bank_impl.Registration) { reg.Audience = "another-aspsp" }, err: "400"},
+		{name: "missing redirect URIs", modify: func(reg *bank_impl.Registration) { reg.RedirectUris = nil }, err: "redirect"},
+		{name: "tls_client_auth without DN", modify: func(reg *bank_impl.Registration) { reg.TokenAuthMethod = bank.TLS_CLIENT_AUTH }, err: "subject DN"},
+		{name: "SSA without software_id", modify: func(reg *bank_impl.Registration) { reg.SoftwareStatement = "e30.e30.sig" }, err: "software_id"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			aspsp := bank_mock.NewAspspServer()
+			defer aspsp.Close()
+			creds := newSigningCreds(t)
+			client, err := bank_impl.NewRegistrationClient(5, aspsp.RegisterUrl(), creds, zap.NewExample().Sugar())
+			require.NoError(t, err)
+			reg := newRegistration(t, aspsp, creds)
+			c.modify(reg)
+
+			// act
+			_, err = client.Register(reg)
+
+			// assert
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), c.err)
+		})
+	}
+}
+
+func TestNewRegistrationClient_RequiresSigningKey(t *testing.T) {
+
+	// act
+	_, err := bank_impl.NewRegistrationClient(5, "http://localhost/register", &bank.OauthClientCreds{}, zap.NewExample().Sugar())
+
+	// assert
+	assert.ErrorContains(t, err, "signing certificate")
+}
@@ -0,0 +1,85 @@
+package bank_impl
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"time"
+)
+
+// REQUEST_OBJECT_LIFETIME is the validity of the signed authorisation request
+const REQUEST_OBJECT_LIFETIME = 5 * time.Minute
+
+// OB_INTENT_ID_CLAIM links the authorisation request and the ID token to the consent
+const OB_INTENT_ID_CLAIM = "openbanking_intent_id"
+
+// authorizeParams returns the query parameters of the authorisation request of a consent, along with its
+// state and nonce. With a signing key the parameters are wrapped in a signed OIDC request object;
+// otherwise the consent ID is passed as the request, as expected by the sandbox in reduced security mode.
+func (c *NatwestSandboxClient) authorizeParams(consentId string) (url.Values, string, string, error) {
+
+	state, err := randomToken()
+	if err != nil {
+		return nil, "", "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	params := url.Values{
+		"client_id":     {c.clientCreds.ClientId},
+		"response_type": {"code id_token"},
+		"scope":         {"openid payments"},
+		"redirect_uri":  {c.clientCreds.RedirectionUrl},
+		"state":         {state},
+		"nonce":         {nonce},
+		"request":       {consentId},
+	}
+	if c.signerErr != nil {
+		return nil, "", "", c.signerErr
+	}
+	if c.signer == nil {
+		return params, state, nonce, nil
+	}
+
+	now := time.Now()
+	intent := map[string]interface{}{
+		OB_INTENT_ID_CLAIM: map[string]interface{}{
+			"value":     consentId,
+			"essential": true,
+		},
+	}
+	request, err := c.signer.SignJwt(map[string]interface{}{
+		"iss":           c.clientCreds.ClientId,
+		"aud":           c.endpoints.Issuer,
+		"client_id":     c.clientCreds.ClientId,
+		"response_type": "code id_token",
+		"scope":         "openid payments",
+		"redirect_uri":  c.clientCreds.RedirectionUrl,
+		"state":         state,
+		"nonce":         nonce,
+		"iat":           now.Unix(),
+		"nbf":           now.Unix(),
+		"exp":           now.Add(REQUEST_OBJECT_LIFETIME).Unix(),
+		"claims": map[string]interface{}{
+			"userinfo": intent,
+			"id_token": intent,
+		},
+	})
+	if err != nil {
+		return nil, "", "", errors.New("Error signing request object: " + err.Error())
+	}
+	params.Set("request", request)
+	return params, state, nonce, nil
+}
+
+// randomToken returns 128 random bits, base64url encoded
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
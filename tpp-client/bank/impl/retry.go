@@ -0,0 +1,83 @@
+package bank_impl
+
+import (
+	"errors"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"go.uber.org/zap"
+	"math/rand"
+	"time"
+)
+
+// DEFAULT_MAX_RETRY_AFTER is the longest Retry-After waited for, unless the policy sets its own
+const DEFAULT_MAX_RETRY_AFTER = 30 * time.Second
+
+// RetryPolicy retries retryable bank calls with exponential backoff and full jitter
+type RetryPolicy struct {
+	// MaxAttempts including the first one. 1 disables retries.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// MaxRetryAfter is the longest Retry-After waited for. A longer one ends the retries, leaving the call
+	// to the next cycle. 0 uses DEFAULT_MAX_RETRY_AFTER.
+	MaxRetryAfter time.Duration
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+	}
+}
+
+// Do calls `fn` until it succeeds, fails with a non-retryable error or the attempts run out.
+// Rate limited calls wait at least as long as the ASPSP asked for, up to MaxRetryAfter.
+func (p *RetryPolicy) Do(operation string, l *zap.SugaredLogger, fn func() error) error {
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || !bank.IsRetryable(err) || attempt >= p.MaxAttempts {
+			return err
+		}
+
+		wait := p.backoff(attempt)
+		var be *bank.BankError
+		if errors.As(err, &be) && be.RetryAfter > wait {
+			if be.RetryAfter > p.maxRetryAfter() {
+				// not stalling the calling task for that long
+				l.Warnw("Bank call not retried. Retry-After too long",
+					"operation", operation,
+					"attempt", attempt,
+					"retryAfter", be.RetryAfter,
+					"error", err)
+				return err
+			}
+			wait = be.RetryAfter
+		}
+		l.Warnw("Retrying bank call",
+			"operation", operation,
+			"attempt", attempt,
+			"wait", wait,
+			"error", err)
+		time.Sleep(wait)
+	}
+}
+
+func (p *RetryPolicy) maxRetryAfter() time.Duration {
+	if p.MaxRetryAfter > 0 {
+		return p.MaxRetryAfter
+	}
+	return DEFAULT_MAX_RETRY_AFTER
+}
+
+// backoff returns a random wait in [0, min(max, initial * 2^(attempt-1))]
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.InitialBackoff << (attempt - 1)
+	if ceiling > p.MaxBackoff || ceiling <= 0 {
+		ceiling = p.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
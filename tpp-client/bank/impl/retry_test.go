@@ -0,0 +1,143 @@
+package bank_impl_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_RetriesUntilSuccess(t *testing.T) {
+
+	// arrange
+	n := 0
+	fn := func() error {
+		n++
+		if n < 3 {
+			return bank.NewTransportError("op", errors.New("connection reset"))
+		}
+		return nil
+	}
+
+	// act
+	err := fastRetries().Do("op", zap.NewExample().Sugar(), fn)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+}
+
+func TestRetryPolicy_GivesUpAfterMaxAttempts(t *testing.T) {
+
+	// arrange
+	n := 0
+	fn := func() error {
+		n++
+		return bank.NewBankError("op", http.StatusServiceUnavailable, nil, nil)
+	}
+
+	// act
+	err := fastRetries().Do("op", zap.NewExample().Sugar(), fn)
+
+	// assert
+	assert.True(t, bank.IsRetryable(err))
+	assert.Equal(t, 3, n)
+}
+
+func TestRetryPolicy_NoRetryForNonRetryable(t *testing.T) {
+
+	// arrange
+	n := 0
+	fn := func() error {
+		n++
+		return errors.New("not a bank error")
+	}
+
+	// act
+	err := fastRetries().Do("op", zap.NewExample().Sugar(), fn)
+
+	// assert
+	assert.Error(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestRetryPolicy_HonoursRetryAfter(t *testing.T) {
+
+	// arrange
+	n := 0
+	fn := func() error {
+		n++
+		if n == 1 {
+			return bank.NewBankError("op", http.StatusTooManyRequests, nil, http.Header{"Retry-After": {"1"}})
+		}
+		return nil
+	}
+
+	// act
+	start := time.Now()
+	err := fastRetries().Do("op", zap.NewExample().Sugar(), fn)
+
+	// assert
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+}
+
+func TestRetryPolicy_GivesUpOnLongRetryAfter(t *testing.T) {
+
+	// arrange
+	n := 0
+	fn := func() error {
+		n++
+		return bank.NewBankError("op", http.StatusTooManyRequests, nil, http.Header{"Retry-After": {"3600"}})
+	}
+	policy := fastRetries()
+	policy.MaxRetryAfter = 2 * time.Second
+
+	// act
+	start := time.Now()
+	err := policy.Do("op", zap.NewExample().Sugar(), fn)
+
+	// assert: failed at once, rather than after an hour
+	assert.True(t, bank.IsRateLimited(err))
+	assert.Equal(t, 1, n)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestRetryPolicy_GivesUpOnLongRetryAfterOfWrappedError(t *testing.T) {
+
+	// arrange
+	n := 0
+	fn := func() error {
+		n++
+		return fmt.Errorf("wrapped: %w", bank.NewBankError("op", http.StatusTooManyRequests, nil, http.Header{"Retry-After": {"3600"}}))
+	}
+	policy := fastRetries()
+	policy.MaxRetryAfter = 2 * time.Second
+
+	// act
+	err := policy.Do("op", zap.NewExample().Sugar(), fn)
+
+	// assert
+	assert.True(t, bank.IsRateLimited(err))
+	assert.Equal(t, 1, n)
+}
+
+func TestIdempotencyKey_DeterministicPerRequestAndOperation(t *testing.T) {
+
+	// act
+	consent := bank_impl.IdempotencyKey("req", bank_impl.OP_CREATE_CONSENT)
+	payment := bank_impl.IdempotencyKey("req", bank_impl.OP_SUBMIT_PAYMENT)
+	other := bank_impl.IdempotencyKey("other", bank_impl.OP_SUBMIT_PAYMENT)
+
+	// assert
+	assert.Equal(t, consent, bank_impl.IdempotencyKey("req", bank_impl.OP_CREATE_CONSENT))
+	assert.Equal(t, payment, bank_impl.IdempotencyKey("req", bank_impl.OP_SUBMIT_PAYMENT))
+	assert.NotEqual(t, consent, payment)
+	assert.NotEqual(t, payment, other)
+	assert.LessOrEqual(t, len(payment), 40)
+}
@@ -0,0 +1,80 @@
+package bank_impl
+
+import (
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"sync"
+	"time"
+)
+
+// TOKEN_EXPIRY_MARGIN is subtracted from a token's lifetime, so that we never hand out a token about to expire
+const TOKEN_EXPIRY_MARGIN = 30 * time.Second
+
+// TokenCache caches Oauth2 access tokens, keyed by client and scope.
+// Concurrent requests for the same key result in a single fetch; the rest of the callers wait for its result.
+type TokenCache struct {
+	margin  time.Duration
+	mu      sync.Mutex
+	entries map[string]*tokenEntry
+}
+
+type tokenEntry struct {
+	// serialises fetches for the key
+	mu        sync.Mutex
+	token     *bank.AccessToken
+	expiresAt time.Time
+}
+
+func NewTokenCache(margin time.Duration) *TokenCache {
+	return &TokenCache{
+		margin:  margin,
+		entries: make(map[string]*tokenEntry),
+	}
+}
+
+// Get returns the cached token for the client and scope, if still valid.
+// Otherwise it calls `fetch` and caches the new token for its `ExpiresIn` minus the safety margin.
+// The returned token's `ExpiresIn` is the remaining lifetime in seconds.
+func (c *TokenCache) Get(clientId string, scope string, fetch func() (*bank.AccessToken, error)) (*bank.AccessToken, error) {
+
+	e := c.entry(clientId, scope)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if e.token != nil && now.Before(e.expiresAt) {
+		return &bank.AccessToken{
+			Token:     e.token.Token,
+			ExpiresIn: int(e.expiresAt.Add(c.margin).Sub(now).Seconds()),
+		}, nil
+	}
+
+	t, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	e.token = t
+	e.expiresAt = now.Add(time.Duration(t.ExpiresIn)*time.Second - c.margin)
+
+	return t, nil
+}
+
+// Invalidate drops the cached token for the client and scope, e.g. after the ASPSP rejected it
+func (c *TokenCache) Invalidate(clientId string, scope string) {
+	e := c.entry(clientId, scope)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.token = nil
+}
+
+func (c *TokenCache) entry(clientId string, scope string) *tokenEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := clientId + "|" + scope
+	e := c.entries[key]
+	if e == nil {
+		e = &tokenEntry{}
+		c.entries[key] = e
+	}
+	return e
+}
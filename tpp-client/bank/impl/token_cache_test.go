@@ -0,0 +1,130 @@
+package bank_impl_test
+
+import (
+	"errors"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	bank_mock "github.com/sgerogia/sol-stablecoin/tpp-client/bank/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenCache_ConcurrentCallersFetchOnce(t *testing.T) {
+
+	// arrange
+	cache := bank_impl.NewTokenCache(bank_impl.TOKEN_EXPIRY_MARGIN)
+	var fetches int32
+	fetch := func() (*bank.AccessToken, error) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(50 * time.Millisecond)
+		return &bank.AccessToken{Token: "tok", ExpiresIn: 3600}, nil
+	}
+
+	// act
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			at, err := cache.Get("client", "payments", fetch)
+			assert.NoError(t, err)
+			assert.Equal(t, "tok", at.Token)
+		}()
+	}
+	wg.Wait()
+
+	// assert
+	assert.Equal(t, int32(1), fetches)
+}
+
+func TestTokenCache_KeyedByClientAndScope(t *testing.T) {
+
+	// arrange
+	cache := bank_impl.NewTokenCache(0)
+	n := 0
+	fetch := func() (*bank.AccessToken, error) {
+		n++
+		return &bank.AccessToken{Token: "tok", ExpiresIn: 3600}, nil
+	}
+
+	// act
+	cache.Get("client", "payments", fetch)
+	cache.Get("client", "accounts", fetch)
+	cache.Get("other", "payments", fetch)
+	cache.Get("client", "payments", fetch)
+
+	// assert
+	assert.Equal(t, 3, n)
+}
+
+func TestTokenCache_RefreshesWithinMargin(t *testing.T) {
+
+	// arrange
+	cache := bank_impl.NewTokenCache(500 * time.Millisecond)
+	n := 0
+	fetch := func() (*bank.AccessToken, error) {
+		n++
+		return &bank.AccessToken{Token: "tok", ExpiresIn: 1}, nil
+	}
+
+	// act
+	cache.Get("client", "payments", fetch)
+	cache.Get("client", "payments", fetch)
+	time.Sleep(600 * time.Millisecond)
+	cache.Get("client", "payments", fetch)
+
+	// assert
+	assert.Equal(t, 2, n)
+}
+
+func TestTokenCache_ErrorsAndInvalidationNotCached(t *testing.T) {
+
+	// arrange
+	cache := bank_impl.NewTokenCache(0)
+	n := 0
+	failing := func() (*bank.AccessToken, error) {
+		n++
+		return nil, errors.New("boom")
+	}
+	ok := func() (*bank.AccessToken, error) {
+		n++
+		return &bank.AccessToken{Token: "tok", ExpiresIn: 3600}, nil
+	}
+
+	// act
+	_, err := cache.Get("client", "payments", failing)
+	require.Error(t, err)
+	cache.Get("client", "payments", ok)
+	cache.Invalidate("client", "payments")
+	cache.Get("client", "payments", ok)
+
+	// assert
+	assert.Equal(t, 3, n)
+}
+
+func TestNatwestClient_MockAspsp_BurstUsesCachedToken(t *testing.T) {
+
+	// arrange
+	aspsp := bank_mock.NewAspspServer()
+	defer aspsp.Close()
+	client := newMockClient(aspsp)
+
+	// act
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.GetPaymentAuthAccessToken("req")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// assert
+	assert.Equal(t, 1, aspsp.CallCount(bank_mock.TOKEN))
+}
@@ -0,0 +1,183 @@
+package bank
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// JWKS_CACHE_TTL is how long a fetched JWKS is used before fetching it again
+	JWKS_CACHE_TTL = 1 * time.Hour
+	// JWKS_MIN_REFRESH is the minimum interval between fetches triggered by unknown key IDs
+	JWKS_MIN_REFRESH = 1 * time.Minute
+	// JWKS_FAILURE_BACKOFF is how long a failed fetch is not retried, e.g. while the JWKS endpoint is down
+	JWKS_FAILURE_BACKOFF = 30 * time.Second
+)
+
+// Jwk is a public JSON Web Key. Only RSA keys are supported.
+type Jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// Jwks is a JSON Web Key Set, as published by an ASPSP
+type Jwks struct {
+	Keys []Jwk `json:"keys"`
+}
+
+// RsaJwk returns the JWK of an RSA signing key
+func RsaJwk(kid string, key *rsa.PublicKey) Jwk {
+	return Jwk{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: JWS_ALG,
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// PublicKey returns the RSA key of the JWK
+func (j *Jwk) PublicKey() (*rsa.PublicKey, error) {
+	if j.Kty != "RSA" {
+		return nil, errors.New("Unsupported JWK key type: " + j.Kty)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(j.N)
+	if err != nil {
+		return nil, errors.New("Malformed JWK modulus: " + err.Error())
+	}
+	e, err := base64.RawURLEncoding.DecodeString(j.E)
+	if err != nil {
+		return nil, errors.New("Malformed JWK exponent: " + err.Error())
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// Find returns the key with the given ID
+func (s *Jwks) Find(kid string) (*Jwk, bool) {
+	for i := range s.Keys {
+		if s.Keys[i].Kid == kid {
+			return &s.Keys[i], true
+		}
+	}
+	return nil, false
+}
+
+// FetchJwks downloads a JWKS
+func FetchJwks(client *http.Client, url string) (*Jwks, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, errors.New("Error fetching JWKS: " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Error fetching JWKS: " + resp.Status)
+	}
+	var jwks Jwks
+	if err = json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, errors.New("Malformed JWKS: " + err.Error())
+	}
+	return &jwks, nil
+}
+
+// JwksCache keeps the keys of a JWKS, fetching it again once stale or when it meets an unknown key ID,
+// i.e. after the ASPSP has rotated its keys
+type JwksCache struct {
+	client *http.Client
+	url    string
+	// Ttl of the fetched keys
+	Ttl time.Duration
+	// MinRefreshInterval limits the fetches caused by unknown key IDs
+	MinRefreshInterval time.Duration
+	// FailureBackoff after a failed fetch, during which the last good keys are used, even if stale,
+	// and the failure is returned for the others
+	FailureBackoff time.Duration
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	// failed is the time of the last failed fetch, and failure its error
+	failed  time.Time
+	failure error
+}
+
+// NewJwksCache returns an empty cache of the JWKS at the given URL
+func NewJwksCache(client *http.Client, url string) *JwksCache {
+	return &JwksCache{
+		client:             client,
+		url:                url,
+		Ttl:                JWKS_CACHE_TTL,
+		MinRefreshInterval: JWKS_MIN_REFRESH,
+		FailureBackoff:     JWKS_FAILURE_BACKOFF,
+	}
+}
+
+// Resolve returns the key with the given ID. It is a `JwsKeyResolver`.
+func (c *JwksCache) Resolve(kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stale := time.Since(c.fetched) > c.Ttl
+	if key, ok := c.keys[kid]; ok && !stale {
+		return key, nil
+	}
+	if time.Since(c.failed) < c.FailureBackoff {
+		// not hammering an endpoint which has just failed
+		if key, ok := c.keys[kid]; ok {
+			return key, nil
+		}
+		return nil, c.failure
+	}
+	if stale || time.Since(c.fetched) >= c.MinRefreshInterval {
+		if err := c.refresh(); err != nil {
+			c.failed = time.Now()
+			c.failure = err
+			// keep using the keys we have, e.g. while the ASPSP's JWKS endpoint is down
+			if key, ok := c.keys[kid]; ok {
+				return key, nil
+			}
+			return nil, err
+		}
+	}
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.New("Key not found in JWKS: " + kid)
+}
+
+// refresh replaces the cached keys. Keys which cannot be parsed are skipped.
+func (c *JwksCache) refresh() error {
+	jwks, err := FetchJwks(c.client, c.url)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for i := range jwks.Keys {
+		if key, err := jwks.Keys[i].PublicKey(); err == nil {
+			keys[jwks.Keys[i].Kid] = key
+		}
+	}
+	c.keys = keys
+	c.fetched = time.Now()
+	c.failed = time.Time{}
+	return nil
+}
+
+// JwksKeyResolver resolves key IDs against the JWKS at the given URL, cached with the default settings
+func JwksKeyResolver(client *http.Client, url string) JwsKeyResolver {
+	return NewJwksCache(client, url).Resolve
+}
@@ -0,0 +1,144 @@
+package bank_test
+
+import (
+	"encoding/json"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	test_util "github.com/sgerogia/sol-stablecoin/tpp-client/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// jwksServer publishes a changeable key set and counts the fetches
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []bank.Jwk
+	fetches int
+	// down fails the fetches
+	down bool
+}
+
+func newJwksServer(keys ...bank.Jwk) *jwksServer {
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+		if s.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(bank.Jwks{Keys: s.keys})
+	}))
+	return s
+}
+
+func newTestJwk(t *testing.T, kid string) bank.Jwk {
+	cert, err := test_util.NewSelfSignedCert("aspsp.test")
+	require.NoError(t, err)
+	signer, err := bank.NewJwsSigner(cert, kid, "aspsp")
+	require.NoError(t, err)
+	return signer.Jwk()
+}
+
+func TestJwksCache_CachesKeys(t *testing.T) {
+
+	// arrange
+	server := newJwksServer(newTestJwk(t, "kid-1"))
+	defer server.Close()
+	cache := bank.NewJwksCache(server.Client(), server.URL)
+
+	// act
+	first, err1 := cache.Resolve("kid-1")
+	second, err2 := cache.Resolve("kid-1")
+
+	// assert
+	require.NoError(t, err1)
+	require.NoError(t, err2)
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, server.fetches)
+}
+
+func TestJwksCache_RefetchesOnRotation(t *testing.T) {
+
+	// arrange
+	server := newJwksServer(newTestJwk(t, "kid-1"))
+	defer server.Close()
+	cache := bank.NewJwksCache(server.Client(), server.URL)
+	cache.MinRefreshInterval = 0
+	_, err := cache.Resolve("kid-1")
+	require.NoError(t, err)
+	server.mu.Lock()
+	server.keys = []bank.Jwk{newTestJwk(t, "kid-2")}
+	server.mu.Unlock()
+
+	// act
+	key, err := cache.Resolve("kid-2")
+
+	// assert
+	require.NoError(t, err)
+	assert.NotNil(t, key)
+	assert.Equal(t, 2, server.fetches)
+	_, err = cache.Resolve("kid-1")
+	assert.Error(t, err, "rotated out keys are dropped")
+}
+
+func TestJwksCache_LimitsRefetches(t *testing.T) {
+
+	// arrange
+	server := newJwksServer(newTestJwk(t, "kid-1"))
+	defer server.Close()
+	cache := bank.NewJwksCache(server.Client(), server.URL)
+	_, err := cache.Resolve("kid-1")
+	require.NoError(t, err)
+
+	// act
+	_, err1 := cache.Resolve("unknown")
+	_, err2 := cache.Resolve("unknown")
+
+	// assert
+	assert.Error(t, err1)
+	assert.Error(t, err2)
+	assert.Equal(t, 1, server.fetches)
+}
+
+func TestJwksCache_BacksOffWhileDown(t *testing.T) {
+
+	// arrange: keys fetched, then the endpoint goes down once they are stale
+	server := newJwksServer(newTestJwk(t, "kid-1"))
+	defer server.Close()
+	cache := bank.NewJwksCache(server.Client(), server.URL)
+	cached, err := cache.Resolve("kid-1")
+	require.NoError(t, err)
+	cache.Ttl = 0
+	server.mu.Lock()
+	server.down = true
+	server.mu.Unlock()
+
+	// act
+	var keyErrs, unknownErrs []error
+	for i := 0; i < 3; i++ {
+		key, err := cache.Resolve("kid-1")
+		assert.Equal(t, cached, key)
+		keyErrs = append(keyErrs, err)
+		_, err = cache.Resolve("unknown")
+		unknownErrs = append(unknownErrs, err)
+	}
+
+	// assert: the last good keys are served, and the endpoint is only tried once
+	for i := range keyErrs {
+		assert.NoError(t, keyErrs[i])
+		assert.ErrorContains(t, unknownErrs[i], "503")
+	}
+	assert.Equal(t, 2, server.fetches)
+
+	// ...until the backoff is over
+	cache.FailureBackoff = 0
+	_, err = cache.Resolve("kid-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, server.fetches)
+}
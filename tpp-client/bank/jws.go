@@ -0,0 +1,264 @@
+package bank
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// JWS_ALG is the only signing algorithm allowed by the OB security profile
+const JWS_ALG = "PS256"
+
+// OB specific protected header claims of a detached JWS
+const (
+	OB_IAT_HEADER     = "http://openbanking.org.uk/iat"
+	OB_ISS_HEADER     = "http://openbanking.org.uk/iss"
+	OB_TAN_HEADER     = "http://openbanking.org.uk/tan"
+	OB_TRUST_ANCHOR   = "openbanking.org.uk"
+	JWS_SIGNATURE_HDR = "x-jws-signature"
+)
+
+// JwsKeyResolver returns the public key for a given key ID, e.g. from a static certificate or the ASPSP's JWKS
+type JwsKeyResolver func(kid string) (crypto.PublicKey, error)
+
+// JwsSigner creates PS256 signatures with the TPP's signing key
+type JwsSigner struct {
+	key         *rsa.PrivateKey
+	kid         string
+	issuer      string
+	trustAnchor string
+}
+
+// NewJwsSigner returns a signer using the private key of the given certificate.
+// If `kid` is empty, the base64url SHA-1 thumbprint of the certificate (x5t) is used instead.
+func NewJwsSigner(cert *tls.Certificate, kid string, issuer string) (*JwsSigner, error) {
+
+	key, ok := cert.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("Signing key must be an RSA private key")
+	}
+	if kid == "" {
+		if len(cert.Certificate) == 0 {
+			return nil, errors.New("Signing key ID is required when no certificate is present")
+		}
+		thumb := sha1.Sum(cert.Certificate[0])
+		kid = base64.RawURLEncoding.EncodeToString(thumb[:])
+	}
+	return &JwsSigner{
+		key:         key,
+		kid:         kid,
+		issuer:      issuer,
+		trustAnchor: OB_TRUST_ANCHOR,
+	}, nil
+}
+
+// KeyId returns the `kid` put in the signatures' header
+func (s *JwsSigner) KeyId() string {
+	return s.kid
+}
+
+// Jwk returns the public JWK of the signing key, e.g. to publish it in a JWKS
+func (s *JwsSigner) Jwk() Jwk {
+	return RsaJwk(s.kid, &s.key.PublicKey)
+}
+
+// SignDetached returns an OB detached JWS (`header..signature`) of the payload, with unencoded payload (b64=false).
+// The payload must be the exact bytes sent over the wire.
+func (s *JwsSigner) SignDetached(payload []byte) (string, error) {
+
+	header := map[string]interface{}{
+		"alg":         JWS_ALG,
+		"kid":         s.kid,
+		"typ":         "JOSE",
+		"cty":         "application/json",
+		"b64":         false,
+		OB_IAT_HEADER: time.Now().Unix(),
+		OB_ISS_HEADER: s.issuer,
+		OB_TAN_HEADER: s.trustAnchor,
+		"crit":        []string{"b64", OB_IAT_HEADER, OB_ISS_HEADER, OB_TAN_HEADER},
+	}
+	h, err := encodeSegment(header)
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := s.sign(append([]byte(h+"."), payload...))
+	if err != nil {
+		return "", err
+	}
+	return h + ".." + sig, nil
+}
+
+// sign returns the base64url PS256 signature of the signing input
+func (s *JwsSigner) sign(input []byte) (string, error) {
+	digest := sha256.Sum256(input)
+	sig, err := rsa.SignPSS(rand.Reader, s.key, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	if err != nil {
+		return "", errors.New("Error signing JWS: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// SignJwt returns a compact PS256 JWS of the given claims, e.g. a client assertion or a request object
+func (s *JwsSigner) SignJwt(claims map[string]interface{}) (string, error) {
+
+	header := map[string]interface{}{
+		"alg": JWS_ALG,
+		"kid": s.kid,
+		"typ": "JWT",
+	}
+	h, err := encodeSegment(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := encodeSegment(claims)
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := s.sign([]byte(h + "." + c))
+	if err != nil {
+		return "", err
+	}
+	return h + "." + c + "." + sig, nil
+}
+
+// VerifyJwt verifies the signature of a compact JWS and returns its claims.
+// Validating the claims themselves (exp, aud,...) is left to the caller.
+func VerifyJwt(token string, resolve JwsKeyResolver) (map[string]interface{}, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Malformed JWT")
+	}
+
+	var header map[string]interface{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.New("Malformed JWT header: " + err.Error())
+	}
+	if err := verifySignature(header, []byte(parts[0]+"."+parts[1]), parts[2], resolve); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.New("Malformed JWT claims: " + err.Error())
+	}
+	return claims, nil
+}
+
+// ParseJwtClaims returns the claims of a compact JWS WITHOUT verifying its signature.
+// Only use it for tokens whose integrity is guaranteed by other means.
+func ParseJwtClaims(token string) (map[string]interface{}, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Malformed JWT")
+	}
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.New("Malformed JWT claims: " + err.Error())
+	}
+	return claims, nil
+}
+
+// VerifyDetached verifies an OB detached JWS against the payload it was sent with
+func VerifyDetached(signature string, payload []byte, resolve JwsKeyResolver) error {
+
+	parts := strings.Split(signature, ".")
+	if len(parts) != 3 || parts[1] != "" {
+		return errors.New("Malformed detached JWS")
+	}
+
+	var header map[string]interface{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return errors.New("Malformed JWS header: " + err.Error())
+	}
+	if b64, ok := header["b64"].(bool); !ok || b64 {
+		return errors.New("Detached JWS must have b64=false")
+	}
+	if err := checkCritical(header); err != nil {
+		return err
+	}
+
+	return verifySignature(header, append([]byte(parts[0]+"."), payload...), parts[2], resolve)
+}
+
+// checkCritical makes sure we understand all the critical header parameters
+func checkCritical(header map[string]interface{}) error {
+	crit, ok := header["crit"].([]interface{})
+	if !ok {
+		return nil
+	}
+	for _, c := range crit {
+		name, _ := c.(string)
+		switch name {
+		case "b64", OB_IAT_HEADER, OB_ISS_HEADER, OB_TAN_HEADER:
+			if _, present := header[name]; !present {
+				return errors.New("Critical JWS header missing: " + name)
+			}
+		default:
+			return errors.New("Unsupported critical JWS header: " + name)
+		}
+	}
+	return nil
+}
+
+// verifySignature checks the algorithm, resolves the key and verifies the signature over the signing input
+func verifySignature(header map[string]interface{}, input []byte, signature string, resolve JwsKeyResolver) error {
+
+	alg, _ := header["alg"].(string)
+	if alg != JWS_ALG {
+		return errors.New("Unsupported JWS algorithm: " + alg)
+	}
+	kid, _ := header["kid"].(string)
+	key, err := resolve(kid)
+	if err != nil {
+		return errors.New("Unable to resolve JWS key '" + kid + "': " + err.Error())
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("JWS key is not an RSA public key: " + kid)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return errors.New("Malformed JWS signature: " + err.Error())
+	}
+
+	digest := sha256.Sum256(input)
+	if err = rsa.VerifyPSS(rsaKey, crypto.SHA256, digest[:], sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}); err != nil {
+		return errors.New("Invalid JWS signature: " + err.Error())
+	}
+	return nil
+}
+
+// StaticKeyResolver resolves any key ID to the given public key, e.g. a pinned ASPSP signing certificate
+func StaticKeyResolver(key crypto.PublicKey) JwsKeyResolver {
+	return func(kid string) (crypto.PublicKey, error) {
+		return key, nil
+	}
+}
+
+func encodeSegment(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
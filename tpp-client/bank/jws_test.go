@@ -0,0 +1,96 @@
+package bank_test
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	test_util "github.com/sgerogia/sol-stablecoin/tpp-client/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestJwsSigner_SignDetached_Header(t *testing.T) {
+
+	// arrange
+	cert, err := test_util.NewSelfSignedCert("tpp.test")
+	require.NoError(t, err)
+	signer, err := bank.NewJwsSigner(cert, "kid-1", "org/ssa")
+	require.NoError(t, err)
+
+	// act
+	sig, err := signer.SignDetached([]byte(`{"Data":{}}`))
+	require.NoError(t, err)
+
+	// assert
+	parts := strings.Split(sig, ".")
+	require.Len(t, parts, 3)
+	assert.Empty(t, parts[1], "payload must be detached")
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	require.NoError(t, err)
+	var header map[string]interface{}
+	require.NoError(t, json.Unmarshal(raw, &header))
+	assert.Equal(t, "PS256", header["alg"])
+	assert.Equal(t, "kid-1", header["kid"])
+	assert.Equal(t, false, header["b64"])
+	assert.Equal(t, "org/ssa", header[bank.OB_ISS_HEADER])
+	assert.Equal(t, bank.OB_TRUST_ANCHOR, header[bank.OB_TAN_HEADER])
+	assert.NotNil(t, header[bank.OB_IAT_HEADER])
+	assert.ElementsMatch(t,
+		[]interface{}{"b64", bank.OB_IAT_HEADER, bank.OB_ISS_HEADER, bank.OB_TAN_HEADER},
+		header["crit"])
+}
+
+func TestJwsSigner_DefaultKidIsThumbprint(t *testing.T) {
+	cert, err := test_util.NewSelfSignedCert("tpp.test")
+	require.NoError(t, err)
+
+	signer, err := bank.NewJwsSigner(cert, "", "org/ssa")
+
+	require.NoError(t, err)
+	assert.NotEmpty(t, signer.KeyId())
+}
+
+func TestVerifyDetached(t *testing.T) {
+
+	// arrange
+	cert, err := test_util.NewSelfSignedCert("tpp.test")
+	require.NoError(t, err)
+	other, err := test_util.NewSelfSignedCert("other.test")
+	require.NoError(t, err)
+	signer, err := bank.NewJwsSigner(cert, "kid-1", "org/ssa")
+	require.NoError(t, err)
+	payload := []byte(`{"Data":{"Amount":"1.00"}}`)
+	sig, err := signer.SignDetached(payload)
+	require.NoError(t, err)
+	pub := &cert.PrivateKey.(*rsa.PrivateKey).PublicKey
+	otherPub := &other.PrivateKey.(*rsa.PrivateKey).PublicKey
+
+	// act & assert
+	assert.NoError(t, bank.VerifyDetached(sig, payload, bank.StaticKeyResolver(pub)))
+	assert.Error(t, bank.VerifyDetached(sig, []byte(`{"Data":{"Amount":"100.00"}}`), bank.StaticKeyResolver(pub)))
+	assert.Error(t, bank.VerifyDetached(sig, payload, bank.StaticKeyResolver(otherPub)))
+	assert.Error(t, bank.VerifyDetached("not-a-jws", payload, bank.StaticKeyResolver(pub)))
+}
+
+func TestParseJwtClaims_DoesNotVerify(t *testing.T) {
+
+	// arrange
+	cert, err := test_util.NewSelfSignedCert("tpp.test")
+	require.NoError(t, err)
+	signer, err := bank.NewJwsSigner(cert, "kid", "iss")
+	require.NoError(t, err)
+	token, err := signer.SignJwt(map[string]interface{}{"nonce": "abc"})
+	require.NoError(t, err)
+
+	// act
+	claims, err := bank.ParseJwtClaims(token[:len(token)-4] + "AAAA")
+	_, errMalformed := bank.ParseJwtClaims("not-a-jwt")
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, "abc", claims["nonce"])
+	assert.Error(t, errMalformed)
+}
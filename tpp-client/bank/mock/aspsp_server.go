@@ -0,0 +1,1170 @@
+package bank_mock
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ----- WARNING! TESTING CODE ONLY! ------
+// An in-process imitation of an ASPSP, following the Natwest sandbox API layout.
+// It keeps just enough state to drive a full consent -> authorisation -> payment -> settlement cycle.
+// -----
+
+const (
+	TOKEN_PATH     = "/token"
+	CONSENT_PATH   = "/open-banking/v3.1/pisp/domestic-payment-consents"
+	AUTHORIZE_PATH = "/authorize"
+	PAYMENT_PATH   = "/open-banking/v3.1/pisp/domestic-payments"
+	LOGIN_PATH     = "/login"
+	JWKS_PATH      = "/jwks"
+
+	ACCOUNT_ACCESS_CONSENT_PATH = "/open-banking/v3.1/aisp/account-access-consents"
+	ACCOUNTS_PATH               = "/open-banking/v3.1/aisp/accounts"
+	REGISTER_PATH               = "/register"
+	// NAME_VERIFICATION_PATH of the Confirmation of Payee style name checks
+	NAME_VERIFICATION_PATH = "/confirmation-of-payee/v1/accounts/name-verification"
+)
+
+// Endpoint names, used to script the server's behaviour and count calls
+const (
+	TOKEN              = "token"
+	CONSENT            = "consent"
+	AUTHORIZE          = "authorize"
+	PAYMENT            = "payment"
+	PAYMENT_STATUS     = "paymentStatus"
+	CONSENT_STATUS     = "consentStatus"
+	FUNDS_CONFIRMATION = "fundsConfirmation"
+	ACCOUNT_ACCESS     = "accountAccess"
+	ACCOUNTS           = "accounts"
+	TRANSACTIONS       = "transactions"
+	NAME_VERIFICATION  = "nameVerification"
+	REGISTRATION       = "registration"
+)
+
+// Behaviour scripts the response of an endpoint
+type Behaviour struct {
+	// Delay is applied before every response
+	Delay time.Duration
+	// FailStatus is the HTTP status returned instead of the normal response. 0 means no failure.
+	FailStatus int
+	// FailTimes is the number of calls to fail before succeeding. Negative means fail forever.
+	FailTimes int
+	// FailBody is the body returned with FailStatus
+	FailBody string
+	// LoseResponse processes failing calls normally but returns the failure instead of the response,
+	// as if the response was lost on the way back
+	LoseResponse bool
+}
+
+// AspspServer is a mock ASPSP backed by `httptest.Server`
+type AspspServer struct {
+	ClientId     string
+	ClientSecret string
+	// TppSigningKey if set, the signatures of POST requests are verified against it
+	TppSigningKey crypto.PublicKey
+	// Signer if set, the consent and payment responses are signed with it
+	Signer *bank.JwsSigner
+	// TokenLifetime of the access tokens issued. Defaults to 1 hour.
+	TokenLifetime time.Duration
+	// NoRefreshTokens if set, the authorization_code grant does not return a refresh token
+	NoRefreshTokens bool
+	// TransactionPageSize if set, the transactions are listed in pages of that size, linked by Links.Next
+	TransactionPageSize int
+
+	server     *httptest.Server
+	mu         sync.Mutex
+	behaviours map[string]*Behaviour
+	calls      map[string]int
+	headers    map[string]http.Header
+	approve    bool
+	funds      bool
+	statuses   []bank.PaymentStatus
+	consents   map[string]*mockConsent
+	codes      map[string]string
+	tokens     map[string]*mockToken
+	refreshes  map[string]string
+	payments   map[string]*mockPayment
+	replies    map[string]*recordedReply
+	bookCredit bool
+	credits    []*mockCredit
+	// accountNames held for name verification, keyed by identification
+	accountNames map[string]string
+}
+
+type mockConsent struct {
+	Id      string
+	Status  string
+	Request map[string]interface{}
+	// AccountAccess is set for AISP consents
+	AccountAccess bool
+}
+
+// mockCredit is a payment booked to the creditor account
+type mockCredit struct {
+	TransactionId  string
+	SchemeName     string
+	Identification string
+	Amount         string
+	Currency       string
+	EndToEndId     string
+	BookedAt       time.Time
+}
+
+type mockToken struct {
+	ConsentId string // empty for client_credentials tokens
+	Expiry    time.Time
+}
+
+// recordedReply is the response to an idempotent request, replayed if the request is repeated
+type recordedReply struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+type mockPayment struct {
+	Id        string
+	ConsentId string
+	polls     int
+}
+
+// NewAspspServer starts a mock ASPSP which accepts any client credentials,
+// auto-approves consents and settles payments on the 2nd status check.
+func NewAspspServer() *AspspServer {
+	s := newAspspServer()
+	s.server.Start()
+	return s
+}
+
+// NewAspspTlsServer starts a mock ASPSP over TLS, which requires client certificates issued by the given CAs
+func NewAspspTlsServer(clientCAs *x509.CertPool) *AspspServer {
+	s := newAspspServer()
+	s.server.TLS = &tls.Config{
+		ClientCAs:  clientCAs,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}
+	s.server.StartTLS()
+	return s
+}
+
+func newAspspServer() *AspspServer {
+	s := &AspspServer{
+		behaviours:   make(map[string]*Behaviour),
+		calls:        make(map[string]int),
+		headers:      make(map[string]http.Header),
+		approve:      true,
+		funds:        true,
+		statuses:     []bank.PaymentStatus{bank.PAYMENT_PENDING, bank.PAYMENT_ACCEPTED_SETTLEMENT_COMPLETED},
+		consents:     make(map[string]*mockConsent),
+		codes:        make(map[string]string),
+		tokens:       make(map[string]*mockToken),
+		refreshes:    make(map[string]string),
+		bookCredit:   true,
+		payments:     make(map[string]*mockPayment),
+		replies:      make(map[string]*recordedReply),
+		accountNames: make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(TOKEN_PATH, s.scripted(TOKEN, s.handleToken))
+	mux.HandleFunc(CONSENT_PATH, s.scripted(CONSENT, s.idempotent(CONSENT, s.handleConsent)))
+	consentStatus := s.scripted(CONSENT_STATUS, s.handleConsentStatus)
+	fundsConfirmation := s.scripted(FUNDS_CONFIRMATION, s.handleFundsConfirmation)
+	mux.HandleFunc(CONSENT_PATH+"/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/funds-confirmation") {
+			fundsConfirmation(w, r)
+		} else {
+			consentStatus(w, r)
+		}
+	})
+	mux.HandleFunc(AUTHORIZE_PATH, s.scripted(AUTHORIZE, s.handleAuthorize))
+	mux.HandleFunc(PAYMENT_PATH, s.scripted(PAYMENT, s.idempotent(PAYMENT, s.handlePayment)))
+	mux.HandleFunc(PAYMENT_PATH+"/", s.scripted(PAYMENT_STATUS, s.handlePaymentStatus))
+	mux.HandleFunc(JWKS_PATH, s.handleJwks)
+	mux.HandleFunc(ACCOUNT_ACCESS_CONSENT_PATH, s.scripted(ACCOUNT_ACCESS, s.handleAccountAccessConsent))
+	mux.HandleFunc(ACCOUNTS_PATH, s.scripted(ACCOUNTS, s.handleAccounts))
+	mux.HandleFunc(ACCOUNTS_PATH+"/", s.scripted(TRANSACTIONS, s.handleTransactions))
+	mux.HandleFunc(REGISTER_PATH, s.scripted(REGISTRATION, s.handleRegister))
+	mux.HandleFunc(NAME_VERIFICATION_PATH, s.scripted(NAME_VERIFICATION, s.handleNameVerification))
+	mux.HandleFunc(LOGIN_PATH, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("<html><body>Mock ASPSP login</body></html>"))
+	})
+	s.server = httptest.NewUnstartedServer(echoInteractionId(mux))
+
+	return s
+}
+
+// URL returns the base URL of the server
+func (s *AspspServer) URL() string {
+	return s.server.URL
+}
+
+// Endpoints returns the endpoints to configure a Natwest client with
+func (s *AspspServer) Endpoints() *bank_impl.NatwestEndpoints {
+	return &bank_impl.NatwestEndpoints{
+		OauthToken:           s.URL() + TOKEN_PATH,
+		CreatePaymentConsent: s.URL() + CONSENT_PATH,
+		CreateAuthUrl:        s.URL() + AUTHORIZE_PATH,
+		ExecutePayment:       s.URL() + PAYMENT_PATH,
+		CheckPayment:         s.URL() + PAYMENT_PATH + "/",
+		Issuer:               s.URL(),
+		AccountAccessConsent: s.URL() + ACCOUNT_ACCESS_CONSENT_PATH,
+		Accounts:             s.URL() + ACCOUNTS_PATH,
+	}
+}
+
+// JwksUrl returns the URL of the server's JWKS, publishing the key of its Signer
+func (s *AspspServer) JwksUrl() string {
+	return s.URL() + JWKS_PATH
+}
+
+// RegisterUrl returns the URL of the server's Dynamic Client Registration
+func (s *AspspServer) RegisterUrl() string {
+	return s.URL() + REGISTER_PATH
+}
+
+// NameVerificationUrl returns the URL of the server's name checks
+func (s *AspspServer) NameVerificationUrl() string {
+	return s.URL() + NAME_VERIFICATION_PATH
+}
+
+// RootCAs returns a pool trusting the server's TLS certificate
+func (s *AspspServer) RootCAs() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.server.Certificate())
+	return pool
+}
+
+// Close shuts down the server
+func (s *AspspServer) Close() {
+	s.server.Close()
+}
+
+// SetBehaviour scripts the response of the given endpoint
+func (s *AspspServer) SetBehaviour(endpoint string, b Behaviour) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.behaviours[endpoint] = &b
+}
+
+// SetApproveConsents decides whether headless authorisations approve (default) or reject the consent
+func (s *AspspServer) SetApproveConsents(approve bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.approve = approve
+}
+
+// SetPaymentStatuses sets the sequence of statuses returned by consecutive status checks of a payment.
+// The last status is repeated once the sequence is exhausted.
+func (s *AspspServer) SetPaymentStatuses(statuses ...bank.PaymentStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses = statuses
+}
+
+// CallCount returns the number of calls received by the given endpoint, failed ones included
+func (s *AspspServer) CallCount(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[endpoint]
+}
+
+// LastHeaders returns the headers of the last call to the endpoint, nil if not called
+func (s *AspspServer) LastHeaders(endpoint string) http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.headers[endpoint]
+}
+
+// SetFundsAvailable decides the result of the funds confirmations (default available)
+func (s *AspspServer) SetFundsAvailable(available bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.funds = available
+}
+
+// SetConsentStatus overrides the status of a consent, e.g. to expire it
+func (s *AspspServer) SetConsentStatus(consentId string, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if consent, ok := s.consents[consentId]; ok {
+		consent.Status = status
+	}
+}
+
+// SetBookCredits sets whether payments are credited to the creditor account as soon as they are submitted
+func (s *AspspServer) SetBookCredits(book bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bookCredit = book
+}
+
+// SetAccountName sets the name held for an account, by its identification. Name checks of other accounts
+// fail with an unknown account.
+func (s *AspspServer) SetAccountName(account *bank.AccountDetails, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accountNames[account.Identification()] = name
+}
+
+// PaymentCount returns the number of payments created
+func (s *AspspServer) PaymentCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.payments)
+}
+
+// scripted wraps a handler with the endpoint's call counting, delay and failure behaviour
+func (s *AspspServer) scripted(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.calls[endpoint]++
+		s.headers[endpoint] = r.Header.Clone()
+		b := s.behaviours[endpoint]
+		var delay time.Duration
+		fail := false
+		var failStatus int
+		var failBody string
+		lose := false
+		if b != nil {
+			delay = b.Delay
+			if b.FailStatus != 0 && b.FailTimes != 0 {
+				fail = true
+				failStatus = b.FailStatus
+				failBody = b.FailBody
+				lose = b.LoseResponse
+				if b.FailTimes > 0 {
+					b.FailTimes--
+				}
+			}
+		}
+		s.mu.Unlock()
+
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		if fail {
+			if lose {
+				h(httptest.NewRecorder(), r)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(failStatus)
+			w.Write([]byte(failBody))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// echoInteractionId returns the caller's FAPI interaction ID, or a new one, with every response
+func echoInteractionId(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(bank_impl.FAPI_INTERACTION_ID)
+		if id == "" {
+			id = uuid.New().String()
+		}
+		w.Header().Set(bank_impl.FAPI_INTERACTION_ID, id)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// idempotent wraps a handler, replaying the successful response to a repeated `x-idempotency-key`
+func (s *AspspServer) idempotent(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("x-idempotency-key")
+		if key == "" {
+			h(w, r)
+			return
+		}
+		key = endpoint + ":" + key
+
+		s.mu.Lock()
+		reply, seen := s.replies[key]
+		s.mu.Unlock()
+		if seen {
+			for k, v := range reply.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(reply.status)
+			w.Write(reply.body)
+			return
+		}
+
+		rec := httptest.NewRecorder()
+		h(rec, r)
+		if rec.Code >= 200 && rec.Code < 300 {
+			s.mu.Lock()
+			s.replies[key] = &recordedReply{status: rec.Code, header: rec.Header(), body: rec.Body.Bytes()}
+			s.mu.Unlock()
+		}
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	}
+}
+
+func (s *AspspServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if err := s.authenticateClient(r); err != nil {
+		writeError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var token mockToken
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials":
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		consentId, ok := s.codes[code]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "Unknown or used code")
+			return
+		}
+		// codes are single use
+		delete(s.codes, code)
+		token.ConsentId = consentId
+	case "refresh_token":
+		refresh := r.PostForm.Get("refresh_token")
+		consentId, ok := s.refreshes[refresh]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "Unknown or used refresh token")
+			return
+		}
+		// refresh tokens are rotated
+		delete(s.refreshes, refresh)
+		token.ConsentId = consentId
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", r.PostForm.Get("grant_type"))
+		return
+	}
+
+	lifetime := s.TokenLifetime
+	if lifetime == 0 {
+		lifetime = time.Hour
+	}
+	token.Expiry = time.Now().Add(lifetime)
+	accessToken := uuid.New().String()
+	s.tokens[accessToken] = &token
+	resp := map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(lifetime.Seconds()),
+	}
+	if token.ConsentId != "" && !s.NoRefreshTokens {
+		refresh := uuid.New().String()
+		s.refreshes[refresh] = token.ConsentId
+		resp["refresh_token"] = refresh
+	}
+	writeJson(w, http.StatusOK, resp)
+}
+
+func (s *AspspServer) handleConsent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.bearer(r); !ok {
+		writeError(w, http.StatusUnauthorized, "UK.OBIE.Unauthorized", "Invalid access token")
+		return
+	}
+	body, ok := s.readSignedBody(w, r)
+	if !ok {
+		return
+	}
+
+	consent := &mockConsent{
+		Id:      "consent-" + uuid.New().String(),
+		Status:  bank.CONSENT_AWAITING_AUTHORISATION,
+		Request: body,
+	}
+	s.mu.Lock()
+	s.consents[consent.Id] = consent
+	s.mu.Unlock()
+
+	body["Data"].(map[string]interface{})["ConsentId"] = consent.Id
+	body["Data"].(map[string]interface{})["Status"] = consent.Status
+	s.writeSignedJson(w, http.StatusCreated, body)
+}
+
+func (s *AspspServer) handleConsentStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.bearer(r); !ok {
+		writeError(w, http.StatusUnauthorized, "UK.OBIE.Unauthorized", "Invalid access token")
+		return
+	}
+	consentId := strings.TrimPrefix(r.URL.Path, CONSENT_PATH+"/")
+
+	s.mu.Lock()
+	consent, ok := s.consents[consentId]
+	var status string
+	if ok {
+		status = consent.Status
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "UK.OBIE.Resource.NotFound", "Unknown consent")
+		return
+	}
+
+	s.writeSignedJson(w, http.StatusOK, map[string]interface{}{
+		"Data": map[string]interface{}{
+			"ConsentId": consentId,
+			"Status":    status,
+		},
+	})
+}
+
+func (s *AspspServer) handleFundsConfirmation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	consentId := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, CONSENT_PATH+"/"), "/funds-confirmation")
+	token, ok := s.bearer(r)
+	if !ok || token.ConsentId != consentId {
+		writeError(w, http.StatusUnauthorized, "UK.OBIE.Unauthorized", "Invalid access token")
+		return
+	}
+
+	s.mu.Lock()
+	consent := s.consents[consentId]
+	status := consent.Status
+	available := s.funds
+	s.mu.Unlock()
+	if status != bank.CONSENT_AUTHORISED {
+		writeError(w, http.StatusBadRequest, "UK.OBIE.Resource.InvalidConsentStatus", status)
+		return
+	}
+
+	s.writeSignedJson(w, http.StatusOK, map[string]interface{}{
+		"Data": map[string]interface{}{
+			"FundsAvailableResult": map[string]interface{}{
+				"FundsAvailableDateTime": time.Now().UTC().Format(time.RFC3339),
+				"FundsAvailable":         available,
+			},
+		},
+	})
+}
+
+// authorizeRequest is the authorisation request, from the query or the request object
+type authorizeRequest struct {
+	consentId   string
+	clientId    string
+	redirectUri string
+	state       string
+	nonce       string
+}
+
+func (s *AspspServer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	req, err := s.parseAuthorizeRequest(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_object", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	consent, ok := s.consents[req.consentId]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Unknown consent")
+		return
+	}
+
+	// interactive flow: send the PSU to the login page, carrying the authorisation request
+	q := r.URL.Query()
+	if q.Get("authorization_mode") == "" {
+		w.Header().Set("Location", s.URL()+LOGIN_PATH+"?"+r.URL.RawQuery)
+		w.WriteHeader(http.StatusFound)
+		return
+	}
+
+	// headless flow: approve or reject straight away
+	var fragment string
+	if s.approve && q.Get("authorization_result") != "REJECTED" {
+		code := uuid.New().String()
+		s.codes[code] = consent.Id
+		consent.Status = bank.CONSENT_AUTHORISED
+		idToken, err := s.idToken(req, consent.Id, code)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+		// code first, as the sandbox does
+		fragment = "code=" + code + "&" + url.Values{"id_token": {idToken}, "state": {req.state}}.Encode()
+	} else {
+		consent.Status = bank.CONSENT_REJECTED
+		fragment = url.Values{
+			"error":             {"access_denied"},
+			"error_description": {"The PSU rejected the consent"},
+			"state":             {req.state},
+		}.Encode()
+	}
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"redirectUri": req.redirectUri + "#" + fragment,
+	})
+}
+
+// parseAuthorizeRequest reads the authorisation request. A signed request object takes precedence over the query;
+// a plain `request` is the consent ID, as in the sandbox's reduced security mode.
+func (s *AspspServer) parseAuthorizeRequest(q url.Values) (*authorizeRequest, error) {
+
+	req := &authorizeRequest{
+		consentId:   q.Get("request"),
+		clientId:    q.Get("client_id"),
+		redirectUri: q.Get("redirect_uri"),
+		state:       q.Get("state"),
+		nonce:       q.Get("nonce"),
+	}
+	if strings.Count(req.consentId, ".") != 2 {
+		return req, nil
+	}
+
+	var claims map[string]interface{}
+	var err error
+	if s.TppSigningKey != nil {
+		claims, err = bank.VerifyJwt(req.consentId, bank.StaticKeyResolver(s.TppSigningKey))
+	} else {
+		claims, err = bank.ParseJwtClaims(req.consentId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if exp, _ := claims["exp"].(float64); int64(exp) < time.Now().Unix() {
+		return nil, errors.New("Request object expired")
+	}
+
+	idTokenClaims, _ := claims["claims"].(map[string]interface{})["id_token"].(map[string]interface{})
+	intent, _ := idTokenClaims[bank_impl.OB_INTENT_ID_CLAIM].(map[string]interface{})
+	req.consentId, _ = intent["value"].(string)
+	req.clientId, _ = claims["client_id"].(string)
+	req.redirectUri, _ = claims["redirect_uri"].(string)
+	req.state, _ = claims["state"].(string)
+	req.nonce, _ = claims["nonce"].(string)
+	return req, nil
+}
+
+// idToken returns the ID token of an authorisation, signed if the server has a signer
+func (s *AspspServer) idToken(req *authorizeRequest, consentId string, code string) (string, error) {
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":                        s.URL(),
+		"sub":                        consentId,
+		"aud":                        req.clientId,
+		"iat":                        now.Unix(),
+		"exp":                        now.Add(5 * time.Minute).Unix(),
+		bank_impl.OB_INTENT_ID_CLAIM: consentId,
+		"c_hash":                     bank.HalfHash(code),
+	}
+	if req.nonce != "" {
+		claims["nonce"] = req.nonce
+	}
+	if req.state != "" {
+		claims["s_hash"] = bank.HalfHash(req.state)
+	}
+	if s.Signer != nil {
+		return s.Signer.SignJwt(claims)
+	}
+
+	// unsigned (alg=none)
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(c) + ".", nil
+}
+
+func (s *AspspServer) handleJwks(w http.ResponseWriter, r *http.Request) {
+	jwks := bank.Jwks{Keys: []bank.Jwk{}}
+	if s.Signer != nil {
+		jwks.Keys = append(jwks.Keys, s.Signer.Jwk())
+	}
+	writeJson(w, http.StatusOK, jwks)
+}
+
+func (s *AspspServer) handlePayment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	token, ok := s.bearer(r)
+	if !ok || token.ConsentId == "" {
+		writeError(w, http.StatusUnauthorized, "UK.OBIE.Unauthorized", "Invalid access token")
+		return
+	}
+	body, ok := s.readSignedBody(w, r)
+	if !ok {
+		return
+	}
+	data, _ := body["Data"].(map[string]interface{})
+	if data == nil || data["ConsentId"] != token.ConsentId {
+		writeError(w, http.StatusBadRequest, "UK.OBIE.Resource.ConsentMismatch", "Consent does not match token")
+		return
+	}
+
+	s.mu.Lock()
+	consent := s.consents[token.ConsentId]
+	if consent.Status != bank.CONSENT_AUTHORISED {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, "UK.OBIE.Resource.InvalidConsentStatus", consent.Status)
+		return
+	}
+	// the payment must be exactly the one consented to
+	consentData, _ := consent.Request["Data"].(map[string]interface{})
+	if !reflect.DeepEqual(data["Initiation"], consentData["Initiation"]) {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, "UK.OBIE.Resource.ConsentMismatch", "Initiation does not match the consent")
+		return
+	}
+	consent.Status = bank.CONSENT_CONSUMED
+	payment := &mockPayment{
+		Id:        "payment-" + uuid.New().String(),
+		ConsentId: consent.Id,
+	}
+	s.payments[payment.Id] = payment
+	if s.bookCredit {
+		s.credits = append(s.credits, newMockCredit(consent.Request))
+	}
+	s.mu.Unlock()
+
+	data["DomesticPaymentId"] = payment.Id
+	data["Status"] = bank.PAYMENT_PENDING
+	s.writeSignedJson(w, http.StatusCreated, body)
+}
+
+func (s *AspspServer) handlePaymentStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.bearer(r); !ok {
+		writeError(w, http.StatusUnauthorized, "UK.OBIE.Unauthorized", "Invalid access token")
+		return
+	}
+	paymentId := strings.TrimPrefix(r.URL.Path, PAYMENT_PATH+"/")
+
+	s.mu.Lock()
+	payment, ok := s.payments[paymentId]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "UK.OBIE.NotFound", "Unknown payment")
+		return
+	}
+	idx := payment.polls
+	if idx >= len(s.statuses) {
+		idx = len(s.statuses) - 1
+	}
+	status := s.statuses[idx]
+	payment.polls++
+	s.mu.Unlock()
+
+	s.writeSignedJson(w, http.StatusOK, map[string]interface{}{
+		"Data": map[string]interface{}{
+			"DomesticPaymentId": payment.Id,
+			"ConsentId":         payment.ConsentId,
+			"Status":            status,
+		},
+	})
+}
+
+// authenticateClient checks the client's credentials, with whichever method the client used
+func (s *AspspServer) authenticateClient(r *http.Request) error {
+	clientId := r.PostForm.Get("client_id")
+	if s.ClientId != "" && clientId != s.ClientId {
+		return errors.New("Unknown client")
+	}
+
+	switch {
+	case r.PostForm.Get("client_assertion") != "":
+		// private_key_jwt
+		if s.TppSigningKey == nil {
+			return errors.New("private_key_jwt not supported")
+		}
+		claims, err := bank.VerifyJwt(r.PostForm.Get("client_assertion"), bank.StaticKeyResolver(s.TppSigningKey))
+		if err != nil {
+			return err
+		}
+		if claims["iss"] != clientId || claims["sub"] != clientId {
+			return errors.New("Client assertion issuer mismatch")
+		}
+		if exp, _ := claims["exp"].(float64); int64(exp) < time.Now().Unix() {
+			return errors.New("Client assertion expired")
+		}
+	case r.PostForm.Get("client_secret") != "":
+		// client_secret_post
+		if s.ClientId != "" && r.PostForm.Get("client_secret") != s.ClientSecret {
+			return errors.New("Invalid client secret")
+		}
+	default:
+		// tls_client_auth
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			return errors.New("No client authentication")
+		}
+	}
+	return nil
+}
+
+// bearer returns the token presented in the Authorization header, if known
+func (s *AspspServer) bearer(r *http.Request) (*mockToken, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[strings.TrimPrefix(auth, "Bearer ")]
+	if ok && time.Now().After(t.Expiry) {
+		return nil, false
+	}
+	return t, ok
+}
+
+// readSignedBody reads the JSON body of a request, verifying its detached JWS if a TPP key is configured
+func (s *AspspServer) readSignedBody(w http.ResponseWriter, r *http.Request) (map[string]interface{}, bool) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "UK.OBIE.Field.Invalid", err.Error())
+		return nil, false
+	}
+	if s.TppSigningKey != nil {
+		sig := r.Header.Get(bank.JWS_SIGNATURE_HDR)
+		if err = bank.VerifyDetached(sig, data, bank.StaticKeyResolver(s.TppSigningKey)); err != nil {
+			writeError(w, http.StatusBadRequest, "UK.OBIE.Signature.Invalid", err.Error())
+			return nil, false
+		}
+	}
+	var body map[string]interface{}
+	if err = json.Unmarshal(data, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "UK.OBIE.Field.Invalid", err.Error())
+		return nil, false
+	}
+	return body, true
+}
+
+// writeSignedJson writes a JSON response, adding a detached JWS if a signer is configured
+func (s *AspspServer) writeSignedJson(w http.ResponseWriter, status int, body interface{}) {
+	var buf bytes.Buffer
+	json.NewEncoder(&buf).Encode(body)
+	if s.Signer != nil {
+		sig, err := s.Signer.SignDetached(buf.Bytes())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "UK.OBIE.UnexpectedError", err.Error())
+			return
+		}
+		w.Header().Set(bank.JWS_SIGNATURE_HDR, sig)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeJson(w, status, map[string]interface{}{
+		"Code":    fmt.Sprintf("%d", status),
+		"Message": message,
+		"Errors": []map[string]interface{}{
+			{"ErrorCode": code, "Message": message},
+		},
+	})
+}
+
+// newMockCredit books the payment of a consent to its creditor account
+func newMockCredit(consentRequest map[string]interface{}) *mockCredit {
+	data, _ := consentRequest["Data"].(map[string]interface{})
+	initiation, _ := data["Initiation"].(map[string]interface{})
+	amount, _ := initiation["InstructedAmount"].(map[string]interface{})
+	creditor, _ := initiation["CreditorAccount"].(map[string]interface{})
+
+	credit := &mockCredit{
+		TransactionId: "txn-" + uuid.New().String(),
+		BookedAt:      time.Now().UTC(),
+	}
+	credit.SchemeName, _ = creditor["SchemeName"].(string)
+	credit.Identification, _ = creditor["Identification"].(string)
+	credit.Amount, _ = amount["Amount"].(string)
+	credit.Currency, _ = amount["Currency"].(string)
+	credit.EndToEndId, _ = initiation["EndToEndIdentification"].(string)
+	return credit
+}
+
+func (s *AspspServer) handleAccountAccessConsent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.bearer(r); !ok {
+		writeError(w, http.StatusUnauthorized, "UK.OBIE.Unauthorized", "Invalid access token")
+		return
+	}
+	body, ok := s.readSignedBody(w, r)
+	if !ok {
+		return
+	}
+
+	consent := &mockConsent{
+		Id:            "aac-" + uuid.New().String(),
+		Status:        bank.CONSENT_AWAITING_AUTHORISATION,
+		Request:       body,
+		AccountAccess: true,
+	}
+	s.mu.Lock()
+	s.consents[consent.Id] = consent
+	s.mu.Unlock()
+
+	body["Data"].(map[string]interface{})["ConsentId"] = consent.Id
+	body["Data"].(map[string]interface{})["Status"] = consent.Status
+	s.writeSignedJson(w, http.StatusCreated, body)
+}
+
+// accountAccess checks that the request carries the token of an authorised account access consent
+func (s *AspspServer) accountAccess(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return false
+	}
+	token, ok := s.bearer(r)
+	if ok {
+		s.mu.Lock()
+		consent := s.consents[token.ConsentId]
+		ok = consent != nil && consent.AccountAccess && consent.Status == bank.CONSENT_AUTHORISED
+		s.mu.Unlock()
+	}
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "UK.OBIE.Unauthorized", "Invalid access token")
+	}
+	return ok
+}
+
+// handleAccounts lists the accounts which have received payments
+func (s *AspspServer) handleAccounts(w http.ResponseWriter, r *http.Request) {
+	if !s.accountAccess(w, r) {
+		return
+	}
+
+	s.mu.Lock()
+	seen := make(map[string]bool)
+	accounts := []interface{}{}
+	for _, c := range s.credits {
+		if seen[c.Identification] {
+			continue
+		}
+		seen[c.Identification] = true
+		accounts = append(accounts, map[string]interface{}{
+			"AccountId": "acc-" + c.Identification,
+			"Currency":  "GBP",
+			"Account": []interface{}{map[string]interface{}{
+				"SchemeName":     c.SchemeName,
+				"Identification": c.Identification,
+			}},
+		})
+	}
+	s.mu.Unlock()
+
+	s.writeSignedJson(w, http.StatusOK, map[string]interface{}{
+		"Data": map[string]interface{}{"Account": accounts},
+	})
+}
+
+// handleTransactions lists the credits of an account, booked since `fromBookingDateTime`
+func (s *AspspServer) handleTransactions(w http.ResponseWriter, r *http.Request) {
+	if !s.accountAccess(w, r) {
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, ACCOUNTS_PATH+"/")
+	if !strings.HasSuffix(path, "/transactions") {
+		writeError(w, http.StatusNotFound, "UK.OBIE.NotFound", "Unknown resource")
+		return
+	}
+	identification := strings.TrimPrefix(strings.TrimSuffix(path, "/transactions"), "acc-")
+	var from time.Time
+	if f := r.URL.Query().Get("fromBookingDateTime"); f != "" {
+		from, _ = time.Parse("2006-01-02T15:04:05", f)
+	}
+
+	s.mu.Lock()
+	transactions := []interface{}{}
+	for _, c := range s.credits {
+		if c.Identification != identification || c.BookedAt.Before(from) {
+			continue
+		}
+		transactions = append(transactions, map[string]interface{}{
+			"AccountId":            "acc-" + identification,
+			"TransactionId":        c.TransactionId,
+			"TransactionReference": c.EndToEndId,
+			"CreditDebitIndicator": "Credit",
+			"Status":               "Booked",
+			"BookingDateTime":      c.BookedAt.Format(time.RFC3339),
+			"Amount": map[string]interface{}{
+				"Amount":   c.Amount,
+				"Currency": c.Currency,
+			},
+		})
+	}
+	s.mu.Unlock()
+
+	body := map[string]interface{}{
+		"Data": map[string]interface{}{"Transaction": transactions},
+	}
+	if size := s.TransactionPageSize; size > 0 {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 1 {
+			page = 1
+		}
+		start, end := (page-1)*size, page*size
+		if start > len(transactions) {
+			start = len(transactions)
+		}
+		if end < len(transactions) {
+			q := r.URL.Query()
+			q.Set("page", strconv.Itoa(page+1))
+			body["Links"] = map[string]interface{}{"Next": s.server.URL + r.URL.Path + "?" + q.Encode()}
+		} else {
+			end = len(transactions)
+		}
+		body["Data"] = map[string]interface{}{"Transaction": transactions[start:end]}
+	}
+	s.writeSignedJson(w, http.StatusOK, body)
+}
+
+// handleNameVerification checks the name of an account against the one set with SetAccountName
+func (s *AspspServer) handleNameVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Data struct {
+			Identification string
+			Name           string
+		}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "UK.OBIE.Field.Invalid", "Malformed request")
+		return
+	}
+
+	s.mu.Lock()
+	held, ok := s.accountNames[req.Data.Identification]
+	s.mu.Unlock()
+
+	report := map[string]interface{}{"Matched": false}
+	switch {
+	case !ok:
+		report["ReasonCode"] = bank.COP_UNKNOWN_ACCOUNT
+	case bank.ScoreName(req.Data.Name, held) == bank.NAME_EXACT:
+		report["Matched"] = true
+	case bank.ScoreName(req.Data.Name, held) == bank.NAME_CLOSE:
+		report["ReasonCode"] = bank.COP_CLOSE_MATCH
+		report["Name"] = held
+	default:
+		report["ReasonCode"] = bank.COP_NO_MATCH
+	}
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"Data": map[string]interface{}{"VerificationReport": report},
+	})
+}
+
+// handleRegister registers a client from a registration request JWT. The issued credentials replace
+// ClientId and ClientSecret.
+func (s *AspspServer) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/jwt" {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_client_metadata", "error_description": "Expected application/jwt"})
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_client_metadata", "error_description": err.Error()})
+		return
+	}
+	var claims map[string]interface{}
+	if s.TppSigningKey != nil {
+		claims, err = bank.VerifyJwt(string(data), bank.StaticKeyResolver(s.TppSigningKey))
+	} else {
+		claims, err = bank.ParseJwtClaims(string(data))
+	}
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_client_metadata", "error_description": err.Error()})
+		return
+	}
+
+	exp, _ := claims["exp"].(float64)
+	redirectUris, _ := claims["redirect_uris"].([]interface{})
+	switch {
+	case claims["aud"] != s.URL():
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_client_metadata", "error_description": "Invalid aud"})
+		return
+	case int64(exp) < time.Now().Unix():
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_client_metadata", "error_description": "Expired request"})
+		return
+	case claims["software_statement"] == nil || claims["software_statement"] == "":
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_software_statement", "error_description": "Missing software statement"})
+		return
+	case len(redirectUris) == 0:
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_redirect_uri", "error_description": "Missing redirect URIs"})
+		return
+	}
+
+	resp := map[string]interface{}{
+		"client_id":                  "client-" + uuid.New().String(),
+		"client_id_issued_at":        time.Now().Unix(),
+		"token_endpoint_auth_method": claims["token_endpoint_auth_method"],
+		"redirect_uris":              redirectUris,
+		"software_id":                claims["software_id"],
+	}
+	if claims["token_endpoint_auth_method"] == bank.CLIENT_SECRET_POST {
+		resp["client_secret"] = uuid.New().String()
+	}
+
+	s.mu.Lock()
+	s.ClientId = resp["client_id"].(string)
+	s.ClientSecret, _ = resp["client_secret"].(string)
+	s.mu.Unlock()
+
+	writeJson(w, http.StatusCreated, resp)
+}
@@ -0,0 +1,128 @@
+package bank
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Modulus checking methods, as published by Vocalink
+const (
+	MOD10 = "MOD10"
+	MOD11 = "MOD11"
+	DBLAL = "DBLAL"
+)
+
+// modulusRule is a line of the Vocalink weight table: a sort code range, a method and the weights of the
+// 6 sort code and 8 account number digits
+type modulusRule struct {
+	start     string
+	end       string
+	method    string
+	weights   [14]int
+	exception int
+}
+
+// ModulusTable validates UK account numbers against the Vocalink modulus weight table (`valacdos.txt`).
+// Sort codes outside the table cannot be checked and are presumed valid. So are those whose rules carry an
+// exception code, as we do not implement the exceptions' special cases.
+type ModulusTable struct {
+	rules []modulusRule
+}
+
+// LoadModulusTable loads the weight table from a file in the Vocalink format
+func LoadModulusTable(path string) (*ModulusTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseModulusTable(f)
+}
+
+// ParseModulusTable parses a weight table in the Vocalink format, i.e. lines of
+// `start end method w1 ... w14 [exception]`
+func ParseModulusTable(r io.Reader) (*ModulusTable, error) {
+
+	table := &ModulusTable{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 17 && len(fields) != 18 {
+			return nil, errors.New("Invalid modulus table line " + strconv.Itoa(line) + ": unexpected number of fields")
+		}
+		rule := modulusRule{start: fields[0], end: fields[1], method: fields[2]}
+		if !sortCodePattern.MatchString(rule.start) || !sortCodePattern.MatchString(rule.end) {
+			return nil, errors.New("Invalid modulus table line " + strconv.Itoa(line) + ": invalid sort code range")
+		}
+		if rule.method != MOD10 && rule.method != MOD11 && rule.method != DBLAL {
+			return nil, errors.New("Invalid modulus table line " + strconv.Itoa(line) + ": unknown method " + rule.method)
+		}
+		for i := range rule.weights {
+			w, err := strconv.Atoi(fields[3+i])
+			if err != nil {
+				return nil, errors.New("Invalid modulus table line " + strconv.Itoa(line) + ": invalid weight")
+			}
+			rule.weights[i] = w
+		}
+		if len(fields) == 18 {
+			ex, err := strconv.Atoi(fields[17])
+			if err != nil {
+				return nil, errors.New("Invalid modulus table line " + strconv.Itoa(line) + ": invalid exception")
+			}
+			rule.exception = ex
+		}
+		table.rules = append(table.rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// Check returns whether the account number passes the checks of its sort code. Sort codes with two rules must
+// pass both.
+func (t *ModulusTable) Check(sortCode string, accountNumber string) bool {
+
+	if !sortCodePattern.MatchString(sortCode) || !accountNumberPattern.MatchString(accountNumber) {
+		return false
+	}
+	digits := sortCode + accountNumber
+	var matched []modulusRule
+	for _, r := range t.rules {
+		if sortCode >= r.start && sortCode <= r.end {
+			if r.exception != 0 {
+				return true
+			}
+			matched = append(matched, r)
+		}
+	}
+	for _, r := range matched {
+		if !r.check(digits) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *modulusRule) check(digits string) bool {
+	total := 0
+	for i, w := range r.weights {
+		p := int(digits[i]-'0') * w
+		if r.method == DBLAL {
+			// add the digits of each product
+			p = p/10 + p%10
+		}
+		total += p
+	}
+	if r.method == MOD11 {
+		return total%11 == 0
+	}
+	return total%10 == 0
+}
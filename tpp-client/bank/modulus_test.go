@@ -0,0 +1,72 @@
+package bank_test
+
+import (
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+// TEST_MODULUS_TABLE has rules in the Vocalink format, for the examples of the Vocalink specification
+const TEST_MODULUS_TABLE = `
+089000 089999 MOD10    0    0    0    0    0    0    7    1    3    7    1    3    7    1
+107999 107999 MOD11    0    0    0    0    0    0    8    7    6    5    4    3    2    1
+938600 938699 DBLAL    2    1    2    1    2    1    2    1    2    1    2    1    2    1
+938600 938699 MOD11    0    0    0    0    0    0    0    0    0    0    0    0    0    0
+070116 070116 MOD11    0    0    0    0    0    0    7    6    5    4    3    2    7    1    1
+`
+
+func TestModulusTable_Check(t *testing.T) {
+
+	// arrange
+	table, err := bank.ParseModulusTable(strings.NewReader(TEST_MODULUS_TABLE))
+	require.NoError(t, err)
+
+	cases := []struct {
+		name          string
+		sortCode      string
+		accountNumber string
+		valid         bool
+	}{
+		{name: "MOD10", sortCode: "089999", accountNumber: "66374958", valid: true},
+		{name: "MOD10 failure", sortCode: "089999", accountNumber: "66374959", valid: false},
+		{name: "MOD11", sortCode: "107999", accountNumber: "88837491", valid: true},
+		{name: "MOD11 failure", sortCode: "107999", accountNumber: "88837492", valid: false},
+		{name: "DBLAL and second check", sortCode: "938611", accountNumber: "07806039", valid: true},
+		{name: "DBLAL failure", sortCode: "938611", accountNumber: "07806038", valid: false},
+		{name: "exception is not checked", sortCode: "070116", accountNumber: "34012583", valid: true},
+		{name: "unknown sort code", sortCode: "500000", accountNumber: "12345601", valid: true},
+		{name: "not digits", sortCode: "08999a", accountNumber: "66374958", valid: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act & assert
+			assert.Equal(t, c.valid, table.Check(c.sortCode, c.accountNumber))
+		})
+	}
+}
+
+func TestParseModulusTable_Invalid(t *testing.T) {
+	cases := []struct {
+		name  string
+		table string
+		err   string
+	}{
+		{name: "missing weights", table: "089000 089999 MOD10 0 0 0", err: "number of fields"},
+		{name: "unknown method", table: "089000 089999 MOD12 0 0 0 0 0 0 7 1 3 7 1 3 7 1", err: "unknown method"},
+		{name: "invalid weight", table: "089000 089999 MOD10 0 0 0 0 0 0 7 1 3 7 1 3 7 x", err: "weight"},
+		{name: "invalid sort code", table: "08900 089999 MOD10 0 0 0 0 0 0 7 1 3 7 1 3 7 1", err: "sort code"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			_, err := bank.ParseModulusTable(strings.NewReader(c.table))
+
+			// assert
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), c.err)
+			assert.Contains(t, err.Error(), "line 1")
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package bank
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// NameVerificationClient checks an account's name with its bank, Confirmation of Payee style
+type NameVerificationClient interface {
+
+	// VerifyName checks the name of the account against the bank's records
+	VerifyName(account *AccountDetails) (*NameVerificationResult, error)
+}
+
+// NameMatch is the outcome of a name check
+type NameMatch string
+
+const (
+	NAME_EXACT NameMatch = "exact"
+	NAME_CLOSE NameMatch = "close"
+	NAME_NONE  NameMatch = "none"
+	// NAME_UNAVAILABLE means the check could not be made, e.g. the bank does not support it or the customer opted out
+	NAME_UNAVAILABLE NameMatch = "unavailable"
+)
+
+// CoP reason codes
+const (
+	COP_CLOSE_MATCH        = "MBAM"
+	COP_NO_MATCH           = "ANNM"
+	COP_BUSINESS_ACCOUNT   = "BANM"
+	COP_PERSONAL_ACCOUNT   = "PANM"
+	COP_UNKNOWN_ACCOUNT    = "AC01"
+	COP_ACCOUNT_NOT_SUPP   = "ACNS"
+	COP_OPTED_OUT          = "OPTO"
+	COP_SWITCHED_ACCOUNT   = "CASS"
+	COP_SECONDARY_REQUIRED = "SCNS"
+)
+
+type NameVerificationResult struct {
+	Match NameMatch
+	// ReasonCode as returned by the bank, if any
+	ReasonCode string
+	// AccountName held by the bank, only returned for close matches
+	AccountName string
+}
+
+// NameMatchOf maps a CoP response to a name match
+func NameMatchOf(matched bool, reasonCode string) NameMatch {
+	if matched {
+		return NAME_EXACT
+	}
+	switch reasonCode {
+	case COP_CLOSE_MATCH, COP_BUSINESS_ACCOUNT, COP_PERSONAL_ACCOUNT:
+		return NAME_CLOSE
+	case COP_NO_MATCH, COP_UNKNOWN_ACCOUNT:
+		return NAME_NONE
+	default:
+		return NAME_UNAVAILABLE
+	}
+}
+
+// CLOSE_MATCH_SIMILARITY is the minimum similarity of two names to be a close match
+const CLOSE_MATCH_SIMILARITY = 0.8
+
+var (
+	nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+	// words which do not tell names apart
+	nameNoise = map[string]bool{"mr": true, "mrs": true, "ms": true, "miss": true, "mx": true, "dr": true, "sir": true}
+	// common spellings of business suffixes
+	nameSynonyms = map[string]string{"limited": "ltd", "and": "&", "company": "co"}
+)
+
+// ScoreName compares the name given for an account with the one held by its bank.
+// Names are compared case-, punctuation- and title-insensitively. Reordered words, initials in place of
+// forenames and small typos are close matches.
+func ScoreName(given string, held string) NameMatch {
+
+	g, h := nameTokens(given), nameTokens(held)
+	if len(g) == 0 || len(h) == 0 {
+		return NAME_NONE
+	}
+	if strings.Join(g, " ") == strings.Join(h, " ") {
+		return NAME_EXACT
+	}
+	if sortedJoin(g) == sortedJoin(h) || initialsMatch(g, h) || initialsMatch(h, g) {
+		return NAME_CLOSE
+	}
+	if NameSimilarity(strings.Join(g, " "), strings.Join(h, " ")) >= CLOSE_MATCH_SIMILARITY {
+		return NAME_CLOSE
+	}
+	return NAME_NONE
+}
+
+// NameSimilarity returns 1 minus the edit distance of the strings, relative to the longer one
+func NameSimilarity(a string, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func nameTokens(name string) []string {
+	var tokens []string
+	for _, t := range strings.Fields(nonAlphanumeric.ReplaceAllString(strings.ToLower(name), " ")) {
+		if nameNoise[t] {
+			continue
+		}
+		if s, ok := nameSynonyms[t]; ok {
+			t = s
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens
+}
+
+func sortedJoin(tokens []string) string {
+	s := append([]string(nil), tokens...)
+	sort.Strings(s)
+	return strings.Join(s, " ")
+}
+
+// initialsMatch returns whether `short` abbreviates `long`'s forenames, e.g. "j doe" and "john doe"
+func initialsMatch(short []string, long []string) bool {
+	if len(short) != len(long) || len(short) < 2 || short[len(short)-1] != long[len(long)-1] {
+		return false
+	}
+	for i := 0; i < len(short)-1; i++ {
+		if short[i] != long[i] && (len(short[i]) != 1 || !strings.HasPrefix(long[i], short[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+func levenshtein(a []rune, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,52 @@
+package bank_test
+
+import (
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestScoreName(t *testing.T) {
+	cases := []struct {
+		given string
+		held  string
+		match bank.NameMatch
+	}{
+		{given: "John Doe", held: "John Doe", match: bank.NAME_EXACT},
+		{given: "mr. john  DOE", held: "John Doe", match: bank.NAME_EXACT},
+		{given: "Acme Limited", held: "ACME LTD", match: bank.NAME_EXACT},
+		{given: "Doe John", held: "John Doe", match: bank.NAME_CLOSE},
+		{given: "J Doe", held: "John Doe", match: bank.NAME_CLOSE},
+		{given: "John Doe", held: "J. Doe", match: bank.NAME_CLOSE},
+		{given: "Jon Doe", held: "John Doe", match: bank.NAME_CLOSE},
+		{given: "Jane Smith", held: "John Doe", match: bank.NAME_NONE},
+		{given: "J Smith", held: "John Doe", match: bank.NAME_NONE},
+		{given: "", held: "John Doe", match: bank.NAME_NONE},
+	}
+	for _, c := range cases {
+		t.Run(c.given+"/"+c.held, func(t *testing.T) {
+			// act & assert
+			assert.Equal(t, c.match, bank.ScoreName(c.given, c.held))
+		})
+	}
+}
+
+func TestNameMatchOf(t *testing.T) {
+	cases := []struct {
+		matched    bool
+		reasonCode string
+		match      bank.NameMatch
+	}{
+		{matched: true, match: bank.NAME_EXACT},
+		{reasonCode: bank.COP_CLOSE_MATCH, match: bank.NAME_CLOSE},
+		{reasonCode: bank.COP_BUSINESS_ACCOUNT, match: bank.NAME_CLOSE},
+		{reasonCode: bank.COP_NO_MATCH, match: bank.NAME_NONE},
+		{reasonCode: bank.COP_UNKNOWN_ACCOUNT, match: bank.NAME_NONE},
+		{reasonCode: bank.COP_OPTED_OUT, match: bank.NAME_UNAVAILABLE},
+		{reasonCode: bank.COP_ACCOUNT_NOT_SUPP, match: bank.NAME_UNAVAILABLE},
+	}
+	for _, c := range cases {
+		// act & assert
+		assert.Equal(t, c.match, bank.NameMatchOf(c.matched, c.reasonCode), c.reasonCode)
+	}
+}
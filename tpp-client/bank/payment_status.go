@@ -0,0 +1,43 @@
+package bank
+
+// PaymentStatus is the status of an OB domestic payment
+type PaymentStatus string
+
+const (
+	// PAYMENT_PENDING the payment is awaiting further checks by the ASPSP
+	PAYMENT_PENDING PaymentStatus = "Pending"
+	// PAYMENT_ACCEPTED_SETTLEMENT_IN_PROCESS all checks passed, the payment is being executed
+	PAYMENT_ACCEPTED_SETTLEMENT_IN_PROCESS PaymentStatus = "AcceptedSettlementInProcess"
+	// PAYMENT_ACCEPTED_SETTLEMENT_COMPLETED the payer's account has been debited
+	PAYMENT_ACCEPTED_SETTLEMENT_COMPLETED PaymentStatus = "AcceptedSettlementCompleted"
+	// PAYMENT_ACCEPTED_CREDIT_SETTLEMENT_COMPLETED the beneficiary's account has been credited
+	PAYMENT_ACCEPTED_CREDIT_SETTLEMENT_COMPLETED PaymentStatus = "AcceptedCreditSettlementCompleted"
+	// PAYMENT_ACCEPTED_WITHOUT_POSTING accepted by the beneficiary's ASPSP but not yet credited
+	PAYMENT_ACCEPTED_WITHOUT_POSTING PaymentStatus = "AcceptedWithoutPosting"
+	// PAYMENT_REJECTED the payment will not be executed
+	PAYMENT_REJECTED PaymentStatus = "Rejected"
+)
+
+// IsTerminal returns true if the status will not change any more, i.e. there is no point checking it again
+func (s PaymentStatus) IsTerminal() bool {
+	return s.IsSuccess() || s == PAYMENT_REJECTED
+}
+
+// IsSuccess returns true if the payment has been settled
+func (s PaymentStatus) IsSuccess() bool {
+	return s == PAYMENT_ACCEPTED_SETTLEMENT_COMPLETED || s == PAYMENT_ACCEPTED_CREDIT_SETTLEMENT_COMPLETED
+}
+
+// IsKnown returns true if the status is one of the OB payment statuses
+func (s PaymentStatus) IsKnown() bool {
+	switch s {
+	case PAYMENT_PENDING,
+		PAYMENT_ACCEPTED_SETTLEMENT_IN_PROCESS,
+		PAYMENT_ACCEPTED_SETTLEMENT_COMPLETED,
+		PAYMENT_ACCEPTED_CREDIT_SETTLEMENT_COMPLETED,
+		PAYMENT_ACCEPTED_WITHOUT_POSTING,
+		PAYMENT_REJECTED:
+		return true
+	}
+	return false
+}
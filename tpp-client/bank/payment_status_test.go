@@ -0,0 +1,28 @@
+package bank_test
+
+import (
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPaymentStatus(t *testing.T) {
+	cases := []struct {
+		status   bank.PaymentStatus
+		terminal bool
+		success  bool
+	}{
+		{status: bank.PAYMENT_PENDING, terminal: false, success: false},
+		{status: bank.PAYMENT_ACCEPTED_SETTLEMENT_IN_PROCESS, terminal: false, success: false},
+		{status: bank.PAYMENT_ACCEPTED_WITHOUT_POSTING, terminal: false, success: false},
+		{status: bank.PAYMENT_ACCEPTED_SETTLEMENT_COMPLETED, terminal: true, success: true},
+		{status: bank.PAYMENT_ACCEPTED_CREDIT_SETTLEMENT_COMPLETED, terminal: true, success: true},
+		{status: bank.PAYMENT_REJECTED, terminal: true, success: false},
+		{status: "SomethingNew", terminal: false, success: false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.terminal, c.status.IsTerminal(), c.status)
+		assert.Equal(t, c.success, c.status.IsSuccess(), c.status)
+		assert.Equal(t, c.status != "SomethingNew", c.status.IsKnown(), c.status)
+	}
+}
@@ -0,0 +1,242 @@
+package callback
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/encrypt"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/event"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CallbackServer receives the ASPSP's redirect after the payer has authorised (or rejected) a consent.
+// The authorisation response is in the URL fragment, so a landing page posts it back to the server.
+// The server validates it and returns the `AuthGrantedPayload` encrypted for the TPP, for the dApp to submit on-chain.
+type CallbackServer interface {
+
+	// Handler returns the HTTP handler of the callback path
+	Handler() http.Handler
+
+	// Start listens in the background
+	Start() error
+
+	// Stop shuts the server down
+	Stop() error
+}
+
+// AuthGrantedResponse is what the landing page hands to the dApp, i.e. the arguments of the contract's `authGranted`
+type AuthGrantedResponse struct {
+	// RequestId as 0x-prefixed hex
+	RequestId string `json:"requestId"`
+	// EncryptedData is the JSON of the encrypted `AuthGrantedPayload`
+	EncryptedData string `json:"encryptedData"`
+}
+
+type authResponse struct {
+	Code             string `json:"code"`
+	IdToken          string `json:"id_token"`
+	State            string `json:"state"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+const MAX_BODY_SIZE = 64 * 1024
+
+type CallbackServerImpl struct {
+	addr     string
+	path     string
+	lookup   *event.AuthorisationLookup
+	keyPair  *encrypt.KeyPair
+	idTokens *bank.IdTokenValidator
+	page     []byte
+	server   *http.Server
+	l        *zap.SugaredLogger
+}
+
+func NewCallbackServer(
+	_addr string,
+	_path string,
+	_lookup event.AuthorisationLookup,
+	_keyPair *encrypt.KeyPair,
+	_idTokens *bank.IdTokenValidator,
+	_dappOrigin string,
+	_l *zap.SugaredLogger) CallbackServer {
+
+	if _path == "" {
+		_path = "/"
+	}
+	return &CallbackServerImpl{
+		addr:     _addr,
+		path:     _path,
+		lookup:   &_lookup,
+		keyPair:  _keyPair,
+		idTokens: _idTokens,
+		page:     landingPage(_dappOrigin),
+		l:        _l,
+	}
+}
+
+func (s *CallbackServerImpl) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(s.path, s.handle)
+	return mux
+}
+
+func (s *CallbackServerImpl) Start() error {
+	server, err := serve("Callback server", s.addr, s.path, s.Handler(), s.l)
+	if err != nil {
+		return err
+	}
+	s.server = server
+	return nil
+}
+
+func (s *CallbackServerImpl) Stop() error {
+	return shutdown(s.server)
+}
+
+func (s *CallbackServerImpl) handle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		w.Write(s.page)
+	case http.MethodPost:
+		s.handleAuthResponse(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAuthResponse validates the authorisation response posted by the landing page
+func (s *CallbackServerImpl) handleAuthResponse(w http.ResponseWriter, r *http.Request) {
+
+	var resp authResponse
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_BODY_SIZE)).Decode(&resp); err != nil {
+		writeJson(w, http.StatusBadRequest, errorResponse{Error: "Malformed authorisation response"})
+		return
+	}
+	if resp.Error != "" {
+		// the consent status check picks the rejection up and notifies the payer on-chain
+		s.l.Infow("Authorisation not granted",
+			"error", resp.Error,
+			"description", resp.ErrorDescription)
+		writeJson(w, http.StatusOK, errorResponse{Error: "The payment was not authorised: " + resp.Error})
+		return
+	}
+	if resp.Code == "" || resp.IdToken == "" {
+		writeJson(w, http.StatusBadRequest, errorResponse{Error: "Missing code or id_token"})
+		return
+	}
+
+	pending, ok := (*s.lookup).FindAuthorisation(resp.State)
+	if !ok {
+		s.l.Warnw("No request awaiting authorisation for the callback's state")
+		writeJson(w, http.StatusBadRequest, errorResponse{Error: "Unknown or completed authorisation request"})
+		return
+	}
+
+	if _, err := s.idTokens.Validate(resp.IdToken, &bank.IdTokenExpectations{
+		Nonce: pending.Nonce,
+		Code:  resp.Code,
+		State: resp.State,
+	}); err != nil {
+		s.l.Warnw("Invalid authorisation response: "+err.Error(),
+			"reqId", pending.RequestId)
+		writeJson(w, http.StatusBadRequest, errorResponse{Error: "Invalid authorisation response"})
+		return
+	}
+
+	// encrypt for the TPP, exactly as the payer would
+	data, err := json.Marshal(event.AuthGrantedPayload{
+		ConsentCode: resp.Code,
+		PublicKey:   base64.StdEncoding.EncodeToString(pending.PublicKey),
+		State:       resp.State,
+		IdToken:     resp.IdToken,
+		// the payer is present, sent to the bank as FAPI headers
+		AuthDate:          time.Now().Unix(),
+		CustomerIpAddress: remoteIp(r),
+	})
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, errorResponse{Error: "Error marshalling payload"})
+		return
+	}
+	box, err := s.keyPair.Encrypt(data, s.keyPair.PublicEncrKeyBytes())
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, errorResponse{Error: "Error encrypting payload"})
+		return
+	}
+	encrData, err := json.Marshal(box)
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, errorResponse{Error: "Error marshalling encrypted payload"})
+		return
+	}
+
+	s.l.Infow("Authorisation callback validated",
+		"reqId", pending.RequestId,
+		"consentId", pending.ConsentId)
+
+	writeJson(w, http.StatusOK, AuthGrantedResponse{
+		RequestId:     "0x" + pending.RequestId,
+		EncryptedData: string(encrData),
+	})
+}
+
+// serve listens on the address and serves the handler in the background
+func serve(name string, addr string, path string, handler http.Handler, l *zap.SugaredLogger) (*http.Server, error) {
+	// listen synchronously, so that a busy port fails the start-up
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.New("Unable to start " + strings.ToLower(name) + ": " + err.Error())
+	}
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			l.Errorw(name + " stopped: " + err.Error())
+		}
+	}()
+	l.Infow(name+" listening",
+		"addr", ln.Addr().String(),
+		"path", path)
+	return server, nil
+}
+
+func shutdown(server *http.Server) error {
+	if server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+// remoteIp returns the IP address of the request's client
+func remoteIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
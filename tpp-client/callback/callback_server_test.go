@@ -0,0 +1,165 @@
+package callback_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/callback"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/encrypt"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/event"
+	test_util "github.com/sgerogia/sol-stablecoin/tpp-client/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const DAPP_ORIGIN = "https://dapp.test"
+
+// fakeLookup knows a single pending authorisation
+type fakeLookup struct {
+	pending *event.PendingAuthorisation
+}
+
+func (f *fakeLookup) FindAuthorisation(state string) (*event.PendingAuthorisation, bool) {
+	if state != f.pending.State {
+		return nil, false
+	}
+	return f.pending, true
+}
+
+type callbackFixture struct {
+	server  *httptest.Server
+	signer  *bank.JwsSigner
+	keyPair *encrypt.KeyPair
+	pending *event.PendingAuthorisation
+}
+
+func newCallbackFixture(t *testing.T) *callbackFixture {
+	cert, err := test_util.NewSelfSignedCert("aspsp.test")
+	require.NoError(t, err)
+	signer, err := bank.NewJwsSigner(cert, "aspsp-kid", "aspsp")
+	require.NoError(t, err)
+	keyPair, err := encrypt.NewKeyPair()
+	require.NoError(t, err)
+	pending := &event.PendingAuthorisation{
+		RequestId: "0a0b",
+		ConsentId: "consent-1",
+		State:     "state-1",
+		Nonce:     "nonce-1",
+		PublicKey: []byte("payer-key"),
+	}
+
+	jwk := signer.Jwk()
+	aspspKey, err := jwk.PublicKey()
+	require.NoError(t, err)
+
+	cb := callback.NewCallbackServer(
+		"", "/callback", &fakeLookup{pending: pending}, keyPair,
+		bank.NewIdTokenValidator("https://aspsp.test", "tpp-client", bank.StaticKeyResolver(aspspKey)), DAPP_ORIGIN, zap.NewNop().Sugar())
+	server := httptest.NewServer(cb.Handler())
+	t.Cleanup(server.Close)
+
+	return &callbackFixture{server: server, signer: signer, keyPair: keyPair, pending: pending}
+}
+
+// post sends an authorisation response, as the landing page does
+func (f *callbackFixture) post(t *testing.T, body map[string]string) (int, map[string]string) {
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+	resp, err := http.Post(f.server.URL+"/callback", "application/json", bytes.NewReader(data))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var result map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	return resp.StatusCode, result
+}
+
+func (f *callbackFixture) idToken(t *testing.T, nonce, code, state string) string {
+	token, err := f.signer.SignJwt(map[string]interface{}{
+		"iss":    "https://aspsp.test",
+		"aud":    "tpp-client",
+		"exp":    time.Now().Add(5 * time.Minute).Unix(),
+		"nonce":  nonce,
+		"c_hash": bank.HalfHash(code),
+		"s_hash": bank.HalfHash(state),
+	})
+	require.NoError(t, err)
+	return token
+}
+
+func TestCallbackServer_LandingPage(t *testing.T) {
+	// arrange
+	f := newCallbackFixture(t)
+
+	// act
+	resp, err := http.Get(f.server.URL + "/callback")
+
+	// assert
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+	page, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(page), `var dappOrigin = "`+DAPP_ORIGIN+`";`)
+	assert.NotContains(t, string(page), `"*"`)
+	assert.NotContains(t, string(page), callback.DAPP_ORIGIN)
+}
+
+func TestCallbackServer_ValidResponse(t *testing.T) {
+	// arrange
+	f := newCallbackFixture(t)
+	idToken := f.idToken(t, "nonce-1", "code-1", "state-1")
+
+	// act
+	status, result := f.post(t, map[string]string{"code": "code-1", "id_token": idToken, "state": "state-1"})
+
+	// assert
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "0x0a0b", result["requestId"])
+	// ...the TPP can decrypt the payload
+	var box encrypt.EthSigUtilBox
+	require.NoError(t, json.Unmarshal([]byte(result["encryptedData"]), &box))
+	decr, err := f.keyPair.Decrypt(&box)
+	require.NoError(t, err)
+	var payload event.AuthGrantedPayload
+	require.NoError(t, json.Unmarshal(decr, &payload))
+	assert.Equal(t, "code-1", payload.ConsentCode)
+	assert.Equal(t, "state-1", payload.State)
+	assert.Equal(t, idToken, payload.IdToken)
+	assert.Equal(t, "cGF5ZXIta2V5", payload.PublicKey)
+	assert.Equal(t, "127.0.0.1", payload.CustomerIpAddress)
+	assert.InDelta(t, time.Now().Unix(), payload.AuthDate, 5)
+}
+
+func TestCallbackServer_InvalidResponses(t *testing.T) {
+	f := newCallbackFixture(t)
+	cases := []struct {
+		name string
+		body map[string]string
+	}{
+		{name: "unknown state", body: map[string]string{
+			"code": "code-1", "id_token": f.idToken(t, "nonce-1", "code-1", "state-2"), "state": "state-2"}},
+		{name: "wrong nonce", body: map[string]string{
+			"code": "code-1", "id_token": f.idToken(t, "nonce-2", "code-1", "state-1"), "state": "state-1"}},
+		{name: "swapped code", body: map[string]string{
+			"code": "code-2", "id_token": f.idToken(t, "nonce-1", "code-1", "state-1"), "state": "state-1"}},
+		{name: "missing id_token", body: map[string]string{"code": "code-1", "state": "state-1"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			status, result := f.post(t, c.body)
+
+			// assert
+			assert.Equal(t, http.StatusBadRequest, status)
+			assert.NotEmpty(t, result["error"])
+			assert.Empty(t, result["encryptedData"])
+		})
+	}
+}
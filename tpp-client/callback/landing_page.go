@@ -0,0 +1,74 @@
+package callback
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// DAPP_ORIGIN is replaced in LANDING_PAGE by the JS string of the dApp's origin
+const DAPP_ORIGIN = "{{DAPP_ORIGIN}}"
+
+// LANDING_PAGE reads the authorisation response from the URL fragment, which never reaches the server,
+// and posts it back. The result is shown to the payer and handed to the dApp window which opened the bank's page,
+// only if that window is on the configured dApp origin.
+const LANDING_PAGE = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Provable GBP - payment authorisation</title>
+</head>
+<body>
+<h3 id="title">Completing your authorisation...</h3>
+<p id="message"></p>
+<pre id="result" style="white-space: pre-wrap; word-break: break-all;"></pre>
+<script>
+(function () {
+	var dappOrigin = {{DAPP_ORIGIN}};
+	var params = new URLSearchParams(window.location.hash.substring(1));
+	// drop the fragment from the address bar and history
+	history.replaceState(null, "", window.location.pathname);
+
+	var title = document.getElementById("title");
+	var message = document.getElementById("message");
+	var result = document.getElementById("result");
+
+	fetch(window.location.pathname, {
+		method: "POST",
+		headers: {"Content-Type": "application/json"},
+		body: JSON.stringify({
+			code: params.get("code") || "",
+			id_token: params.get("id_token") || "",
+			state: params.get("state") || "",
+			error: params.get("error") || "",
+			error_description: params.get("error_description") || ""
+		})
+	}).then(function (resp) {
+		return resp.json();
+	}).then(function (body) {
+		if (body.error) {
+			title.textContent = "Authorisation failed";
+			message.textContent = body.error;
+			return;
+		}
+		title.textContent = "Payment authorised";
+		message.textContent = "Submit the following to the contract's authGranted, or return to the dApp.";
+		result.textContent = JSON.stringify(body, null, 2);
+		if (window.opener && dappOrigin) {
+			window.opener.postMessage({type: "authGranted", payload: body}, dappOrigin);
+		}
+	}).catch(function (err) {
+		title.textContent = "Authorisation failed";
+		message.textContent = String(err);
+	});
+})();
+</script>
+</body>
+</html>
+`
+
+// landingPage renders LANDING_PAGE for the dApp origin. An empty origin posts nothing to the opener.
+func landingPage(dappOrigin string) []byte {
+	// JSON escapes '<' and '>', so the origin cannot close the script
+	origin, _ := json.Marshal(dappOrigin)
+	return []byte(strings.Replace(LANDING_PAGE, DAPP_ORIGIN, string(origin), 1))
+}
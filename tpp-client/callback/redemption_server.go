@@ -0,0 +1,119 @@
+package callback
+
+import (
+	"encoding/json"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/event"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+// RedemptionServer receives the holders' payout instructions and shows the status of their redemptions.
+//
+//	POST {path}               submits a `RedemptionRequest`, returns the `RedemptionInfo`
+//	GET  {path}/{burnTxHash}  returns the `RedemptionInfo` of a burn
+type RedemptionServer interface {
+
+	// Handler returns the HTTP handler of the redemption paths
+	Handler() http.Handler
+
+	// Start listens in the background
+	Start() error
+
+	// Stop shuts the server down
+	Stop() error
+}
+
+// RedemptionRequest is what the dApp posts, i.e. the holder's instruction encrypted for the TPP
+type RedemptionRequest struct {
+	// EncryptedData is the JSON of the encrypted `RedemptionPayload`
+	EncryptedData string `json:"encryptedData"`
+}
+
+// DEFAULT_REDEMPTION_PATH is used if the path is empty or the root
+const DEFAULT_REDEMPTION_PATH = "/redemptions"
+
+type RedemptionServerImpl struct {
+	addr    string
+	path    string
+	handler *event.RedemptionHandler
+	server  *http.Server
+	l       *zap.SugaredLogger
+}
+
+func NewRedemptionServer(
+	_addr string,
+	_path string,
+	_handler event.RedemptionHandler,
+	_l *zap.SugaredLogger) RedemptionServer {
+
+	path := "/" + strings.Trim(_path, "/")
+	if path == "/" {
+		path = DEFAULT_REDEMPTION_PATH
+	}
+	return &RedemptionServerImpl{
+		addr:    _addr,
+		path:    path,
+		handler: &_handler,
+		l:       _l,
+	}
+}
+
+func (s *RedemptionServerImpl) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(s.path, s.handleSubmit)
+	mux.HandleFunc(s.path+"/", s.handleStatus)
+	return mux
+}
+
+func (s *RedemptionServerImpl) Start() error {
+	server, err := serve("Redemption server", s.addr, s.path, s.Handler(), s.l)
+	if err != nil {
+		return err
+	}
+	s.server = server
+	return nil
+}
+
+func (s *RedemptionServerImpl) Stop() error {
+	return shutdown(s.server)
+}
+
+// handleSubmit hands a holder's instruction to the redemption handler
+func (s *RedemptionServerImpl) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RedemptionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_BODY_SIZE)).Decode(&req); err != nil || req.EncryptedData == "" {
+		writeJson(w, http.StatusBadRequest, errorResponse{Error: "Malformed redemption request"})
+		return
+	}
+
+	info, err := (*s.handler).SubmitRedemption([]byte(req.EncryptedData))
+	if err != nil {
+		s.l.Warnw("Redemption instruction refused: "+err.Error(),
+			"ip", remoteIp(r))
+		writeJson(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJson(w, http.StatusAccepted, info)
+}
+
+// handleStatus returns the status of the redemption of the burn in the path
+func (s *RedemptionServerImpl) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	burnTxHash := strings.TrimPrefix(r.URL.Path, s.path+"/")
+	info, ok := (*s.handler).FindRedemption(burnTxHash)
+	if !ok {
+		writeJson(w, http.StatusNotFound, errorResponse{Error: "Unknown redemption"})
+		return
+	}
+	writeJson(w, http.StatusOK, info)
+}
@@ -0,0 +1,118 @@
+package callback_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/callback"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/contract"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const BURN_TX_HASH = "0x8d3c5b8a4e6f3f0d1c2b3a4958675a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f"
+
+// fakeRedemptions accepts the instruction "valid" and knows a single redemption
+type fakeRedemptions struct {
+	info *event.RedemptionInfo
+}
+
+func (f *fakeRedemptions) SubmitRedemption(encryptedData []byte) (*event.RedemptionInfo, error) {
+	if string(encryptedData) != "valid" {
+		return nil, errors.New("Invalid redemption instruction")
+	}
+	return f.info, nil
+}
+
+func (f *fakeRedemptions) ProcessBurn(burn *contract.ProvableGBPTransfer) error {
+	return nil
+}
+
+func (f *fakeRedemptions) ProcessPayouts() {}
+
+func (f *fakeRedemptions) ProcessPaymentStatusResponse(request *bank.PaymentStatusResponse) (bool, error) {
+	return true, nil
+}
+
+func (f *fakeRedemptions) FindRedemption(burnTxHash string) (*event.RedemptionInfo, bool) {
+	if burnTxHash != f.info.BurnTxHash {
+		return nil, false
+	}
+	return f.info, true
+}
+
+func newRedemptionServer(t *testing.T) *httptest.Server {
+	handler := &fakeRedemptions{info: &event.RedemptionInfo{
+		BurnTxHash: BURN_TX_HASH,
+		Status:     event.PAYOUT_PENDING,
+		Amount:     "1.00",
+	}}
+	server := httptest.NewServer(callback.NewRedemptionServer("", "", handler, zap.NewNop().Sugar()).Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRedemptionServer_Submit(t *testing.T) {
+	cases := []struct {
+		name      string
+		body      string
+		expStatus int
+		expField  string
+		expValue  string
+	}{
+		{name: "accepted", body: `{"encryptedData":"valid"}`, expStatus: http.StatusAccepted, expField: "status", expValue: "PayoutPending"},
+		{name: "refused", body: `{"encryptedData":"forged"}`, expStatus: http.StatusBadRequest, expField: "error", expValue: "Invalid redemption instruction"},
+		{name: "empty", body: `{}`, expStatus: http.StatusBadRequest, expField: "error", expValue: "Malformed redemption request"},
+		{name: "malformed", body: `not json`, expStatus: http.StatusBadRequest, expField: "error", expValue: "Malformed redemption request"},
+	}
+	server := newRedemptionServer(t)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act
+			resp, err := http.Post(server.URL+callback.DEFAULT_REDEMPTION_PATH, "application/json", bytes.NewReader([]byte(c.body)))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			// assert
+			assert.Equal(t, c.expStatus, resp.StatusCode)
+			var result map[string]string
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+			assert.Equal(t, c.expValue, result[c.expField])
+		})
+	}
+}
+
+func TestRedemptionServer_Status(t *testing.T) {
+	cases := []struct {
+		name      string
+		method    string
+		hash      string
+		expStatus int
+	}{
+		{name: "known", method: http.MethodGet, hash: BURN_TX_HASH, expStatus: http.StatusOK},
+		{name: "unknown", method: http.MethodGet, hash: "0x1234", expStatus: http.StatusNotFound},
+		{name: "wrong method", method: http.MethodDelete, hash: BURN_TX_HASH, expStatus: http.StatusMethodNotAllowed},
+	}
+	server := newRedemptionServer(t)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			req, err := http.NewRequest(c.method, server.URL+callback.DEFAULT_REDEMPTION_PATH+"/"+c.hash, nil)
+			require.NoError(t, err)
+
+			// act
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			// assert
+			assert.Equal(t, c.expStatus, resp.StatusCode)
+		})
+	}
+}
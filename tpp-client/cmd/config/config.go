@@ -1,9 +1,20 @@
 package config
 
 import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"github.com/pelletier/go-toml/v2"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
 	"go.uber.org/zap"
 	"os"
+	f "path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
 )
 
 type Config struct {
@@ -12,6 +23,99 @@ type Config struct {
 		ClientId     string
 		ClientSecret string
 		RedirectUrl  string
+		// PEM files of the OB signing certificate and its private key. Required unless ReducedSecurity.
+		SigningCertFile string
+		SigningKeyFile  string
+		SigningKeyId    string
+		// ReducedSecurity sends the requests unsigned, with the Natwest sandbox's placeholder signature, and accepts
+		// ID tokens without verifying their signature. Sandboxes only!
+		ReducedSecurity bool
+		// `iss` of the request signatures, i.e. `{org-id}/{software-statement-id}`
+		JwsIssuer string
+		// PEM file of the ASPSP's signing certificate. Response signatures are not verified if empty.
+		AspspSigningCertFile string
+		// AspspJwksUrl to verify the ID tokens against. AspspSigningCertFile is used if empty.
+		// One of them is required unless ReducedSecurity.
+		AspspJwksUrl string
+		// AspspIssuer of the ID tokens. Defaults to the Natwest sandbox.
+		AspspIssuer string
+		// FinancialId is the ASPSP's OB organisation ID, sent as x-fapi-financial-id. Omitted if empty.
+		FinancialId string
+		// PEM files of the OB transport certificate and its private key, presented in MTLS connections
+		TransportCertFile string
+		TransportKeyFile  string
+		// PEM bundle of the CAs trusted for the ASPSP connections, in addition to the system ones
+		CaBundleFile string
+		// client_secret_post (default), tls_client_auth or private_key_jwt
+		TokenAuthMethod string
+		// check payment statuses with a client_credentials token once the payment's token cannot be refreshed
+		StatusClientCredentials bool
+		// check the payer's funds before submitting a payment
+		ConfirmFunds bool
+		// templates of the payments' remittance reference (max. 35 characters) and information (max. 140).
+		// Placeholders: {requestId}, {shortId}, {endToEndId}, {amount}. Defaults are used if empty.
+		RemittanceReference    string
+		RemittanceUnstructured string
+	}
+	Callback struct {
+		// ListenAddress of the server receiving the ASPSP's redirects on RedirectUrl, e.g. ":8080". Not started if empty.
+		// Requires AspspJwksUrl or AspspSigningCertFile to verify the ID tokens.
+		ListenAddress string
+		// DappOrigin is the origin of the dApp, e.g. "https://dapp.example.com", which the landing page hands the
+		// authorisation to. The payer submits it manually if empty.
+		DappOrigin string
+	}
+	AccountInfo struct {
+		// Enabled looks up the payments' credits on BankAccount before minting
+		Enabled bool
+		// Policy is `both` (default) to require the settled payment and its credit, or `either`
+		Policy string
+		// RefreshToken of an account access consent for BankAccount, authorised by the account owner
+		RefreshToken string
+		// SandboxUsername authorises the account access at start-up instead. Natwest sandbox only.
+		SandboxUsername string
+	}
+	Registration struct {
+		// RegisterUrl of the ASPSP's Dynamic Client Registration endpoint
+		RegisterUrl string
+		// SoftwareStatementFile holds the SSA issued by the OB directory
+		SoftwareStatementFile string
+		// Audience of the registration request, i.e. the ASPSP's issuer or OB organisation ID
+		Audience string
+		// TlsClientAuthSubjectDn of the transport certificate, for tls_client_auth
+		TlsClientAuthSubjectDn string
+		// Scope requested. Defaults to `openid payments accounts`.
+		Scope string
+	}
+	AutoApprove struct {
+		// Enabled approves every consent as soon as it is created and submits its payment. Sandboxes only!
+		Enabled bool
+		// Username of the sandbox customer approving the consents
+		Username string
+	}
+	Redemption struct {
+		// Enabled pays the burnt tokens out of BankAccount, to the accounts of the holders' instructions
+		Enabled bool
+		// ListenAddress of the server receiving the holders' instructions, e.g. ":8081". Required if enabled.
+		ListenAddress string
+		// Path of the instructions. Defaults to `/redemptions`.
+		Path string
+		// StoreFile keeps the submitted, paid out and failed redemptions across restarts, i.e. the refunds owed.
+		// Required if enabled.
+		StoreFile string
+		// ApproverUsername authorises the payouts as the owner of BankAccount. Sandboxes only.
+		ApproverUsername string
+		// Sandbox confirms that the payouts are approved headlessly as ApproverUsername, which only the Natwest
+		// sandbox supports. There is no approval step for a real ASPSP yet, so redemptions do not start without it.
+		Sandbox bool
+	}
+	NameCheck struct {
+		// Url of the Confirmation of Payee style name verification. Payer names are not checked if empty.
+		Url string
+		// actions on close matches (default `warn`), no match (`reject`) and unavailable checks (`proceed`)
+		Close       string
+		None        string
+		Unavailable string
 	}
 	Ethereum struct {
 		ProviderUrl     string
@@ -20,15 +124,38 @@ type Config struct {
 		MaxGas          int64
 	}
 	Tuning struct {
-		BankCronSchedule  int
-		ChainCronSchedule int
-		BankClientTimeout int
-		StartingBlock     uint64
+		BankCronSchedule int
+		// ConsentCronSchedule of open consent checks. 0 uses BankCronSchedule.
+		ConsentCronSchedule int
+		ChainCronSchedule   int
+		BankClientTimeout   int
+		// BankMaxAttempts of retryable bank calls, including the first one. 0 uses the client's default.
+		BankMaxAttempts int
+		// BankMaxRetryAfter is the longest Retry-After of the ASPSP waited for, in seconds. 0 uses the client's default.
+		BankMaxRetryAfter int
+		// BankRateLimit of the calls per second to each bank endpoint, in bursts of up to BankRateBurst.
+		// 0 uses the defaults, negative disables rate limiting.
+		BankRateLimit float64
+		BankRateBurst int
+		// BankBreakerFailures consecutive failures open the bank's circuit breaker for BankBreakerCooldown seconds,
+		// failing mint requests fast. 0 uses the defaults, negative disables the breaker.
+		BankBreakerFailures int
+		BankBreakerCooldown int
+		// BankMetricsSchedule logs the bank call latencies every so many seconds. Disabled if 0.
+		BankMetricsSchedule int
+		StartingBlock       uint64
 	}
 	BankAccount struct {
 		SortCode      string
 		AccountNumber string
-		AccountName   string
+		// Iban identifies the account instead of the sort code and account number, if set
+		Iban string
+		// SecondaryIdentification, e.g. a building society roll number
+		SecondaryIdentification string
+		AccountName             string
+		// ModulusTableFile is the Vocalink weight table (valacdos.txt) to modulus check the accounts against.
+		// Sort codes are not checked if empty.
+		ModulusTableFile string
 	}
 }
 
@@ -49,3 +176,190 @@ func LoadConfig(path string, l *zap.SugaredLogger) (*Config, error) {
 		return LoadConfigData(data, l)
 	}
 }
+
+// OauthClientCreds builds the bank client credentials, loading any certificates and keys from their files
+func (c *Config) OauthClientCreds() (*bank.OauthClientCreds, error) {
+	creds := bank.OauthClientCreds{
+		ClientId:        c.BankClient.ClientId,
+		ClientSecret:    c.BankClient.ClientSecret,
+		RedirectionUrl:  c.BankClient.RedirectUrl,
+		SigningKeyId:    c.BankClient.SigningKeyId,
+		JwsIssuer:       c.BankClient.JwsIssuer,
+		TokenAuthMethod: c.BankClient.TokenAuthMethod,
+		ReducedSecurity: c.BankClient.ReducedSecurity,
+	}
+	if c.BankClient.TransportCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.BankClient.TransportCertFile, c.BankClient.TransportKeyFile)
+		if err != nil {
+			return nil, errors.New("Unable to load transport certificate: " + err.Error())
+		}
+		creds.TransportCert = cert
+	}
+	if c.BankClient.CaBundleFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		data, err := os.ReadFile(c.BankClient.CaBundleFile)
+		if err != nil {
+			return nil, errors.New("Unable to read CA bundle: " + err.Error())
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("No certificates found in CA bundle")
+		}
+		creds.RootCAs = pool
+	}
+	if c.BankClient.SigningCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.BankClient.SigningCertFile, c.BankClient.SigningKeyFile)
+		if err != nil {
+			return nil, errors.New("Unable to load signing certificate: " + err.Error())
+		}
+		// fail early, rather than on the first request
+		if _, err = bank.NewJwsSigner(&cert, creds.SigningKeyId, creds.JwsIssuer); err != nil {
+			return nil, err
+		}
+		creds.SigningCert = cert
+	} else if !creds.ReducedSecurity {
+		return nil, errors.New("SigningCertFile is required, unless ReducedSecurity is enabled in the sandbox")
+	}
+
+	switch creds.TokenAuthMethod {
+	case "", bank.CLIENT_SECRET_POST:
+	case bank.TLS_CLIENT_AUTH:
+		if len(creds.TransportCert.Certificate) == 0 {
+			return nil, errors.New("tls_client_auth requires TransportCertFile")
+		}
+	case bank.PRIVATE_KEY_JWT:
+		if creds.SigningCert.PrivateKey == nil {
+			return nil, errors.New("private_key_jwt requires SigningCertFile")
+		}
+	default:
+		return nil, errors.New("Unsupported TokenAuthMethod: " + creds.TokenAuthMethod)
+	}
+	return &creds, nil
+}
+
+// AspspSigningKey returns the public key of the ASPSP's signing certificate, or nil if not configured
+func (c *Config) AspspSigningKey() (crypto.PublicKey, error) {
+	if c.BankClient.AspspSigningCertFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(c.BankClient.AspspSigningCertFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("No PEM data in ASPSP signing certificate file")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.New("Unable to parse ASPSP signing certificate: " + err.Error())
+	}
+	return cert.PublicKey, nil
+}
+
+var (
+	sectionPattern = regexp.MustCompile(`^\s*\[([^\]]+)\]`)
+	keyPattern     = regexp.MustCompile(`^\s*([A-Za-z0-9_-]+)\s*=`)
+)
+
+// UpdateBankClientCredentials sets the ClientId and ClientSecret of the config file's [BankClient] section,
+// keeping the rest of the file, comments included, as is
+func UpdateBankClientCredentials(path string, clientId string, clientSecret string) error {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !utf8.ValidString(clientId) || !utf8.ValidString(clientSecret) {
+		return errors.New("Unable to update config: client credentials are not valid UTF-8")
+	}
+	values := map[string]string{
+		"ClientId":     tomlString(clientId),
+		"ClientSecret": tomlString(clientSecret),
+	}
+
+	lines := strings.Split(string(data), "\n")
+	var updated []string
+	section := ""
+	headerAt := -1
+	for _, line := range lines {
+		if m := sectionPattern.FindStringSubmatch(line); m != nil {
+			section = strings.TrimSpace(m[1])
+			if section == "BankClient" {
+				headerAt = len(updated)
+			}
+		} else if m := keyPattern.FindStringSubmatch(line); m != nil && section == "BankClient" {
+			if v, ok := values[m[1]]; ok {
+				line = m[1] + " = " + v
+				delete(values, m[1])
+			}
+		}
+		updated = append(updated, line)
+	}
+
+	// add the keys missing from the file
+	var missing []string
+	for _, k := range []string{"ClientId", "ClientSecret"} {
+		if v, ok := values[k]; ok {
+			missing = append(missing, k+" = "+v)
+		}
+	}
+	if headerAt < 0 {
+		updated = append(updated, "[BankClient]")
+		updated = append(updated, missing...)
+	} else if len(missing) > 0 {
+		rest := append(missing, updated[headerAt+1:]...)
+		updated = append(updated[:headerAt+1], rest...)
+	}
+
+	// validate before writing
+	out := []byte(strings.Join(updated, "\n"))
+	if _, err = LoadConfigData(out, nil); err != nil {
+		return errors.New("Unable to update config: " + err.Error())
+	}
+	return replaceFile(path, out)
+}
+
+// tomlString quotes s as a TOML basic string. Go's quoting of control characters (\a, \x1b...) is not valid TOML
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, "\\u%04X", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// replaceFile writes data to a private temp file next to path and moves it in place,
+// so that a failed write never leaves the config truncated or readable by others
+func replaceFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(f.Dir(path), f.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
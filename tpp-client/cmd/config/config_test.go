@@ -1,12 +1,19 @@
 package config_test
 
 import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
 	"github.com/sgerogia/sol-stablecoin/tpp-client/cmd/config"
+	test_util "github.com/sgerogia/sol-stablecoin/tpp-client/util"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
+	"os"
 	f "path/filepath"
 	"runtime"
+	"strconv"
 	"testing"
 )
 
@@ -32,3 +39,151 @@ func TestLoadConfigData(t *testing.T) {
 	assert.Equal(t, uint64(10), c.Tuning.StartingBlock)
 	assert.Equal(t, "ProvableGBP Limited", c.BankAccount.AccountName)
 }
+
+func TestOauthClientCreds(t *testing.T) {
+
+	// arrange: write a transport cert & key to disk
+	cert, err := test_util.NewSelfSignedCert("tpp.test")
+	require.NoError(t, err)
+	dir := t.TempDir()
+	certFile := f.Join(dir, "transport.pem")
+	keyFile := f.Join(dir, "transport.key")
+	require.NoError(t, os.WriteFile(certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	require.NoError(t, os.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(cert.PrivateKey.(*rsa.PrivateKey))}), 0600))
+
+	l := zaptest.NewLogger(t).Sugar()
+	c, err := config.LoadConfigData([]byte(`
+[BankClient]
+ClientId = "XYZ"
+TransportCertFile = "`+certFile+`"
+TransportKeyFile = "`+keyFile+`"
+CaBundleFile = "`+certFile+`"
+TokenAuthMethod = "tls_client_auth"
+ReducedSecurity = true
+`), l)
+	require.NoError(t, err)
+
+	// act
+	creds, err := c.OauthClientCreds()
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, "XYZ", creds.ClientId)
+	assert.Equal(t, bank.TLS_CLIENT_AUTH, creds.TokenAuthMethod)
+	assert.Len(t, creds.TransportCert.Certificate, 1)
+	assert.NotNil(t, creds.RootCAs)
+}
+
+func TestOauthClientCreds_AuthMethodWithoutKeys(t *testing.T) {
+	l := zaptest.NewLogger(t).Sugar()
+	for _, m := range []string{"tls_client_auth", "private_key_jwt", "unknown"} {
+		c, err := config.LoadConfigData([]byte(`
+[BankClient]
+TokenAuthMethod = "`+m+`"
+ReducedSecurity = true
+`), l)
+		require.NoError(t, err)
+
+		_, err = c.OauthClientCreds()
+
+		assert.Error(t, err, m)
+	}
+}
+
+func TestOauthClientCreds_SigningRequired(t *testing.T) {
+	l := zaptest.NewLogger(t).Sugar()
+	for _, reduced := range []bool{false, true} {
+		c, err := config.LoadConfigData([]byte(`
+[BankClient]
+ClientId = "XYZ"
+ReducedSecurity = `+strconv.FormatBool(reduced)+`
+`), l)
+		require.NoError(t, err)
+
+		creds, err := c.OauthClientCreds()
+
+		if reduced {
+			require.NoError(t, err)
+			assert.True(t, creds.ReducedSecurity)
+		} else {
+			assert.ErrorContains(t, err, "SigningCertFile is required")
+		}
+	}
+}
+
+func TestUpdateBankClientCredentials(t *testing.T) {
+	cases := []struct {
+		name   string
+		config string
+	}{
+		{name: "existing keys", config: `
+# comment kept
+[BankClient]
+ClientId = "OLD"
+# secret below
+ClientSecret = "OLD"
+RedirectUrl = "http://localhost:8080/callback"
+`},
+		{name: "missing keys", config: `
+[BankClient]
+RedirectUrl = "http://localhost:8080/callback"
+`},
+		{name: "missing section", config: `
+[Tuning]
+BankClientTimeout = 30
+`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			file := f.Join(t.TempDir(), "tpp-client.toml")
+			require.NoError(t, os.WriteFile(file, []byte(c.config), 0600))
+
+			// act
+			err := config.UpdateBankClientCredentials(file, "NEW-ID", "NEW-SECRET")
+
+			// assert
+			require.NoError(t, err)
+			data, err := os.ReadFile(file)
+			require.NoError(t, err)
+			conf, err := config.LoadConfigData(data, zaptest.NewLogger(t).Sugar())
+			require.NoError(t, err)
+			assert.Equal(t, "NEW-ID", conf.BankClient.ClientId)
+			assert.Equal(t, "NEW-SECRET", conf.BankClient.ClientSecret)
+			assert.NotContains(t, string(data), "OLD")
+			if c.name == "existing keys" {
+				assert.Contains(t, string(data), "# comment kept")
+				assert.Contains(t, string(data), "# secret below")
+			}
+			if c.name != "missing section" {
+				assert.Equal(t, "http://localhost:8080/callback", conf.BankClient.RedirectUrl)
+			}
+		})
+	}
+}
+
+func TestUpdateBankClientCredentials_SpecialCharacters(t *testing.T) {
+	// arrange
+	file := f.Join(t.TempDir(), "tpp-client.toml")
+	require.NoError(t, os.WriteFile(file, []byte("[BankClient]\nClientId = \"OLD\"\n"), 0644))
+	secret := "q\"uo\\te\x07bell\x1b\ttab\x7fdelé\U0001F600"
+
+	// act
+	err := config.UpdateBankClientCredentials(file, "NEW-ID", secret)
+
+	// assert
+	require.NoError(t, err)
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	conf, err := config.LoadConfigData(data, zaptest.NewLogger(t).Sugar())
+	require.NoError(t, err)
+	assert.Equal(t, secret, conf.BankClient.ClientSecret)
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	entries, err := os.ReadDir(f.Dir(file))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
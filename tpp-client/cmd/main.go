@@ -1,12 +1,14 @@
 package main
 
 import (
+	"crypto"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/go-co-op/gocron"
 	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
 	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/callback"
 	"github.com/sgerogia/sol-stablecoin/tpp-client/cmd/config"
 	contract2 "github.com/sgerogia/sol-stablecoin/tpp-client/contract"
 	"github.com/sgerogia/sol-stablecoin/tpp-client/encrypt"
@@ -15,13 +17,28 @@ import (
 	"github.com/sgerogia/sol-stablecoin/tpp-client/schedule"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"math"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 )
 
+// NATWEST_INSTITUTION names the ASPSP in the bank errors and logs
+const NATWEST_INSTITUTION = "natwest-sandbox"
+
 func main() {
+	// subcommands
+	if len(os.Args) > 1 && os.Args[1] == "register" {
+		if err := register(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "Registration failed: "+err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	if os.Getenv("PRIVATE_KEY") == "" {
 		panic(errors.New("PRIVATE_KEY env var not set"))
 	}
@@ -31,29 +48,7 @@ func main() {
 	var configPath = flag.String("config", "./tpp-client.toml", "Location of the config TOML file.\nDefaults to ./tpp-client.toml")
 	flag.Parse()
 
-	cfg := zap.Config{
-		Encoding:         "json",
-		Level:            zap.NewAtomicLevelAt(zapcore.DebugLevel),
-		OutputPaths:      []string{"stderr"},
-		ErrorOutputPaths: []string{"stderr"},
-		EncoderConfig: zapcore.EncoderConfig{
-			MessageKey: "m",
-
-			LevelKey:    "l",
-			EncodeLevel: zapcore.CapitalLevelEncoder,
-
-			TimeKey:    "t",
-			EncodeTime: zapcore.ISO8601TimeEncoder,
-
-			CallerKey:    "c",
-			EncodeCaller: zapcore.ShortCallerEncoder,
-		},
-	}
-	l, err := cfg.Build()
-	if err != nil {
-		panic("Unable to create logger")
-	}
-	logger := l.Sugar()
+	logger := newLogger()
 
 	// Create channel to notify the main goroutine when to stop the server.
 	errc := make(chan error)
@@ -84,6 +79,32 @@ func main() {
 	logger.Info("Done.")
 }
 
+func newLogger() *zap.SugaredLogger {
+	cfg := zap.Config{
+		Encoding:         "json",
+		Level:            zap.NewAtomicLevelAt(zapcore.DebugLevel),
+		OutputPaths:      []string{"stderr"},
+		ErrorOutputPaths: []string{"stderr"},
+		EncoderConfig: zapcore.EncoderConfig{
+			MessageKey: "m",
+
+			LevelKey:    "l",
+			EncodeLevel: zapcore.CapitalLevelEncoder,
+
+			TimeKey:    "t",
+			EncodeTime: zapcore.ISO8601TimeEncoder,
+
+			CallerKey:    "c",
+			EncodeCaller: zapcore.ShortCallerEncoder,
+		},
+	}
+	l, err := cfg.Build()
+	if err != nil {
+		panic("Unable to create logger")
+	}
+	return l.Sugar()
+}
+
 func startClients(
 	conf *config.Config,
 	privateKey string,
@@ -107,32 +128,146 @@ func startClients(
 	}
 
 	// get bank client
-	cr := bank.OauthClientCreds{
-		ClientId:       conf.BankClient.ClientId,
-		ClientSecret:   conf.BankClient.ClientSecret,
-		RedirectionUrl: conf.BankClient.RedirectUrl,
+	cr, err := conf.OauthClientCreds()
+	if err != nil {
+		return nil, nil, errors.New("Unable to load bank client credentials: " + err.Error())
 	}
 	bankClient := bank_impl.NewNatwestSandboxClient(
 		conf.Tuning.BankClientTimeout,
-		&cr,
+		cr,
 		l,
 	)
+	aspspKey, err := conf.AspspSigningKey()
+	if err != nil {
+		return nil, nil, errors.New("Unable to load ASPSP signing key: " + err.Error())
+	}
+	if aspspKey != nil {
+		bankClient.(*bank_impl.NatwestSandboxClient).SetResponseKeyResolver(bank.StaticKeyResolver(aspspKey))
+	}
+	bankClient.(*bank_impl.NatwestSandboxClient).SetStatusClientCredentials(conf.BankClient.StatusClientCredentials)
+	bankClient.(*bank_impl.NatwestSandboxClient).SetFinancialId(conf.BankClient.FinancialId)
+	if conf.Tuning.BankMaxAttempts > 0 || conf.Tuning.BankMaxRetryAfter > 0 {
+		retry := bank_impl.DefaultRetryPolicy()
+		if conf.Tuning.BankMaxAttempts > 0 {
+			retry.MaxAttempts = conf.Tuning.BankMaxAttempts
+		}
+		retry.MaxRetryAfter = time.Duration(conf.Tuning.BankMaxRetryAfter) * time.Second
+		bankClient.(*bank_impl.NatwestSandboxClient).SetRetryPolicy(retry)
+	}
+	remittance := bank_impl.DefaultRemittance()
+	if conf.BankClient.RemittanceReference != "" {
+		remittance.Reference = conf.BankClient.RemittanceReference
+	}
+	if conf.BankClient.RemittanceUnstructured != "" {
+		remittance.Unstructured = conf.BankClient.RemittanceUnstructured
+	}
+	if err = bankClient.(*bank_impl.NatwestSandboxClient).SetRemittance(remittance); err != nil {
+		return nil, nil, errors.New("Invalid remittance template: " + err.Error())
+	}
+
+	// latencies of the bank calls
+	metrics := bank_impl.NewInMemoryMetrics()
+	bankClient.(*bank_impl.NatwestSandboxClient).SetTransport(bank_impl.RecordMetrics(metrics))
+
+	// throttle the bank calls and fail fast while the bank is down
+	guardedClient := bank_impl.NewGuardedClient(bankClient, NATWEST_INSTITUTION, guardPolicy(conf), l)
+
+	// ID tokens returned with the consent codes. Only their nonce is checked if the ASPSP's keys are not configured.
+	idTokens := idTokenValidator(conf, cr, aspspKey)
+	if idTokens == nil {
+		if !conf.BankClient.ReducedSecurity {
+			return nil, nil, errors.New("ID tokens cannot be verified. Set AspspJwksUrl or AspspSigningCertFile, " +
+				"unless ReducedSecurity is enabled in the sandbox")
+		}
+		l.Warn("ID tokens are not verified, only their nonce. Set AspspJwksUrl or AspspSigningCertFile.")
+	}
+
+	// account information
+	var accountInfo bank.AccountInformationClient
+	creditPolicy := event.CreditPolicy(conf.AccountInfo.Policy)
+	if conf.AccountInfo.Enabled {
+		if accountInfo, err = startAccountInfo(conf, bankClient.(*bank_impl.NatwestSandboxClient)); err != nil {
+			return nil, nil, err
+		}
+		if creditPolicy == "" {
+			creditPolicy = event.CREDIT_BOTH
+		}
+		if creditPolicy != event.CREDIT_BOTH && creditPolicy != event.CREDIT_EITHER {
+			return nil, nil, errors.New("Unsupported AccountInfo policy: " + conf.AccountInfo.Policy)
+		}
+	}
+
+	// payer name checks
+	var nameVerifier bank.NameVerificationClient
+	namePolicy := event.NamePolicy{
+		Close:       event.NameAction(conf.NameCheck.Close),
+		None:        event.NameAction(conf.NameCheck.None),
+		Unavailable: event.NameAction(conf.NameCheck.Unavailable),
+	}
+	if err = namePolicy.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if conf.NameCheck.Url != "" {
+		nameVerifier = bank_impl.NewCopClient(conf.Tuning.BankClientTimeout, conf.NameCheck.Url, cr, l)
+	}
+
+	// auto-approval, for non-production environments
+	var approver bank.ConsentApprover
+	if conf.AutoApprove.Enabled {
+		if conf.AutoApprove.Username == "" {
+			return nil, nil, errors.New("AutoApprove requires a Username")
+		}
+		l.Warnw("Consents are auto-approved. Never enable this in production!",
+			"username", conf.AutoApprove.Username)
+		approver = bankClient.(*bank_impl.NatwestSandboxClient)
+	}
 
 	// scheduling & event handling
 	sch := schedule.NewPaymentScheduler(l)
+	consentSch := schedule.NewConsentScheduler(l)
 	rcv := bank.AccountDetails{
-		AccountNumber: conf.BankAccount.AccountNumber,
-		SortCode:      conf.BankAccount.SortCode,
-		Name:          conf.BankAccount.AccountName,
+		AccountNumber:           conf.BankAccount.AccountNumber,
+		SortCode:                conf.BankAccount.SortCode,
+		Iban:                    conf.BankAccount.Iban,
+		SecondaryIdentification: conf.BankAccount.SecondaryIdentification,
+		Name:                    conf.BankAccount.AccountName,
+	}
+	var modulus *bank.ModulusTable
+	if conf.BankAccount.ModulusTableFile != "" {
+		if modulus, err = bank.LoadModulusTable(conf.BankAccount.ModulusTableFile); err != nil {
+			return nil, nil, errors.New("Unable to load modulus table: " + err.Error())
+		}
 	}
-	handler := event_impl.NewEventHandler(
+	if err = rcv.Validate(modulus); err != nil {
+		return nil, nil, errors.New("Invalid BankAccount: " + err.Error())
+	}
+	handler := event_impl.NewEventHandlerWithOptions(
 		chainClient,
 		keyPair,
-		bankClient,
+		guardedClient,
 		&rcv,
 		sch,
+		consentSch,
+		event_impl.HandlerOptions{
+			ConfirmFunds:     conf.BankClient.ConfirmFunds,
+			IdTokens:         idTokens,
+			ModulusTable:     modulus,
+			NameVerifier:     nameVerifier,
+			NamePolicy:       namePolicy,
+			ConsentApprover:  approver,
+			ApproverUsername: conf.AutoApprove.Username,
+			AccountInfo:      accountInfo,
+			CreditPolicy:     creditPolicy,
+		},
 		l)
 
+	// optional redirect callback
+	if conf.Callback.ListenAddress != "" {
+		if err = startCallbackServer(conf, idTokens, handler, keyPair, l); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	subscriber := event_impl.NewEventSubscriber(
 		handler,
 		chainClient,
@@ -154,20 +289,203 @@ func startClients(
 
 	// schedule bank polling
 	l.Info("Starting bank polling scheduler")
-	paymentTask := schedule.NewPaymentStatusTask(sch, bankClient, handler, l)
+	paymentTask := schedule.NewPaymentStatusTask(sch, guardedClient, handler, l)
 	s := gocron.NewScheduler(time.UTC)
 	s.Every(conf.Tuning.BankCronSchedule).Seconds().Do(paymentTask.CheckPaymentStatuses)
+	consentTask := schedule.NewConsentStatusTask(consentSch, guardedClient, handler, l)
+	consentSchedule := conf.Tuning.ConsentCronSchedule
+	if consentSchedule == 0 {
+		consentSchedule = conf.Tuning.BankCronSchedule
+	}
+	s.Every(consentSchedule).Seconds().Do(consentTask.CheckConsentStatuses)
+
+	if conf.Tuning.BankMetricsSchedule > 0 {
+		s.Every(conf.Tuning.BankMetricsSchedule).Seconds().Do(logMetrics, metrics, l)
+	}
 
 	// schedule chain polling
 	l.Info("Starting contract polling scheduler")
 	contractTask := schedule.NewContractEventTask(conf.Tuning.StartingBlock, chainClient, &handler, l)
 	s.Every(conf.Tuning.ChainCronSchedule).Seconds().Do(contractTask.FetchAndProcessEvents)
-	
+
+	// optional redemptions, paid out of our account
+	if conf.Redemption.Enabled {
+		err = startRedemptions(conf, chainClient, keyPair, guardedClient, bankClient.(*bank_impl.NatwestSandboxClient), &rcv, modulus, s, l)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
 	s.StartAsync()
-	
+
 	return &subscriber, s, nil
 }
 
+// startRedemptions schedules the burn polling and payout checks, and serves the holders' instructions
+func startRedemptions(
+	conf *config.Config,
+	chainClient *contract2.ContractClient,
+	keyPair *encrypt.KeyPair,
+	bankClient bank.OpenBankingClient,
+	approver bank.ConsentApprover,
+	account *bank.AccountDetails,
+	modulus *bank.ModulusTable,
+	s *gocron.Scheduler,
+	l *zap.SugaredLogger,
+) error {
+
+	if conf.Redemption.ListenAddress == "" {
+		return errors.New("Redemption requires a ListenAddress")
+	}
+	if !conf.Redemption.Sandbox {
+		return errors.New("Redemption payouts can only be approved in the sandbox. Set Redemption.Sandbox to confirm")
+	}
+	if conf.Redemption.ApproverUsername == "" {
+		return errors.New("Redemption requires an ApproverUsername to approve the payouts")
+	}
+	if conf.Redemption.StoreFile == "" {
+		return errors.New("Redemption requires a StoreFile to keep the redemptions")
+	}
+	l.Warnw("Payouts are approved as the sandbox customer. Never enable this in production!",
+		"username", conf.Redemption.ApproverUsername)
+
+	store, err := event_impl.NewFileRedemptionStore(conf.Redemption.StoreFile)
+	if err != nil {
+		return errors.New("Unable to load the redemption store: " + err.Error())
+	}
+	for _, refund := range store.Refunds() {
+		l.Warnw("Failed redemption. The holder must be refunded",
+			"burnTxHash", refund.BurnTxHash,
+			"holder", refund.Holder,
+			"amount", refund.Amount,
+			"reason", refund.Reason)
+	}
+
+	payoutSch := schedule.NewPaymentScheduler(l)
+	handler := event_impl.NewRedemptionHandler(
+		keyPair,
+		bankClient,
+		approver,
+		conf.Redemption.ApproverUsername,
+		account,
+		payoutSch,
+		modulus,
+		store,
+		l)
+
+	server := callback.NewRedemptionServer(conf.Redemption.ListenAddress, conf.Redemption.Path, handler, l)
+	if err := server.Start(); err != nil {
+		return err
+	}
+
+	l.Info("Starting redemption schedulers")
+	payoutTask := schedule.NewPaymentStatusTask(payoutSch, bankClient, handler, l)
+	s.Every(conf.Tuning.BankCronSchedule).Seconds().Do(payoutTask.CheckPaymentStatuses)
+	burnTask := schedule.NewBurnEventTask(conf.Tuning.StartingBlock, chainClient, handler, l)
+	s.Every(conf.Tuning.ChainCronSchedule).Seconds().Do(burnTask.FetchAndProcessBurns)
+	return nil
+}
+
+// logMetrics logs the bank call latencies so far, per endpoint
+func logMetrics(metrics *bank_impl.InMemoryMetrics, l *zap.SugaredLogger) {
+	for endpoint, stats := range metrics.Snapshot() {
+		l.Infow("Bank call metrics",
+			"endpoint", endpoint,
+			"calls", stats.Calls,
+			"errors", stats.Errors,
+			"mean", stats.Mean(),
+			"max", stats.Max)
+	}
+}
+
+// guardPolicy returns the default guard policy, with the configured overrides
+func guardPolicy(conf *config.Config) *bank_impl.GuardPolicy {
+	policy := bank_impl.DefaultGuardPolicy()
+	if conf.Tuning.BankRateLimit != 0 {
+		policy.RatePerSecond = math.Max(conf.Tuning.BankRateLimit, 0)
+	}
+	if conf.Tuning.BankRateBurst > 0 {
+		policy.Burst = conf.Tuning.BankRateBurst
+	}
+	if conf.Tuning.BankBreakerFailures != 0 {
+		policy.FailureThreshold = conf.Tuning.BankBreakerFailures
+	}
+	if conf.Tuning.BankBreakerCooldown > 0 {
+		policy.OpenTimeout = time.Duration(conf.Tuning.BankBreakerCooldown) * time.Second
+	}
+	return policy
+}
+
+// startAccountInfo sets up the client's access to our account
+func startAccountInfo(conf *config.Config, client *bank_impl.NatwestSandboxClient) (bank.AccountInformationClient, error) {
+	switch {
+	case conf.AccountInfo.RefreshToken != "":
+		client.SetAccountAccessRefreshToken(conf.AccountInfo.RefreshToken)
+	case conf.AccountInfo.SandboxUsername != "":
+		if err := client.AuthoriseAccountAccess(conf.AccountInfo.SandboxUsername); err != nil {
+			return nil, errors.New("Unable to authorise account access: " + err.Error())
+		}
+	default:
+		return nil, errors.New("AccountInfo requires RefreshToken or SandboxUsername")
+	}
+	return client, nil
+}
+
+// idTokenValidator verifies ID tokens against the ASPSP's JWKS or signing certificate, if configured
+func idTokenValidator(conf *config.Config, cr *bank.OauthClientCreds, aspspKey crypto.PublicKey) *bank.IdTokenValidator {
+
+	var resolve bank.JwsKeyResolver
+	switch {
+	case conf.BankClient.AspspJwksUrl != "":
+		resolve = bank.JwksKeyResolver(&http.Client{
+			Timeout:   time.Duration(conf.Tuning.BankClientTimeout) * time.Second,
+			Transport: bank_impl.NewTlsTransport(cr),
+		}, conf.BankClient.AspspJwksUrl)
+	case aspspKey != nil:
+		resolve = bank.StaticKeyResolver(aspspKey)
+	default:
+		return nil
+	}
+	issuer := conf.BankClient.AspspIssuer
+	if issuer == "" {
+		issuer = bank_impl.AUTH_ISSUER
+	}
+	return bank.NewIdTokenValidator(issuer, cr.ClientId, resolve)
+}
+
+// startCallbackServer serves the redirect callback on the path of the configured RedirectUrl
+func startCallbackServer(
+	conf *config.Config,
+	idTokens *bank.IdTokenValidator,
+	handler event.EventHandler,
+	keyPair *encrypt.KeyPair,
+	l *zap.SugaredLogger,
+) error {
+
+	redirect, err := url.Parse(conf.BankClient.RedirectUrl)
+	if err != nil {
+		return errors.New("Invalid RedirectUrl: " + err.Error())
+	}
+	if idTokens == nil {
+		return errors.New("The callback server requires AspspJwksUrl or AspspSigningCertFile to verify ID tokens")
+	}
+	if origin := conf.Callback.DappOrigin; origin != "" {
+		dapp, err := url.Parse(origin)
+		if err != nil || (dapp.Scheme != "https" && dapp.Scheme != "http") || dapp.Host == "" ||
+			dapp.Path != "" || dapp.RawQuery != "" || dapp.Fragment != "" {
+			return errors.New("Invalid DappOrigin, expected scheme://host[:port]: " + origin)
+		}
+	}
+	lookup, ok := handler.(event.AuthorisationLookup)
+	if !ok {
+		return errors.New("The event handler does not support authorisation lookups")
+	}
+
+	server := callback.NewCallbackServer(
+		conf.Callback.ListenAddress, redirect.Path, lookup, keyPair, idTokens, conf.Callback.DappOrigin, l)
+	return server.Start()
+}
+
 type chainInfo struct {
 	providerUrl     string
 	chainId         int64
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/cmd/config"
+	"os"
+	"strings"
+)
+
+// register performs an OB Dynamic Client Registration with the ASPSP and writes the issued credentials
+// into the config file, i.e. `tpp-client register [-config ./tpp-client.toml] [-dry-run]`
+func register(args []string) error {
+
+	flags := flag.NewFlagSet("register", flag.ContinueOnError)
+	configPath := flags.String("config", "./tpp-client.toml", "Location of the config TOML file, updated with the issued credentials")
+	dryRun := flags.Bool("dry-run", false, "Print the signed registration request instead of sending it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	l := newLogger()
+	conf, err := config.LoadConfig(*configPath, l)
+	if err != nil {
+		return errors.New("Unable to load config: " + err.Error())
+	}
+	if conf.Registration.RegisterUrl == "" {
+		return errors.New("Missing Registration.RegisterUrl")
+	}
+	if conf.Registration.SoftwareStatementFile == "" {
+		return errors.New("Missing Registration.SoftwareStatementFile")
+	}
+	ssa, err := os.ReadFile(conf.Registration.SoftwareStatementFile)
+	if err != nil {
+		return errors.New("Unable to read software statement: " + err.Error())
+	}
+	creds, err := conf.OauthClientCreds()
+	if err != nil {
+		return err
+	}
+
+	client, err := bank_impl.NewRegistrationClient(conf.Tuning.BankClientTimeout, conf.Registration.RegisterUrl, creds, l)
+	if err != nil {
+		return err
+	}
+	audience := conf.Registration.Audience
+	if audience == "" {
+		audience = conf.BankClient.AspspIssuer
+	}
+	if audience == "" {
+		audience = bank_impl.AUTH_ISSUER
+	}
+	reg := &bank_impl.Registration{
+		SoftwareStatement:      strings.TrimSpace(string(ssa)),
+		Audience:               audience,
+		RedirectUris:           []string{conf.BankClient.RedirectUrl},
+		TokenAuthMethod:        conf.BankClient.TokenAuthMethod,
+		TlsClientAuthSubjectDn: conf.Registration.TlsClientAuthSubjectDn,
+		Scope:                  conf.Registration.Scope,
+	}
+
+	if *dryRun {
+		jwt, err := client.RequestJwt(reg)
+		if err != nil {
+			return err
+		}
+		fmt.Println(jwt)
+		return nil
+	}
+
+	resp, err := client.Register(reg)
+	if err != nil {
+		return err
+	}
+	if err = config.UpdateBankClientCredentials(*configPath, resp.ClientId, resp.ClientSecret); err != nil {
+		return err
+	}
+	l.Infow("Client registered. Credentials written to the config file",
+		"clientId", resp.ClientId,
+		"config", *configPath)
+	return nil
+}
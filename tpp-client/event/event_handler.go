@@ -2,6 +2,7 @@ package event
 
 import (
 	"encoding/hex"
+	"errors"
 	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
 	"github.com/sgerogia/sol-stablecoin/tpp-client/contract"
 	"github.com/shopspring/decimal"
@@ -20,30 +21,160 @@ type EventHandler interface {
 	// ProcessAuthGranted processes an AuthGranted event coming from the payer via the contract.
 	// I.e. the payer has authorised the payment.
 	// The handler will submit the payment to the bank and schedule the check of the payment's final settlement.
+	// If funds confirmation is enabled and the payer's funds are insufficient, the request fails without a payment
+	// and the payer is notified via the contract's `AuthRequest` method.
 	ProcessAuthGranted(request *contract.ProvableGBPAuthGranted) error
 
 	// ProcessPaymentStatusResponse called by the scheduler when a payment status response is received from the bank.
-	// If the payment is not settled, the method does nothing and returns `false`.
-	// If it is, the method calls the contract's `paymentComplete` method and returns `true` (i.e. stop checking the payment).
+	// If the payment status is not final, the method does nothing and returns `false`.
+	// If the payment is settled, the method calls the contract's `paymentComplete` method and returns `true` (i.e. stop checking the payment).
+	// If account information is enabled, the payment's credit to the beneficiary account is also required, or suffices,
+	// as per the `CreditPolicy`.
+	// If it was rejected, the request is finalised as failed and the payer notified via the contract's `AuthRequest` method.
 	ProcessPaymentStatusResponse(request *bank.PaymentStatusResponse) (bool, error)
+
+	// ProcessConsentStatusResponse called by the scheduler when a consent status response is received from the bank.
+	// If the consent was rejected or has expired, the request is finalised, the payer notified via the contract's
+	// `AuthRequest` method and the method returns `true` (i.e. stop checking the consent).
+	// It also returns `true` once the consent has been used, as the payment takes over.
+	ProcessConsentStatusResponse(request *bank.PaymentConsentStatusResponse) (bool, error)
+}
+
+// AuthorisationLookup finds the requests awaiting the payer's authorisation at the bank.
+// It lets the redirect callback match an authorisation response to its request.
+type AuthorisationLookup interface {
+
+	// FindAuthorisation returns the request whose authorisation request carried the given state,
+	// or `false` if there is none awaiting authorisation
+	FindAuthorisation(state string) (*PendingAuthorisation, bool)
+}
+
+// PendingAuthorisation is a request awaiting the payer's authorisation
+type PendingAuthorisation struct {
+	RequestId string
+	ConsentId string
+	// State and Nonce of the authorisation request
+	State string
+	Nonce string
+	// PublicKey is the payer's encryption key
+	PublicKey []byte
+}
+
+// RequestStatus is the lifecycle state of a mint request, as tracked by the TPP
+type RequestStatus string
+
+const (
+	AWAITING_AUTHORISATION RequestStatus = "AwaitingAuthorisation"
+	// SUBMITTING while the authorised payment is sent to the bank. A mint request goes back to AWAITING_AUTHORISATION
+	// if that fails; a payout stays, as its payment may have been made.
+	SUBMITTING        RequestStatus = "Submitting"
+	PAYMENT_SUBMITTED RequestStatus = "PaymentSubmitted"
+	COMPLETED         RequestStatus = "Completed"
+	// terminal failures
+	REJECTED RequestStatus = "Rejected"
+	EXPIRED  RequestStatus = "Expired"
+	FAILED   RequestStatus = "Failed"
+	// redemptions, until both the burn and the holder's instruction have arrived and the payout is made
+	AWAITING_BURN        RequestStatus = "AwaitingBurn"
+	AWAITING_INSTRUCTION RequestStatus = "AwaitingInstruction"
+	PAYOUT_PENDING       RequestStatus = "PayoutPending"
+)
+
+// IsFinal returns true if the request will not progress any further
+func (s RequestStatus) IsFinal() bool {
+	return s == COMPLETED || s == REJECTED || s == EXPIRED || s == FAILED
+}
+
+// CreditPolicy decides which evidence of the payer's payment is needed before minting,
+// when the credits of the beneficiary account are checked as well as the payment status
+type CreditPolicy string
+
+const (
+	// CREDIT_BOTH requires the payment to be settled and the credit to appear on the beneficiary account
+	CREDIT_BOTH CreditPolicy = "both"
+	// CREDIT_EITHER mints on whichever comes first
+	CREDIT_EITHER CreditPolicy = "either"
+)
+
+// NameAction is what to do with a mint request, depending on the check of the payer's account name
+type NameAction string
+
+const (
+	NAME_PROCEED NameAction = "proceed"
+	// NAME_WARN proceeds, warning the payer along with the authorisation URL
+	NAME_WARN   NameAction = "warn"
+	NAME_REJECT NameAction = "reject"
+)
+
+// NamePolicy decides the action for each outcome of the payer's name check, except exact matches which proceed.
+// Empty actions take the defaults: warn on close matches, reject on no match, proceed if the check is unavailable.
+type NamePolicy struct {
+	Close       NameAction
+	None        NameAction
+	Unavailable NameAction
+}
+
+// Action returns the policy's action for a name check outcome
+func (p *NamePolicy) Action(match bank.NameMatch) NameAction {
+	var action NameAction
+	var def NameAction
+	switch match {
+	case bank.NAME_EXACT:
+		return NAME_PROCEED
+	case bank.NAME_CLOSE:
+		action, def = p.Close, NAME_WARN
+	case bank.NAME_NONE:
+		action, def = p.None, NAME_REJECT
+	default:
+		action, def = p.Unavailable, NAME_PROCEED
+	}
+	if action == "" {
+		return def
+	}
+	return action
+}
+
+// Validate checks that the policy's actions are known
+func (p *NamePolicy) Validate() error {
+	for _, a := range []NameAction{p.Close, p.None, p.Unavailable} {
+		if a != "" && a != NAME_PROCEED && a != NAME_WARN && a != NAME_REJECT {
+			return errors.New("Unsupported name check action: " + string(a))
+		}
+	}
+	return nil
 }
 
 type MintRequestPayload struct {
 	InstitutionId string `json:"institutionId"`
 	SortCode      string `json:"sortCode"`
 	AccountNumber string `json:"accountNumber"`
-	Name          string `json:"name"`
-	PublicKey     string `json:"publicKey"` // in base64
+	// Iban identifies the account instead of the sort code and account number, if set
+	Iban string `json:"iban,omitempty"`
+	// SecondaryIdentification, e.g. a building society roll number
+	SecondaryIdentification string `json:"secondaryIdentification,omitempty"`
+	Name                    string `json:"name"`
+	PublicKey               string `json:"publicKey"` // in base64
 }
 
 type AuthRequestPayload struct {
 	Url       string `json:"url"`
 	ConsentId string `json:"consentId"`
+	// Status is only set when the request has been finalised without a payment, e.g. a rejected consent
+	Status RequestStatus `json:"status,omitempty"`
+	Reason string        `json:"reason,omitempty"`
+	// Warning for the payer to consider before authorising, e.g. a close match of their account name
+	Warning string `json:"warning,omitempty"`
 }
 
 type AuthGrantedPayload struct {
 	ConsentCode string `json:"consentCode"`
 	PublicKey   string `json:"publicKey"` // in base64
+	// State and IdToken as returned by the bank, along with the consent code
+	State   string `json:"state"`
+	IdToken string `json:"idToken"`
+	// AuthDate (Unix time) and CustomerIpAddress of the payer's authorisation, set by the callback server
+	AuthDate          int64  `json:"authDate,omitempty"`
+	CustomerIpAddress string `json:"customerIpAddress,omitempty"`
 }
 
 type PendingPayment struct {
@@ -59,9 +190,11 @@ func NewPaymentAuthRequest(envelope *contract.ProvableGBPMintRequest, payload *M
 		InstitutionId: payload.InstitutionId,
 		Amount:        ToDecimal(envelope.Amount, DECIMAL_DIGITS),
 		Payer: bank.AccountDetails{
-			Name:          payload.Name,
-			SortCode:      payload.SortCode,
-			AccountNumber: payload.AccountNumber,
+			Name:                    payload.Name,
+			SortCode:                payload.SortCode,
+			AccountNumber:           payload.AccountNumber,
+			Iban:                    payload.Iban,
+			SecondaryIdentification: payload.SecondaryIdentification,
 		},
 	}
 }
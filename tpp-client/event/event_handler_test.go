@@ -1,8 +1,12 @@
 package event_test
 
 import (
+	"encoding/json"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/contract"
 	"github.com/sgerogia/sol-stablecoin/tpp-client/event"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"math/big"
 	"testing"
 )
@@ -42,3 +46,63 @@ func TestToWei(t *testing.T) {
 		assert.Equal(t, x, got)
 	}
 }
+
+func TestNewPaymentAuthRequest_AccountSchemes(t *testing.T) {
+	cases := []struct {
+		name           string
+		payload        string
+		scheme         string
+		identification string
+	}{
+		{name: "sort code",
+			payload: `{"institutionId":"natwest","sortCode":"500000","accountNumber":"12345601","name":"John Doe","publicKey":""}`,
+			scheme:  bank.SCHEME_SORT_CODE_ACCOUNT_NUMBER, identification: "50000012345601"},
+		{name: "iban",
+			payload: `{"institutionId":"natwest","iban":"GB29NWBK60161331926819","secondaryIdentification":"ROLL-1","name":"John Doe","publicKey":""}`,
+			scheme:  bank.SCHEME_IBAN, identification: "GB29NWBK60161331926819"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			var payload event.MintRequestPayload
+			require.NoError(t, json.Unmarshal([]byte(c.payload), &payload))
+			envelope := &contract.ProvableGBPMintRequest{Amount: big.NewInt(1)}
+
+			// act
+			req := event.NewPaymentAuthRequest(envelope, &payload)
+
+			// assert
+			assert.Equal(t, c.scheme, req.Payer.Scheme())
+			assert.Equal(t, c.identification, req.Payer.Identification())
+			assert.Equal(t, payload.SecondaryIdentification, req.Payer.SecondaryIdentification)
+			assert.NoError(t, req.Payer.Validate(nil))
+		})
+	}
+}
+
+func TestNamePolicy_Action(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy event.NamePolicy
+		match  bank.NameMatch
+		action event.NameAction
+	}{
+		{name: "exact", policy: event.NamePolicy{Close: event.NAME_REJECT}, match: bank.NAME_EXACT, action: event.NAME_PROCEED},
+		{name: "default close", match: bank.NAME_CLOSE, action: event.NAME_WARN},
+		{name: "default none", match: bank.NAME_NONE, action: event.NAME_REJECT},
+		{name: "default unavailable", match: bank.NAME_UNAVAILABLE, action: event.NAME_PROCEED},
+		{name: "reject close", policy: event.NamePolicy{Close: event.NAME_REJECT}, match: bank.NAME_CLOSE, action: event.NAME_REJECT},
+		{name: "warn unavailable", policy: event.NamePolicy{Unavailable: event.NAME_WARN}, match: bank.NAME_UNAVAILABLE, action: event.NAME_WARN},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// act & assert
+			assert.Equal(t, c.action, c.policy.Action(c.match))
+		})
+	}
+}
+
+func TestNamePolicy_Validate(t *testing.T) {
+	assert.NoError(t, (&event.NamePolicy{Close: event.NAME_PROCEED}).Validate())
+	assert.Error(t, (&event.NamePolicy{None: "ignore"}).Validate())
+}
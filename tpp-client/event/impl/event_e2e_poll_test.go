@@ -25,16 +25,9 @@ func TestEventHandlerLifecyclePollEvents(t *testing.T) {
 
 	// 1. Create handlers & clients
 	sch := schedule.NewPaymentScheduler(testingCtx.l)
+	consentSch := schedule.NewConsentScheduler(testingCtx.l)
 
-	bankClient := bank_impl.NewNatwestSandboxClient(
-		30,
-		&bank.OauthClientCreds{
-			ClientId:       testingCtx.bankInfo.ClientId,
-			ClientSecret:   testingCtx.bankInfo.ClientSecret,
-			RedirectionUrl: testingCtx.bankInfo.RedirectUrl,
-		},
-		testingCtx.l,
-	)
+	bankClient := newBankClient()
 
 	handler := event_impl.NewEventHandler(
 		testingCtx.chainInfo.TppContractClient,
@@ -42,6 +35,7 @@ func TestEventHandlerLifecyclePollEvents(t *testing.T) {
 		bankClient,
 		test_util.Receiver(),
 		sch,
+		consentSch,
 		testingCtx.l)
 
 	// 2. task and schedule polling payments
@@ -129,7 +123,7 @@ func TestEventHandlerLifecyclePollEvents(t *testing.T) {
 	}
 	authGranted, err := bankClient.(*bank_impl.NatwestSandboxClient).ApproveConsent(
 		&authResp,
-		test_util.GetNatwestSandboxInfo().CustomerUsername)
+		testingCtx.bankInfo.CustomerUsername)
 	require.NoError(t, err)
 
 	// 5. Send an AuthGranted request
@@ -137,6 +131,8 @@ func TestEventHandlerLifecyclePollEvents(t *testing.T) {
 	authGrantedPayload := event.AuthGrantedPayload{
 		ConsentCode: authGranted.ConsentCode,
 		PublicKey:   base64.StdEncoding.EncodeToString([]byte(payerEncKey)),
+		State:       authGranted.State,
+		IdToken:     authGranted.IdToken,
 	}
 	data, err = json.Marshal(authGrantedPayload)
 	require.NoError(t, err)
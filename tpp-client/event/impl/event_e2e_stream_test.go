@@ -25,16 +25,9 @@ package event_impl_test
 
 // 	// 1. Create handlers & clients
 // 	sch := schedule.NewPaymentScheduler(testingCtx.l)
+// 	consentSch := schedule.NewConsentScheduler(testingCtx.l)
 
-// 	bankClient := bank_impl.NewNatwestSandboxClient(
-// 		30,
-// 		&bank.OauthClientCreds{
-// 			ClientId:       testingCtx.bankInfo.ClientId,
-// 			ClientSecret:   testingCtx.bankInfo.ClientSecret,
-// 			RedirectionUrl: testingCtx.bankInfo.RedirectUrl,
-// 		},
-// 		testingCtx.l,
-// 	)
+// 	bankClient := newBankClient()
 
 // 	handler := event_impl.NewEventHandler(
 // 		testingCtx.chainInfo.TppContractClient,
@@ -42,6 +35,7 @@ package event_impl_test
 // 		bankClient,
 // 		test_util.Receiver(),
 // 		sch,
+// 		consentSch,
 // 		testingCtx.l)
 
 // 	subscriber := event_impl.NewEventSubscriber(
@@ -137,7 +131,7 @@ package event_impl_test
 // 	}
 // 	authGranted, err := bankClient.(*bank_impl.NatwestSandboxClient).ApproveConsent(
 // 		&authResp,
-// 		test_util.GetNatwestSandboxInfo().CustomerUsername)
+// 		testingCtx.bankInfo.CustomerUsername)
 // 	require.NoError(t, err)
 
 // 	// 5. Send an AuthGranted request
@@ -145,6 +139,8 @@ package event_impl_test
 // 	authGrantedPayload := event.AuthGrantedPayload{
 // 		ConsentCode: authGranted.ConsentCode,
 // 		PublicKey:   base64.StdEncoding.EncodeToString([]byte(payerEncKey)),
+// 		State:       authGranted.State,
+// 		IdToken:     authGranted.IdToken,
 // 	}
 // 	data, err = json.Marshal(authGrantedPayload)
 // 	require.NoError(t, err)
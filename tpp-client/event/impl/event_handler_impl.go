@@ -1,16 +1,20 @@
 package event_impl
 
 import (
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
 	"github.com/sgerogia/sol-stablecoin/tpp-client/contract"
 	"github.com/sgerogia/sol-stablecoin/tpp-client/encrypt"
 	"github.com/sgerogia/sol-stablecoin/tpp-client/event"
 	"github.com/sgerogia/sol-stablecoin/tpp-client/schedule"
 	"go.uber.org/zap"
+	"sync"
+	"time"
 )
 
 // EventHandlerImpl non-persistent implementation the EventHandler interface.
@@ -21,13 +25,60 @@ type EventHandlerImpl struct {
 	bankClient  *bank.OpenBankingClient
 	beneficiary *bank.AccountDetails
 	scheduler   *schedule.PaymentStatusScheduler
+	consents    *schedule.ConsentStatusScheduler
+	options     HandlerOptions
 	l           *zap.SugaredLogger
-	cache       map[string]*ongoingRequest
+	// mu guards the cache, the status and payment of its requests, and the credited transactions.
+	// They are shared by the scheduled tasks and the redirect callback, each in its own goroutine.
+	mu    sync.RWMutex
+	cache map[string]*ongoingRequest
+	// credited are the transaction IDs of the credits matched to requests
+	credited map[string]string
 }
 
+// HandlerOptions are the optional steps of the payment flow
+type HandlerOptions struct {
+	// ConfirmFunds checks the payer's funds at the bank before submitting the payment
+	ConfirmFunds bool
+	// IdTokens verifies the ID tokens returned with the consent codes. Without it, only their nonce is checked,
+	// which is only good enough against sandboxes.
+	IdTokens *bank.IdTokenValidator
+	// AccountInfo if set, the payment's credit is looked up on the beneficiary account, as per CreditPolicy
+	AccountInfo  bank.AccountInformationClient
+	CreditPolicy event.CreditPolicy
+	// ModulusTable if set, the payers' sort codes and account numbers are modulus checked
+	ModulusTable *bank.ModulusTable
+	// ConsentApprover if set, approves every consent as ApproverUsername in the background, as soon as it is created.
+	// Sandboxes only, for testing full mint cycles without the bank's login.
+	ConsentApprover  bank.ConsentApprover
+	ApproverUsername string
+	// NameVerifier if set, the payers' account names are checked with their bank, and acted on as per NamePolicy
+	NameVerifier bank.NameVerificationClient
+	NamePolicy   event.NamePolicy
+}
+
+// BANK_UNAVAILABLE is the reason of the mint requests rejected while the bank's circuit breaker is open
+const BANK_UNAVAILABLE = "Bank unavailable. Please try again later"
+
+// CREDIT_LOOKBACK is subtracted from the submission time of a payment when looking up its credit,
+// to allow for clock differences with the bank
+const CREDIT_LOOKBACK = 1 * time.Hour
+
 type ongoingRequest struct {
+	RequestId          [32]byte
 	ConsentId          string
 	PaymentAuthRequest *bank.PaymentAuthRequest
+	Status             event.RequestStatus
+	// PublicKey is the payer's encryption key
+	PublicKey []byte
+	// Expiration of the mint request on-chain. Consents still open by then are expired.
+	Expiration time.Time
+	// State and Nonce of the authorisation request, expected back with the consent code
+	State string
+	Nonce string
+	// Identifiers of the payment, to reconcile the bank statements, and its submission time, to find its credit
+	Identifiers bank.PaymentIdentifiers
+	SubmittedAt time.Time
 }
 
 func NewEventHandler(
@@ -36,6 +87,21 @@ func NewEventHandler(
 	_bankClient bank.OpenBankingClient,
 	_beneficiary *bank.AccountDetails,
 	_scheduler schedule.PaymentStatusScheduler,
+	_consents schedule.ConsentStatusScheduler,
+	_l *zap.SugaredLogger) event.EventHandler {
+
+	return NewEventHandlerWithOptions(_contract, _keyPair, _bankClient, _beneficiary, _scheduler, _consents, HandlerOptions{}, _l)
+}
+
+// NewEventHandlerWithOptions returns a handler with the given optional steps enabled
+func NewEventHandlerWithOptions(
+	_contract *contract.ContractClient,
+	_keyPair *encrypt.KeyPair,
+	_bankClient bank.OpenBankingClient,
+	_beneficiary *bank.AccountDetails,
+	_scheduler schedule.PaymentStatusScheduler,
+	_consents schedule.ConsentStatusScheduler,
+	_options HandlerOptions,
 	_l *zap.SugaredLogger) event.EventHandler {
 
 	return &EventHandlerImpl{
@@ -44,8 +110,11 @@ func NewEventHandler(
 		bankClient:  &_bankClient,
 		beneficiary: _beneficiary,
 		scheduler:   &_scheduler,
+		consents:    &_consents,
+		options:     _options,
 		l:           _l,
 		cache:       make(map[string]*ongoingRequest),
+		credited:    make(map[string]string),
 	}
 }
 
@@ -79,70 +148,117 @@ func (h *EventHandlerImpl) ProcessMintRequest(request *contract.ProvableGBPMintR
 		"reqId", reqIdStr,
 		"payload", mintRequestPayload)
 
-	// --- OpenBanking call ---
-
-	token, err := (*h.bankClient).GetPaymentAuthAccessToken(reqIdStr)
+	// recover their base64 public key
+	publicKey, err := base64.StdEncoding.DecodeString(mintRequestPayload.PublicKey)
 	if err != nil {
-		return err
+		return errors.New("Error decoding their public key: " + err.Error())
 	}
 
+	// no point asking the bank for a consent to a payment from an invalid account
 	var pAuthReq = event.NewPaymentAuthRequest(request, &mintRequestPayload)
-	resp, err := (*h.bankClient).CreatePaymentAuthRequest(&pAuthReq, token, h.beneficiary)
-	if err != nil {
-		return err
-	}
-
-	authRequestPayload := event.AuthRequestPayload{
-		Url:       resp.Url,
-		ConsentId: resp.ConsentId,
+	if err = pAuthReq.Payer.Validate(h.options.ModulusTable); err != nil {
+		h.l.Warnw("Invalid payer account: "+err.Error(),
+			"reqId", reqIdStr)
+		return h.reject(request, &pAuthReq, publicKey, err.Error())
 	}
-	arJson, err := json.Marshal(authRequestPayload)
+	warning, err := h.checkPayerName(reqIdStr, &pAuthReq.Payer)
 	if err != nil {
-		return errors.New("Error marshalling AuthRequestPayload: " + err.Error())
+		return h.reject(request, &pAuthReq, publicKey, err.Error())
 	}
 
-	// --- Contract callback ---
+	// --- OpenBanking call ---
 
-	// recover their base64 public key
-	publicKey, err := base64.StdEncoding.DecodeString(mintRequestPayload.PublicKey)
-	if err != nil {
-		return errors.New("Error decoding their public key: " + err.Error())
+	token, err := (*h.bankClient).GetPaymentAuthAccessToken(reqIdStr)
+	if bank.IsUnavailable(err) {
+		return h.reject(request, &pAuthReq, publicKey, BANK_UNAVAILABLE)
 	}
-
-	// encrypt response with their key
-	authReqEncr, err := h.keyPair.Encrypt([]byte(arJson), (*[32]byte)(publicKey))
 	if err != nil {
-		return err
+		return h.bankFailure(reqIdStr, err)
 	}
 
-	authReqEncrJson, err := json.Marshal(authReqEncr)
-	if err != nil {
-		return errors.New("Error marshalling encryption structure for AuthRequest: " + err.Error())
+	resp, err := (*h.bankClient).CreatePaymentAuthRequest(&pAuthReq, token, h.beneficiary)
+	if bank.IsAuthExpired(err) {
+		// the token was revoked before its expiry. Try once more with a fresh one.
+		h.l.Warnw("Access token rejected. Retrying with a new one",
+			"reqId", reqIdStr)
+		if token, err = (*h.bankClient).GetPaymentAuthAccessToken(reqIdStr); err == nil {
+			resp, err = (*h.bankClient).CreatePaymentAuthRequest(&pAuthReq, token, h.beneficiary)
+		}
+	}
+	if bank.IsUnavailable(err) {
+		return h.reject(request, &pAuthReq, publicKey, BANK_UNAVAILABLE)
 	}
-
-	sess, err := h.contract.GetSingleUseSession()
 	if err != nil {
-		return err
+		return h.bankFailure(reqIdStr, err)
 	}
 
-	tx, err := sess.AuthRequest(request.RequestId, []byte(authReqEncrJson))
+	// --- Contract callback ---
+
+	tx, err := h.notifyPayer(request.RequestId, publicKey, &event.AuthRequestPayload{
+		Url:       resp.Url,
+		ConsentId: resp.ConsentId,
+		Warning:   warning,
+	})
 	if err != nil {
-		return errors.New("Error calling AuthRequest: " + err.Error())
+		return err
 	}
 
-	// add to cache
+	// add to cache and watch the consent until the payer acts on it
+	h.mu.Lock()
 	h.cache[reqIdStr] = &ongoingRequest{
+		RequestId:          request.RequestId,
 		ConsentId:          resp.ConsentId,
 		PaymentAuthRequest: &pAuthReq,
+		Status:             event.AWAITING_AUTHORISATION,
+		PublicKey:          publicKey,
+		Expiration:         time.Unix(request.Expiration.Int64(), 0),
+		State:              resp.State,
+		Nonce:              resp.Nonce,
+		Identifiers:        resp.Identifiers,
 	}
+	h.mu.Unlock()
+	(*h.consents).ScheduleConsent(resp)
 
 	h.l.Infow("MintRequest processed. AuthRequest call",
 		"reqId", reqIdStr,
-		"txHash", tx.Hash().Hex())
+		"txHash", tx.Hash().Hex(),
+		"endToEndId", resp.Identifiers.EndToEndId,
+		"interactionId", resp.InteractionId)
+
+	if h.options.ConsentApprover != nil {
+		// the approval goes through the bank's consent pages. Don't hold up the next events while it does.
+		go h.autoApprove(reqIdStr, resp, publicKey)
+	}
 
 	return nil
 }
 
+// autoApprove approves the consent on the payer's behalf and carries on as if their AuthGranted had arrived.
+// Runs in its own goroutine, racing the consent status task and the payer's own AuthGranted for the request.
+// Failures leave the request awaiting the payer's authorisation.
+func (h *EventHandlerImpl) autoApprove(reqIdStr string, authResp *bank.PaymentAuthResponse, publicKey []byte) {
+
+	granted, err := h.options.ConsentApprover.ApproveConsent(authResp, h.options.ApproverUsername)
+	if err != nil {
+		h.l.Warnw("Consent auto-approval failed: "+err.Error(),
+			"reqId", reqIdStr)
+		return
+	}
+	h.l.Infow("Consent auto-approved",
+		"reqId", reqIdStr,
+		"consentId", authResp.ConsentId)
+
+	if err = h.processAuthGranted(reqIdStr, &event.AuthGrantedPayload{
+		ConsentCode: granted.ConsentCode,
+		PublicKey:   base64.StdEncoding.EncodeToString(publicKey),
+		State:       granted.State,
+		IdToken:     granted.IdToken,
+	}); err != nil {
+		h.l.Warnw("Auto-approved consent not processed: "+err.Error(),
+			"reqId", reqIdStr)
+	}
+}
+
 func (h *EventHandlerImpl) ProcessAuthGranted(request *contract.ProvableGBPAuthGranted) error {
 
 	// --- unpack ETH event ---
@@ -168,25 +284,93 @@ func (h *EventHandlerImpl) ProcessAuthGranted(request *contract.ProvableGBPAuthG
 		return errors.New("Error unmarshalling AuthGranted payload: " + err.Error())
 	}
 
+	return h.processAuthGranted(reqIdStr, &authGrantedPayload)
+}
+
+// processAuthGranted submits the payment of a granted consent
+func (h *EventHandlerImpl) processAuthGranted(reqIdStr string, authGrantedPayload *event.AuthGrantedPayload) error {
+
 	// --- OpenBanking call ---
 
-	ongoingReq := h.cache[reqIdStr]
+	ongoingReq, status := h.lookup(reqIdStr)
 	if ongoingReq == nil {
 		return errors.New("No ongoing request found for requestId: " + reqIdStr)
 	}
+	if status != event.AWAITING_AUTHORISATION {
+		return errors.New("Request " + reqIdStr + " is " + string(status) + ", cannot submit payment")
+	}
+
+	if err := h.validateAuthResponse(ongoingReq, authGrantedPayload); err != nil {
+		// not finalising the request, so that a forged response cannot cancel it
+		h.l.Warnw("Invalid authorisation response",
+			"reqId", reqIdStr,
+			"error", err)
+		return err
+	}
+	// only one AuthGranted gets to use the consent; the consent check leaves the request alone meanwhile
+	if !h.claim(ongoingReq, event.AWAITING_AUTHORISATION, event.SUBMITTING) {
+		return errors.New("Request " + reqIdStr + " is already being submitted")
+	}
 
 	pAuthGranted := bank.PaymentAuthGranted{
 		RequestId:   reqIdStr,
 		ConsentId:   ongoingReq.ConsentId,
 		ConsentCode: authGrantedPayload.ConsentCode,
+		State:       authGrantedPayload.State,
+		IdToken:     authGrantedPayload.IdToken,
+		// the PSU's details are only known if they went through the callback server
+		CustomerIpAddress: authGrantedPayload.CustomerIpAddress,
+	}
+	if authGrantedPayload.AuthDate > 0 {
+		pAuthGranted.AuthDate = time.Unix(authGrantedPayload.AuthDate, 0)
 	}
+	if h.options.ConfirmFunds {
+		funds, err := (*h.bankClient).ConfirmFunds(&pAuthGranted)
+		if err != nil {
+			h.bankFailure(reqIdStr, err)
+			if bank.IsTerminal(err) {
+				(*h.consents).UnscheduleConsent(&bank.PaymentAuthResponse{RequestId: reqIdStr, ConsentId: ongoingReq.ConsentId})
+				(*h.bankClient).ForgetPayment(reqIdStr)
+				return h.finalise(reqIdStr, ongoingReq, event.FAILED, "Funds check refused by the bank")
+			}
+			h.setStatus(ongoingReq, event.AWAITING_AUTHORISATION)
+			return err
+		}
+		if !funds.FundsAvailable {
+			// no point submitting a payment the bank will reject
+			h.l.Warnw("Insufficient funds",
+				"reqId", reqIdStr)
+			(*h.consents).UnscheduleConsent(&bank.PaymentAuthResponse{RequestId: reqIdStr, ConsentId: ongoingReq.ConsentId})
+			(*h.bankClient).ForgetPayment(reqIdStr)
+			return h.finalise(reqIdStr, ongoingReq, event.FAILED, "Insufficient funds in the payer's account")
+		}
+	}
+
 	resp, err := (*h.bankClient).SubmitPayment(&pAuthGranted, ongoingReq.PaymentAuthRequest, h.beneficiary)
 	if err != nil {
+		h.bankFailure(reqIdStr, err)
+		if bank.IsTerminal(err) {
+			// the consent code cannot be used again, nothing more to do for this request
+			(*h.consents).UnscheduleConsent(&bank.PaymentAuthResponse{RequestId: reqIdStr, ConsentId: ongoingReq.ConsentId})
+			(*h.bankClient).ForgetPayment(reqIdStr)
+			return h.finalise(reqIdStr, ongoingReq, event.FAILED, "Payment refused by the bank")
+		}
+		h.setStatus(ongoingReq, event.AWAITING_AUTHORISATION)
 		return err
 	}
+	h.mu.Lock()
+	ongoingReq.Status = event.PAYMENT_SUBMITTED
+	ongoingReq.Identifiers = resp.Identifiers
+	ongoingReq.SubmittedAt = time.Now()
+	h.mu.Unlock()
+	(*h.consents).UnscheduleConsent(&bank.PaymentAuthResponse{RequestId: reqIdStr, ConsentId: ongoingReq.ConsentId})
 	h.l.Infow("Payment submitted",
 		"reqId", reqIdStr,
-		"paymentId", resp.PaymentId)
+		"paymentId", resp.PaymentId,
+		"instructionId", resp.Identifiers.InstructionId,
+		"endToEndId", resp.Identifiers.EndToEndId,
+		"reference", resp.Identifiers.Reference,
+		"interactionId", resp.InteractionId)
 
 	scheduled := (*h.scheduler).SchedulePayment(resp)
 	if !scheduled {
@@ -209,31 +393,383 @@ func (h *EventHandlerImpl) ProcessPaymentStatusResponse(request *bank.PaymentSta
 	h.l.Infow("Payment Status event",
 		"reqId", request.RequestId,
 		"paymentId", request.PaymentId,
-		"settled", request.Settled)
+		"status", request.Status)
+
+	if request.Status.IsTerminal() && !request.Status.IsSuccess() {
+		ongoingReq, _ := h.lookup(request.RequestId)
+		if ongoingReq == nil {
+			h.l.Warnw("Payment failed for unknown request",
+				"reqId", request.RequestId,
+				"paymentId", request.PaymentId,
+				"status", request.Status)
+			return true, nil
+		}
+		return true, h.finalise(request.RequestId, ongoingReq, event.FAILED, "Payment "+string(request.Status)+" by the bank")
+	}
 
-	if request.Settled {
-		sess, err := h.contract.GetSingleUseSession()
-		if err != nil {
-			return false, err
+	if !h.paymentReceived(request) {
+		return false, nil
+	}
+
+	sess, err := h.contract.GetSingleUseSession()
+	if err != nil {
+		return false, err
+	}
+
+	// go from hex back to bytes
+	var reqId [32]byte
+	tmp, err := hex.DecodeString(request.RequestId)
+	if err != nil {
+		return false, errors.New("Error converting requestId to bytes: " + err.Error())
+	}
+	copy(reqId[:], tmp)
+	tx, err := sess.PaymentComplete(reqId)
+	if err != nil {
+		return false, errors.New("Error calling PaymentComplete: " + err.Error())
+	}
+
+	var ids bank.PaymentIdentifiers
+	h.mu.Lock()
+	if ongoingReq := h.cache[request.RequestId]; ongoingReq != nil {
+		ongoingReq.Status = event.COMPLETED
+		ids = ongoingReq.Identifiers
+	}
+	h.mu.Unlock()
+
+	h.l.Infow("PaymentComplete call",
+		"reqId", request.RequestId,
+		"paymentId", request.PaymentId,
+		"txHash", tx.Hash().Hex(),
+		"endToEndId", ids.EndToEndId)
+
+	return true, nil
+}
+
+func (h *EventHandlerImpl) ProcessConsentStatusResponse(request *bank.PaymentConsentStatusResponse) (bool, error) {
+
+	h.l.Infow("Consent Status event",
+		"reqId", request.RequestId,
+		"consent", request.ConsentId,
+		"status", request.Status)
+
+	ongoingReq, status := h.lookup(request.RequestId)
+	if status == event.SUBMITTING {
+		// the payment is being submitted, check again in case it fails
+		return false, nil
+	}
+	if ongoingReq == nil || status != event.AWAITING_AUTHORISATION {
+		// unknown or already moved on, nothing to watch
+		return true, nil
+	}
+
+	switch request.Status {
+	case bank.CONSENT_REJECTED:
+		if !h.claim(ongoingReq, event.AWAITING_AUTHORISATION, event.REJECTED) {
+			return false, nil
+		}
+		return true, h.finalise(request.RequestId, ongoingReq, event.REJECTED, "Consent rejected at the bank")
+	case bank.CONSENT_EXPIRED:
+		if !h.claim(ongoingReq, event.AWAITING_AUTHORISATION, event.EXPIRED) {
+			return false, nil
 		}
+		return true, h.finalise(request.RequestId, ongoingReq, event.EXPIRED, "Consent expired at the bank")
+	case bank.CONSENT_CONSUMED:
+		// the payment has been submitted
+		return true, nil
+	}
 
-		// go from hex back to bytes
-		var reqId [32]byte
-		tmp, err := hex.DecodeString(request.RequestId)
-		if err != nil {
-			return false, errors.New("Error converting requestId to bytes: " + err.Error())
+	// awaiting authorisation, or authorised but no AuthGranted yet
+	if time.Now().After(ongoingReq.Expiration) {
+		// the contract refuses AuthRequest calls for expired requests, so there is no payer to notify
+		if !h.claim(ongoingReq, event.AWAITING_AUTHORISATION, event.EXPIRED) {
+			return false, nil
 		}
-		copy(reqId[:], tmp)
-		tx, err := sess.PaymentComplete(reqId)
-		if err != nil {
-			return false, errors.New("Error calling PaymentComplete: " + err.Error())
+		h.l.Infow("Request expired before the payment was authorised",
+			"reqId", request.RequestId)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (h *EventHandlerImpl) FindAuthorisation(state string) (*event.PendingAuthorisation, bool) {
+
+	if state == "" {
+		return nil, false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for reqIdStr, req := range h.cache {
+		if req.Status != event.AWAITING_AUTHORISATION {
+			continue
 		}
+		if subtle.ConstantTimeCompare([]byte(req.State), []byte(state)) == 1 {
+			return &event.PendingAuthorisation{
+				RequestId: reqIdStr,
+				ConsentId: req.ConsentId,
+				State:     req.State,
+				Nonce:     req.Nonce,
+				PublicKey: req.PublicKey,
+			}, true
+		}
+	}
+	return nil, false
+}
+
+// lookup returns the ongoing request and its current status, or nil if unknown
+func (h *EventHandlerImpl) lookup(reqIdStr string) (*ongoingRequest, event.RequestStatus) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	req := h.cache[reqIdStr]
+	if req == nil {
+		return nil, ""
+	}
+	return req, req.Status
+}
+
+// setStatus moves the request to a new status
+func (h *EventHandlerImpl) setStatus(req *ongoingRequest, status event.RequestStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	req.Status = status
+}
+
+// claim moves the request from one status to another, unless another task has moved it already
+func (h *EventHandlerImpl) claim(req *ongoingRequest, from event.RequestStatus, to event.RequestStatus) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if req.Status != from {
+		return false
+	}
+	req.Status = to
+	return true
+}
+
+// paymentReceived decides whether the payment has reached us, from its status and, if enabled, the credits of the
+// beneficiary account
+func (h *EventHandlerImpl) paymentReceived(request *bank.PaymentStatusResponse) bool {
 
-		h.l.Infow("PaymentComplete call",
+	settled := request.Status.IsSuccess()
+	if h.options.AccountInfo == nil {
+		return settled
+	}
+	either := h.options.CreditPolicy == event.CREDIT_EITHER
+	if settled == either {
+		// settled under `either`, or not settled under `both`: no need to look
+		return settled
+	}
+
+	credited, err := h.findCredit(request.RequestId)
+	if err != nil {
+		// check again in the next cycle
+		h.l.Warnw("Unable to look up the payment's credit",
 			"reqId", request.RequestId,
 			"paymentId", request.PaymentId,
-			"txHash", tx.Hash().Hex())
+			"error", err)
+	}
+	return credited
+}
+
+// findCredit looks for the payment's credit on the beneficiary account.
+// Each credit is matched to a single request, in case of identical payments.
+func (h *EventHandlerImpl) findCredit(reqIdStr string) (bool, error) {
+
+	h.mu.RLock()
+	ongoingReq := h.cache[reqIdStr]
+	var submittedAt time.Time
+	var endToEndId string
+	if ongoingReq != nil {
+		submittedAt = ongoingReq.SubmittedAt
+		endToEndId = ongoingReq.Identifiers.EndToEndId
+	}
+	h.mu.RUnlock()
+	if submittedAt.IsZero() {
+		return false, errors.New("No submitted payment found for requestId: " + reqIdStr)
+	}
+	credits, err := h.options.AccountInfo.GetAccountCredits(h.beneficiary, submittedAt.Add(-CREDIT_LOOKBACK))
+	if err != nil {
+		return false, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, c := range bank.MatchingCredits(credits, ongoingReq.PaymentAuthRequest.Amount, endToEndId) {
+		if owner, ok := h.credited[c.TransactionId]; ok && owner != reqIdStr {
+			continue
+		}
+		h.credited[c.TransactionId] = reqIdStr
+		h.l.Infow("Payment credited",
+			"reqId", reqIdStr,
+			"transactionId", c.TransactionId)
+		return true, nil
 	}
+	return false, nil
+}
 
-	return request.Settled, nil
+// validateAuthResponse checks that the consent code comes from the authorisation request we made,
+// i.e. the state matches and the ID token, issued by the bank for this code and state, carries our nonce
+func (h *EventHandlerImpl) validateAuthResponse(req *ongoingRequest, payload *event.AuthGrantedPayload) error {
+
+	if subtle.ConstantTimeCompare([]byte(req.State), []byte(payload.State)) != 1 {
+		return errors.New("State mismatch in authorisation response")
+	}
+	if req.Nonce == "" {
+		return nil
+	}
+	if payload.IdToken == "" {
+		return errors.New("Missing ID token in authorisation response")
+	}
+	if h.options.IdTokens != nil {
+		_, err := h.options.IdTokens.Validate(payload.IdToken, &bank.IdTokenExpectations{
+			Nonce: req.Nonce,
+			Code:  payload.ConsentCode,
+			State: payload.State,
+		})
+		return err
+	}
+	claims, err := bank.ParseJwtClaims(payload.IdToken)
+	if err != nil {
+		return errors.New("Invalid ID token: " + err.Error())
+	}
+	nonce, _ := claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(req.Nonce), []byte(nonce)) != 1 {
+		return errors.New("Nonce mismatch in ID token")
+	}
+	return nil
+}
+
+// finalise moves a request to a terminal state and lets the payer know, re-using the contract's `AuthRequest` method
+func (h *EventHandlerImpl) finalise(reqIdStr string, req *ongoingRequest, status event.RequestStatus, reason string) error {
+
+	h.setStatus(req, status)
+	h.l.Infow("Request finalised",
+		"reqId", reqIdStr,
+		"status", status,
+		"reason", reason)
+
+	tx, err := h.notifyPayer(req.RequestId, req.PublicKey, &event.AuthRequestPayload{
+		ConsentId: req.ConsentId,
+		Status:    status,
+		Reason:    reason,
+	})
+	if err != nil {
+		return err
+	}
+
+	h.l.Infow("Payer notified. AuthRequest call",
+		"reqId", reqIdStr,
+		"txHash", tx.Hash().Hex())
+	return nil
+}
+
+// reject fails a mint request before any consent is created, notifying the payer
+func (h *EventHandlerImpl) reject(
+	request *contract.ProvableGBPMintRequest,
+	pAuthReq *bank.PaymentAuthRequest,
+	publicKey []byte,
+	reason string) error {
+
+	failed := &ongoingRequest{
+		RequestId:          request.RequestId,
+		PaymentAuthRequest: pAuthReq,
+		PublicKey:          publicKey,
+		Expiration:         time.Unix(request.Expiration.Int64(), 0),
+	}
+	h.mu.Lock()
+	h.cache[pAuthReq.RequestId] = failed
+	h.mu.Unlock()
+	return h.finalise(pAuthReq.RequestId, failed, event.FAILED, reason)
+}
+
+// checkPayerName checks the payer's account name with their bank and applies the name policy.
+// It returns a warning for the payer, or an error if the request is to be rejected.
+func (h *EventHandlerImpl) checkPayerName(reqIdStr string, payer *bank.AccountDetails) (string, error) {
+
+	if h.options.NameVerifier == nil {
+		return "", nil
+	}
+	result, err := h.options.NameVerifier.VerifyName(payer)
+	if err != nil {
+		h.l.Warnw("Name verification failed: "+err.Error(),
+			"reqId", reqIdStr)
+		result = &bank.NameVerificationResult{Match: bank.NAME_UNAVAILABLE}
+	}
+	action := h.options.NamePolicy.Action(result.Match)
+	h.l.Infow("Payer name checked",
+		"reqId", reqIdStr,
+		"match", result.Match,
+		"reasonCode", result.ReasonCode,
+		"action", action)
+
+	var message string
+	switch result.Match {
+	case bank.NAME_EXACT:
+		return "", nil
+	case bank.NAME_CLOSE:
+		message = "The account name is a close match to the bank's records"
+		if result.AccountName != "" {
+			message += ": " + result.AccountName
+		}
+	case bank.NAME_NONE:
+		message = "The account name does not match the bank's records"
+	default:
+		message = "The account name could not be checked with the bank"
+	}
+	switch action {
+	case event.NAME_REJECT:
+		return "", errors.New(message)
+	case event.NAME_WARN:
+		return message, nil
+	default:
+		return "", nil
+	}
+}
+
+// notifyPayer encrypts the payload with the payer's public key and calls the contract's `AuthRequest` method
+func (h *EventHandlerImpl) notifyPayer(requestId [32]byte, publicKey []byte, payload *event.AuthRequestPayload) (*types.Transaction, error) {
+
+	arJson, err := json.Marshal(payload)
+	if err != nil {
+		return nil, errors.New("Error marshalling AuthRequestPayload: " + err.Error())
+	}
+
+	// encrypt response with their key
+	authReqEncr, err := h.keyPair.Encrypt([]byte(arJson), (*[32]byte)(publicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	authReqEncrJson, err := json.Marshal(authReqEncr)
+	if err != nil {
+		return nil, errors.New("Error marshalling encryption structure for AuthRequest: " + err.Error())
+	}
+
+	sess, err := h.contract.GetSingleUseSession()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := sess.AuthRequest(requestId, []byte(authReqEncrJson))
+	if err != nil {
+		return nil, errors.New("Error calling AuthRequest: " + err.Error())
+	}
+	return tx, nil
+}
+
+// bankFailure logs a failed bank call according to its class and returns the error
+func (h *EventHandlerImpl) bankFailure(reqIdStr string, err error) error {
+	class, _ := bank.ClassOf(err)
+	if class == bank.TERMINAL {
+		h.l.Errorw("Bank call failed permanently",
+			"reqId", reqIdStr,
+			"class", class,
+			"error", err)
+	} else {
+		h.l.Warnw("Bank call failed",
+			"reqId", reqIdStr,
+			"class", class,
+			"error", err)
+	}
+	return err
 }
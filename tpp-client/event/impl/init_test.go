@@ -1,6 +1,9 @@
 package event_impl_test
 
 import (
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	bank_mock "github.com/sgerogia/sol-stablecoin/tpp-client/bank/mock"
 	test_util "github.com/sgerogia/sol-stablecoin/tpp-client/util"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest/observer"
@@ -11,6 +14,7 @@ import (
 type TestingInfo struct {
 	chainInfo *test_util.ChainInfo
 	bankInfo  *test_util.NatwestSandboxInfo
+	aspsp     *bank_mock.AspspServer
 	l         *zap.SugaredLogger
 	o         *observer.ObservedLogs
 }
@@ -26,9 +30,11 @@ func TestMain(m *testing.M) {
 		os.Exit(-1)
 	}
 	testingCtx.chainInfo = chain
+	// use the Natwest sandbox if configured, otherwise a local mock ASPSP
 	testingCtx.bankInfo = test_util.GetNatwestSandboxInfo()
 	if testingCtx.bankInfo == nil {
-		os.Exit(-1)
+		testingCtx.aspsp = bank_mock.NewAspspServer()
+		testingCtx.bankInfo = test_util.MockAspspInfo()
 	}
 
 	// logger and log observer
@@ -40,7 +46,24 @@ func TestMain(m *testing.M) {
 	exitVal := m.Run()
 
 	// clean up here
+	if testingCtx.aspsp != nil {
+		testingCtx.aspsp.Close()
+	}
 
 	// ...and exit test suite
 	os.Exit(exitVal)
 }
+
+// newBankClient returns a client for the Natwest sandbox or the mock ASPSP, whichever is in use
+func newBankClient() bank.OpenBankingClient {
+	creds := bank.OauthClientCreds{
+		ClientId:        testingCtx.bankInfo.ClientId,
+		ClientSecret:    testingCtx.bankInfo.ClientSecret,
+		RedirectionUrl:  testingCtx.bankInfo.RedirectUrl,
+		ReducedSecurity: true,
+	}
+	if testingCtx.aspsp != nil {
+		return bank_impl.NewNatwestClient(30, testingCtx.aspsp.Endpoints(), &creds, testingCtx.l)
+	}
+	return bank_impl.NewNatwestSandboxClient(30, &creds, testingCtx.l)
+}
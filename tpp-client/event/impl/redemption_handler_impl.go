@@ -0,0 +1,526 @@
+package event_impl
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/contract"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/encrypt"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/event"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/schedule"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"sync"
+)
+
+// MIN_PAYOUT of a redemption, in GBP. Burns are paid out in whole pence, rounded down.
+var MIN_PAYOUT = decimal.New(1, -2)
+
+// MAX_PAYOUT_ATTEMPTS of a redemption which reach the bank, before the redemption fails
+const MAX_PAYOUT_ATTEMPTS = 5
+
+// MAX_AWAITING_BURN is the number of instructions kept until their burn arrives, to bound the memory of bogus ones
+const MAX_AWAITING_BURN = 1000
+
+// RedemptionHandlerImpl implementation of the RedemptionHandler interface.
+// Uses an in-memory map to track the ongoing redemptions, by burn transaction, and a store for the ones whose payout
+// has been submitted.
+type RedemptionHandlerImpl struct {
+	keyPair          *encrypt.KeyPair
+	bankClient       *bank.OpenBankingClient
+	approver         bank.ConsentApprover
+	approverUsername string
+	account          *bank.AccountDetails
+	scheduler        *schedule.PaymentStatusScheduler
+	modulus          *bank.ModulusTable
+	store            *event.RedemptionStore
+	l                *zap.SugaredLogger
+	// mu guards the redemptions against the instructions of the HTTP server
+	mu          sync.Mutex
+	redemptions map[string]*ongoingRedemption
+}
+
+type ongoingRedemption struct {
+	BurnTxHash string
+	Status     event.RequestStatus
+	// instructions by signer, until the burn arrives and tells which one is the holder's
+	instructions map[common.Address]*event.RedemptionPayload
+	// Holder, Amount (GBP) and log index of the burn
+	Holder   common.Address
+	Amount   string
+	LogIndex uint
+	Payee    *bank.AccountDetails
+	// consent and its grant, kept so that a failed payout is retried from the step which failed
+	consent *bank.PaymentAuthResponse
+	granted *bank.PaymentAuthGranted
+	// PaymentId and Identifiers of the payout, once submitted
+	PaymentId   string
+	Identifiers bank.PaymentIdentifiers
+	Reason      string
+	attempts    int
+	// paying is true while a payout is in flight
+	paying bool
+}
+
+// NewRedemptionHandler returns a handler paying the redemptions out of `account`.
+// The payout consents are authorised by `approver` as `approverUsername`, the account's owner.
+// The redemptions are kept in `store` from their payout's submission, so that their burns are not redeemed again
+// after a restart. The payouts submitted before a restart are scheduled for their status checks.
+func NewRedemptionHandler(
+	_keyPair *encrypt.KeyPair,
+	_bankClient bank.OpenBankingClient,
+	_approver bank.ConsentApprover,
+	_approverUsername string,
+	_account *bank.AccountDetails,
+	_scheduler schedule.PaymentStatusScheduler,
+	_modulus *bank.ModulusTable,
+	_store event.RedemptionStore,
+	_l *zap.SugaredLogger) event.RedemptionHandler {
+
+	h := &RedemptionHandlerImpl{
+		keyPair:          _keyPair,
+		bankClient:       &_bankClient,
+		approver:         _approver,
+		approverUsername: _approverUsername,
+		account:          _account,
+		scheduler:        &_scheduler,
+		modulus:          _modulus,
+		store:            &_store,
+		l:                _l,
+		redemptions:      make(map[string]*ongoingRedemption),
+	}
+	for _, info := range _store.Unfinished() {
+		reqIdStr, _ := event.RedemptionId(info.BurnTxHash)
+		if info.Status == event.PAYMENT_SUBMITTED {
+			_scheduler.SchedulePayment(&bank.SubmitPaymentResponse{RequestId: reqIdStr, PaymentId: info.PaymentId})
+			continue
+		}
+		// neither retried, as the grant is lost, nor refunded, as the payment may have been made
+		_l.Errorw("Payout interrupted by a restart. Need to investigate!",
+			"reqId", reqIdStr,
+			"consent", info.ConsentId,
+			"holder", info.Holder,
+			"amount", info.Amount)
+	}
+	return h
+}
+
+func (h *RedemptionHandlerImpl) SubmitRedemption(encryptedData []byte) (*event.RedemptionInfo, error) {
+
+	// encrypted data
+	var box encrypt.EthSigUtilBox
+	if err := json.Unmarshal(encryptedData, &box); err != nil {
+		return nil, errors.New("Error unmarshalling encr. data: " + err.Error())
+	}
+	// decrypt
+	decr, err := h.keyPair.Decrypt(&box)
+	if err != nil {
+		return nil, errors.New("Error decrypting encr. data: " + err.Error())
+	}
+	var payload event.RedemptionPayload
+	if err = json.Unmarshal(decr, &payload); err != nil {
+		return nil, errors.New("Error unmarshalling RedemptionPayload: " + err.Error())
+	}
+
+	reqIdStr, err := event.RedemptionId(payload.BurnTxHash)
+	if err != nil {
+		return nil, err
+	}
+	// no point waiting for the burn to find out the payout cannot be made
+	payee := payload.Payee()
+	if err = payee.Validate(h.modulus); err != nil {
+		return nil, errors.New("Invalid payee account: " + err.Error())
+	}
+	signer, err := payload.Signer()
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r := h.redemptions[reqIdStr]
+	if r == nil {
+		if stored, ok := (*h.store).Find(payload.BurnTxHash); ok {
+			return nil, errors.New("Redemption is " + string(stored.Status) + ", its instruction cannot change")
+		}
+		if h.awaitingBurn() >= MAX_AWAITING_BURN {
+			return nil, errors.New("Too many redemptions awaiting their burn. Please try again later")
+		}
+		r = &ongoingRedemption{
+			BurnTxHash: "0x" + reqIdStr,
+			Status:     event.AWAITING_BURN,
+		}
+		h.redemptions[reqIdStr] = r
+	}
+
+	switch r.Status {
+	case event.AWAITING_BURN:
+		// the signer is checked against the holder once the burn arrives. A later instruction replaces theirs.
+		if r.instructions == nil {
+			r.instructions = make(map[common.Address]*event.RedemptionPayload)
+		}
+		r.instructions[signer] = &payload
+	case event.AWAITING_INSTRUCTION:
+		if signer != r.Holder {
+			return nil, errors.New("Instruction not signed by the holder of the burnt tokens")
+		}
+		r.Payee = &payee
+		r.Status = event.PAYOUT_PENDING
+	default:
+		return nil, errors.New("Redemption is " + string(r.Status) + ", its instruction cannot change")
+	}
+
+	h.l.Infow("Redemption instruction",
+		"reqId", reqIdStr,
+		"signer", signer.Hex(),
+		"status", r.Status)
+
+	return r.info(), nil
+}
+
+func (h *RedemptionHandlerImpl) ProcessBurn(burn *contract.ProvableGBPTransfer) error {
+
+	reqIdStr := hex.EncodeToString(burn.Raw.TxHash[:])
+
+	h.l.Infow("Burn event",
+		"reqId", reqIdStr,
+		"holder", burn.From.Hex(),
+		"value", burn.Value.String())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r := h.redemptions[reqIdStr]
+	if r == nil {
+		if stored, ok := (*h.store).Find(burn.Raw.TxHash.Hex()); ok {
+			// finalised in a previous run, e.g. re-read from an earlier starting block
+			h.l.Infow("Burn already redeemed",
+				"reqId", reqIdStr,
+				"status", stored.Status)
+			return nil
+		}
+		r = &ongoingRedemption{
+			BurnTxHash: "0x" + reqIdStr,
+			Status:     event.AWAITING_BURN,
+		}
+		h.redemptions[reqIdStr] = r
+	}
+	if r.Status != event.AWAITING_BURN {
+		if burn.Raw.Index != r.LogIndex {
+			h.l.Warnw("Multiple burns in a transaction. Only the first is redeemed",
+				"reqId", reqIdStr,
+				"logIndex", burn.Raw.Index)
+		}
+		return nil
+	}
+
+	amount := decimal.NewFromBigInt(burn.Value, -event.DECIMAL_DIGITS).Truncate(2)
+	r.Holder = burn.From
+	r.Amount = amount.StringFixed(2)
+	r.LogIndex = burn.Raw.Index
+	instruction := r.instructions[burn.From]
+	r.instructions = nil
+
+	switch {
+	case amount.LessThan(MIN_PAYOUT):
+		h.fail(reqIdStr, r, "Amount below the minimum payout of "+MIN_PAYOUT.StringFixed(2))
+	case instruction == nil:
+		r.Status = event.AWAITING_INSTRUCTION
+	default:
+		payee := instruction.Payee()
+		r.Payee = &payee
+		r.Status = event.PAYOUT_PENDING
+	}
+	return nil
+}
+
+func (h *RedemptionHandlerImpl) ProcessPayouts() {
+
+	// claim the payouts, so that a concurrent call does not make them again
+	h.mu.Lock()
+	claimed := make(map[string]*ongoingRedemption)
+	for reqIdStr, r := range h.redemptions {
+		// submissions are retried while their grant is kept
+		if !r.paying && (r.Status == event.PAYOUT_PENDING || (r.Status == event.SUBMITTING && r.granted != nil)) {
+			r.paying = true
+			claimed[reqIdStr] = r
+		}
+	}
+	h.mu.Unlock()
+
+	for reqIdStr, r := range claimed {
+		h.payout(reqIdStr, r)
+	}
+}
+
+// payout pays the redemption out of our account. Failed payouts are retried in the next cycle, unless terminal.
+// Once submitted, the payout only fails if the bank has not used its consent, as the response may have been lost.
+func (h *RedemptionHandlerImpl) payout(reqIdStr string, r *ongoingRedemption) {
+
+	authReq := &bank.PaymentAuthRequest{
+		RequestId: reqIdStr,
+		Amount:    r.Amount,
+		Payer:     *h.account,
+	}
+	resp, err := h.submitPayout(reqIdStr, authReq, r)
+	if err == nil {
+		h.submitted(reqIdStr, r, resp)
+		return
+	}
+
+	class, _ := bank.ClassOf(err)
+	h.mu.Lock()
+	if class != bank.UNAVAILABLE && class != bank.RATE_LIMITED {
+		r.attempts++
+	}
+	attempts := r.attempts
+	submitting := r.Status == event.SUBMITTING
+	retry := !bank.IsTerminal(err) && attempts < MAX_PAYOUT_ATTEMPTS
+	if retry {
+		r.paying = false
+	}
+	h.mu.Unlock()
+
+	if retry {
+		h.l.Warnw("Payout failed. Retrying in the next cycle",
+			"reqId", reqIdStr,
+			"class", class,
+			"error", err)
+		return
+	}
+	h.l.Errorw("Payout failed permanently",
+		"reqId", reqIdStr,
+		"class", class,
+		"attempts", attempts,
+		"error", err)
+	paid := submitting && h.consentUsed(reqIdStr, r)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r.paying = false
+	if paid {
+		// neither retried nor refunded
+		r.granted = nil
+		h.l.Errorw("Payout outcome unknown. The payment may have been made. Need to investigate!",
+			"reqId", reqIdStr,
+			"consent", r.consent.ConsentId,
+			"amount", r.Amount)
+		return
+	}
+	h.fail(reqIdStr, r, "Payout rejected by the bank")
+}
+
+// submitPayout makes the payout's consent, approves it as our account's owner and submits the payment.
+// The steps done in a previous cycle are not repeated.
+func (h *RedemptionHandlerImpl) submitPayout(
+	reqIdStr string,
+	authReq *bank.PaymentAuthRequest,
+	r *ongoingRedemption) (*bank.SubmitPaymentResponse, error) {
+
+	if r.consent == nil {
+		token, err := (*h.bankClient).GetPaymentAuthAccessToken(reqIdStr)
+		if err != nil {
+			return nil, err
+		}
+		consent, err := (*h.bankClient).CreatePaymentAuthRequest(authReq, token, r.Payee)
+		if err != nil {
+			return nil, err
+		}
+		h.mu.Lock()
+		r.consent = consent
+		h.mu.Unlock()
+	}
+	if r.granted == nil {
+		granted, err := h.approver.ApproveConsent(r.consent, h.approverUsername)
+		if err != nil {
+			return nil, err
+		}
+		h.mu.Lock()
+		r.granted = granted
+		h.mu.Unlock()
+	}
+
+	// stored before the payment is made, so that a restart does not make it again
+	h.mu.Lock()
+	status := r.Status
+	r.Status = event.SUBMITTING
+	err := h.save(reqIdStr, r)
+	if err != nil {
+		r.Status = status
+	}
+	h.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	// retries reuse the grant and idempotency key, so the bank returns the payment if it has already been made
+	return (*h.bankClient).SubmitPayment(r.granted, authReq, r.Payee)
+}
+
+// submitted records the payout's payment and schedules its status checks
+func (h *RedemptionHandlerImpl) submitted(reqIdStr string, r *ongoingRedemption, resp *bank.SubmitPaymentResponse) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r.paying = false
+	r.Status = event.PAYMENT_SUBMITTED
+	r.PaymentId = resp.PaymentId
+	r.Identifiers = resp.Identifiers
+	h.save(reqIdStr, r)
+	h.l.Infow("Payout submitted",
+		"reqId", reqIdStr,
+		"paymentId", resp.PaymentId,
+		"amount", r.Amount,
+		"endToEndId", resp.Identifiers.EndToEndId,
+		"interactionId", resp.InteractionId)
+
+	if !(*h.scheduler).SchedulePayment(resp) {
+		h.l.Errorw("Duplicate payout. Need to investigate!",
+			"reqId", reqIdStr,
+			"paymentId", resp.PaymentId)
+	}
+}
+
+// consentUsed returns true if the bank has used the payout's consent, i.e. made its payment, or if that is unknown
+func (h *RedemptionHandlerImpl) consentUsed(reqIdStr string, r *ongoingRedemption) bool {
+
+	token, err := (*h.bankClient).GetPaymentAuthAccessToken(reqIdStr)
+	if err == nil {
+		var status *bank.PaymentConsentStatusResponse
+		if status, err = (*h.bankClient).GetPaymentConsentStatus(r.consent, token); err == nil {
+			return status.Status == bank.CONSENT_CONSUMED
+		}
+	}
+	h.l.Warnw("Unable to check the payout's consent",
+		"reqId", reqIdStr,
+		"error", err)
+	return true
+}
+
+func (h *RedemptionHandlerImpl) ProcessPaymentStatusResponse(request *bank.PaymentStatusResponse) (bool, error) {
+
+	h.l.Infow("Payout Status event",
+		"reqId", request.RequestId,
+		"paymentId", request.PaymentId,
+		"status", request.Status)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r := h.redemptions[request.RequestId]
+	if r == nil {
+		// submitted before a restart
+		r = h.stored(request.RequestId)
+	}
+	if r == nil || r.Status != event.PAYMENT_SUBMITTED {
+		h.l.Warnw("Payout status of unknown redemption",
+			"reqId", request.RequestId,
+			"paymentId", request.PaymentId)
+		return true, nil
+	}
+
+	switch {
+	case request.Status.IsSuccess():
+		r.Status = event.COMPLETED
+		h.l.Infow("Redemption paid out",
+			"reqId", request.RequestId,
+			"paymentId", request.PaymentId,
+			"amount", r.Amount,
+			"endToEndId", r.Identifiers.EndToEndId)
+		h.save(request.RequestId, r)
+		return true, nil
+	case request.Status.IsTerminal():
+		h.fail(request.RequestId, r, "Payout "+string(request.Status)+" by the bank")
+		return true, nil
+	}
+	return false, nil
+}
+
+func (h *RedemptionHandlerImpl) FindRedemption(burnTxHash string) (*event.RedemptionInfo, bool) {
+
+	reqIdStr, err := event.RedemptionId(burnTxHash)
+	if err != nil {
+		return nil, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r := h.redemptions[reqIdStr]
+	if r == nil {
+		return (*h.store).Find(burnTxHash)
+	}
+	return r.info(), true
+}
+
+// fail finalises a redemption without a payout. The tokens are burnt, so the holder is owed their amount.
+// The redemption is stored, to be listed among the refunds.
+func (h *RedemptionHandlerImpl) fail(reqIdStr string, r *ongoingRedemption, reason string) {
+	r.Status = event.FAILED
+	r.Reason = reason
+	h.l.Errorw("Redemption failed. The holder must be refunded",
+		"reqId", reqIdStr,
+		"holder", r.Holder.Hex(),
+		"amount", r.Amount,
+		"reason", reason)
+	h.save(reqIdStr, r)
+}
+
+// save stores the redemption. If that fails for a finalised one, the log is the only record left.
+func (h *RedemptionHandlerImpl) save(reqIdStr string, r *ongoingRedemption) error {
+	info := r.info()
+	err := (*h.store).Save(info)
+	if err != nil {
+		h.l.Errorw("Unable to store the redemption. Need to investigate!",
+			"reqId", reqIdStr,
+			"redemption", info,
+			"error", err)
+	}
+	return err
+}
+
+// stored returns the redemption of a payout submitted before a restart, from the store. It is not kept in memory,
+// as the store has all there is to know about it.
+func (h *RedemptionHandlerImpl) stored(reqIdStr string) *ongoingRedemption {
+	info, ok := (*h.store).Find("0x" + reqIdStr)
+	if !ok {
+		return nil
+	}
+	return &ongoingRedemption{
+		BurnTxHash:  info.BurnTxHash,
+		Status:      info.Status,
+		Holder:      common.HexToAddress(info.Holder),
+		Amount:      info.Amount,
+		consent:     &bank.PaymentAuthResponse{RequestId: reqIdStr, ConsentId: info.ConsentId},
+		PaymentId:   info.PaymentId,
+		Identifiers: bank.PaymentIdentifiers{EndToEndId: info.EndToEndId},
+	}
+}
+
+// awaitingBurn returns the number of instructions awaiting their burn
+func (h *RedemptionHandlerImpl) awaitingBurn() int {
+	n := 0
+	for _, r := range h.redemptions {
+		n += len(r.instructions)
+	}
+	return n
+}
+
+func (r *ongoingRedemption) info() *event.RedemptionInfo {
+	info := &event.RedemptionInfo{
+		BurnTxHash: r.BurnTxHash,
+		Status:     r.Status,
+		Amount:     r.Amount,
+		PaymentId:  r.PaymentId,
+		EndToEndId: r.Identifiers.EndToEndId,
+		Reason:     r.Reason,
+	}
+	if r.consent != nil {
+		info.ConsentId = r.consent.ConsentId
+	}
+	if r.Status != event.AWAITING_BURN {
+		info.Holder = r.Holder.Hex()
+	}
+	return info
+}
@@ -0,0 +1,455 @@
+package event_impl_test
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	bank_impl "github.com/sgerogia/sol-stablecoin/tpp-client/bank/impl"
+	bank_mock "github.com/sgerogia/sol-stablecoin/tpp-client/bank/mock"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/contract"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/event"
+	event_impl "github.com/sgerogia/sol-stablecoin/tpp-client/event/impl"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/schedule"
+	test_util "github.com/sgerogia/sol-stablecoin/tpp-client/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"math/big"
+	"net/http"
+	f "path/filepath"
+	"testing"
+)
+
+type redemptionFixture struct {
+	chain      *test_util.ChainInfo
+	bankClient bank.OpenBankingClient
+	storeFile  string
+	aspsp      *bank_mock.AspspServer
+	handler    event.RedemptionHandler
+	burnTask   schedule.BurnEventTask
+	payouts    schedule.PaymentStatusTask
+	scheduler  schedule.PaymentStatusScheduler
+}
+
+// newRedemptionFixture deploys its own contract, so that the burns do not interfere with the mint tests
+func newRedemptionFixture(t *testing.T) *redemptionFixture {
+	chain, err := test_util.DeployProvableGBPAndCreateAccounts()
+	require.NoError(t, err)
+	aspsp := bank_mock.NewAspspServer()
+	t.Cleanup(aspsp.Close)
+
+	l := zap.NewNop().Sugar()
+	info := test_util.MockAspspInfo()
+	bankClient := bank_impl.NewNatwestClient(30, aspsp.Endpoints(), &bank.OauthClientCreds{
+		ClientId:        info.ClientId,
+		ClientSecret:    info.ClientSecret,
+		RedirectionUrl:  info.RedirectUrl,
+		ReducedSecurity: true,
+	}, l)
+	// retried by the handler, in its next cycle
+	bankClient.(*bank_impl.NatwestSandboxClient).SetRetryPolicy(&bank_impl.RetryPolicy{MaxAttempts: 1})
+	storeFile := f.Join(t.TempDir(), "redemptions.jsonl")
+	store, err := event_impl.NewFileRedemptionStore(storeFile)
+	require.NoError(t, err)
+	sch := schedule.NewPaymentScheduler(l)
+	handler := event_impl.NewRedemptionHandler(
+		chain.TppKeyPair,
+		bankClient,
+		bankClient.(*bank_impl.NatwestSandboxClient),
+		info.CustomerUsername,
+		test_util.Receiver(),
+		sch,
+		nil,
+		store,
+		l)
+
+	return &redemptionFixture{
+		chain:      chain,
+		bankClient: bankClient,
+		storeFile:  storeFile,
+		aspsp:      aspsp,
+		handler:    handler,
+		burnTask:   schedule.NewBurnEventTask(0, chain.TppContractClient, handler, l),
+		payouts:    schedule.NewPaymentStatusTask(sch, bankClient, handler, l),
+		scheduler:  sch,
+	}
+}
+
+// mintAndBurn mints `amount` to the payer, bypassing the bank, then burns `burnt` of it
+func (f *redemptionFixture) mintAndBurn(t *testing.T, amount string, burnt string) *types.Transaction {
+
+	sess, err := f.chain.PayerContractClient.GetSingleUseSession()
+	require.NoError(t, err)
+	_, err = sess.MintRequest(event.ToWei(amount, event.DECIMAL_DIGITS), []byte("{}"))
+	require.NoError(t, err)
+	f.chain.Backend.Commit()
+
+	// the latest mint request is ours
+	requests, err := f.chain.TppContractClient.GetEventFilterer().FilterMintRequest(nil, nil, nil)
+	require.NoError(t, err)
+	var requestId [32]byte
+	for requests.Next() {
+		requestId = requests.Event.RequestId
+	}
+	sess, err = f.chain.TppContractClient.GetSingleUseSession()
+	require.NoError(t, err)
+	_, err = sess.PaymentComplete(requestId)
+	require.NoError(t, err)
+	f.chain.Backend.Commit()
+
+	sess, err = f.chain.PayerContractClient.GetSingleUseSession()
+	require.NoError(t, err)
+	tx, err := sess.Burn(event.ToWei(burnt, event.DECIMAL_DIGITS))
+	require.NoError(t, err)
+	f.chain.Backend.Commit()
+	return tx
+}
+
+// instruction returns the payout instruction of a burn, signed with `key` and encrypted for the TPP
+func (f *redemptionFixture) instruction(t *testing.T, burnTxHash common.Hash, key *ecdsa.PrivateKey) []byte {
+
+	payee := test_util.Payer()
+	payload := event.RedemptionPayload{
+		BurnTxHash:    burnTxHash.Hex(),
+		SortCode:      payee.SortCode,
+		AccountNumber: payee.AccountNumber,
+		Name:          payee.Name,
+	}
+	sig, err := crypto.Sign(accounts.TextHash([]byte(payload.Message())), key)
+	require.NoError(t, err)
+	payload.Signature = hexutil.Encode(sig)
+
+	data, err := json.Marshal(payload)
+	require.NoError(t, err)
+	box, err := f.chain.PayerKeyPair.Encrypt(data, (*[32]byte)(f.chain.TppKeyPair.PublicEncrKeyBytes()[:]))
+	require.NoError(t, err)
+	encrData, err := json.Marshal(box)
+	require.NoError(t, err)
+	return encrData
+}
+
+func (f *redemptionFixture) status(t *testing.T, burnTxHash common.Hash) *event.RedemptionInfo {
+	info, ok := f.handler.FindRedemption(burnTxHash.Hex())
+	require.True(t, ok)
+	return info
+}
+
+func TestRedemptionHandler_BurnThenInstruction(t *testing.T) {
+
+	// arrange
+	f := newRedemptionFixture(t)
+	burn := f.mintAndBurn(t, "10", "2.505")
+	holder := crypto.PubkeyToAddress(f.chain.PayerKeyPair.PrivateKey.PublicKey)
+
+	// act & assert
+	// 1. the burn awaits its instruction
+	f.burnTask.FetchAndProcessBurns()
+	info := f.status(t, burn.Hash())
+	assert.Equal(t, event.AWAITING_INSTRUCTION, info.Status)
+	assert.Equal(t, "2.50", info.Amount)
+	assert.Equal(t, holder.Hex(), info.Holder)
+
+	// 2. the holder's instruction makes it payable
+	info, err := f.handler.SubmitRedemption(f.instruction(t, burn.Hash(), f.chain.PayerKeyPair.PrivateKey))
+	require.NoError(t, err)
+	assert.Equal(t, event.PAYOUT_PENDING, info.Status)
+
+	// 3. paid out in the next cycle, once only
+	f.burnTask.FetchAndProcessBurns()
+	f.burnTask.FetchAndProcessBurns()
+	info = f.status(t, burn.Hash())
+	assert.Equal(t, event.PAYMENT_SUBMITTED, info.Status)
+	assert.NotEmpty(t, info.PaymentId)
+	assert.NotEmpty(t, info.EndToEndId)
+	assert.Equal(t, 1, f.aspsp.CallCount(bank_mock.PAYMENT))
+	assert.Len(t, f.scheduler.GetScheduledPayments(), 1)
+
+	// 4. tracked to completion: pending, then completed
+	f.payouts.CheckPaymentStatuses()
+	assert.Equal(t, event.PAYMENT_SUBMITTED, f.status(t, burn.Hash()).Status)
+	f.payouts.CheckPaymentStatuses()
+	assert.Equal(t, event.COMPLETED, f.status(t, burn.Hash()).Status)
+	assert.Empty(t, f.scheduler.GetScheduledPayments())
+}
+
+func TestRedemptionHandler_InstructionThenBurn(t *testing.T) {
+
+	// arrange
+	f := newRedemptionFixture(t)
+	burn := f.mintAndBurn(t, "10", "1")
+	other, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	// act: an instruction by someone else, then the holder's, before the burn is seen
+	info, err := f.handler.SubmitRedemption(f.instruction(t, burn.Hash(), other))
+	require.NoError(t, err)
+	assert.Equal(t, event.AWAITING_BURN, info.Status)
+	assert.Empty(t, info.Holder)
+	_, err = f.handler.SubmitRedemption(f.instruction(t, burn.Hash(), f.chain.PayerKeyPair.PrivateKey))
+	require.NoError(t, err)
+	f.burnTask.FetchAndProcessBurns()
+
+	// assert: the holder's instruction is paid out
+	info = f.status(t, burn.Hash())
+	assert.Equal(t, event.PAYMENT_SUBMITTED, info.Status)
+	assert.Equal(t, "1.00", info.Amount)
+	assert.Equal(t, 1, f.aspsp.CallCount(bank_mock.PAYMENT))
+	// ...and cannot be changed any more
+	_, err = f.handler.SubmitRedemption(f.instruction(t, burn.Hash(), f.chain.PayerKeyPair.PrivateKey))
+	assert.ErrorContains(t, err, "cannot change")
+}
+
+func TestRedemptionHandler_InstructionNotSignedByHolder(t *testing.T) {
+
+	// arrange
+	f := newRedemptionFixture(t)
+	burn := f.mintAndBurn(t, "10", "1")
+	f.burnTask.FetchAndProcessBurns()
+	other, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	// act
+	_, err = f.handler.SubmitRedemption(f.instruction(t, burn.Hash(), other))
+
+	// assert
+	assert.ErrorContains(t, err, "not signed by the holder")
+	assert.Equal(t, event.AWAITING_INSTRUCTION, f.status(t, burn.Hash()).Status)
+	f.burnTask.FetchAndProcessBurns()
+	assert.Equal(t, 0, f.aspsp.CallCount(bank_mock.PAYMENT))
+}
+
+func TestRedemptionHandler_PayoutRetriedThenFailed(t *testing.T) {
+
+	// arrange
+	f := newRedemptionFixture(t)
+	f.aspsp.SetBehaviour(bank_mock.CONSENT, bank_mock.Behaviour{
+		FailStatus: 500,
+		FailTimes:  1,
+	})
+	burn := f.mintAndBurn(t, "10", "1")
+	f.burnTask.FetchAndProcessBurns()
+	_, err := f.handler.SubmitRedemption(f.instruction(t, burn.Hash(), f.chain.PayerKeyPair.PrivateKey))
+	require.NoError(t, err)
+
+	// act: a retryable failure, then a successful payout rejected at settlement
+	f.burnTask.FetchAndProcessBurns()
+	assert.Equal(t, event.PAYOUT_PENDING, f.status(t, burn.Hash()).Status)
+	f.aspsp.SetPaymentStatuses(bank.PAYMENT_REJECTED)
+	f.burnTask.FetchAndProcessBurns()
+	f.payouts.CheckPaymentStatuses()
+
+	// assert
+	info := f.status(t, burn.Hash())
+	assert.Equal(t, event.FAILED, info.Status)
+	assert.Equal(t, "Payout Rejected by the bank", info.Reason)
+	assert.Equal(t, 1, f.aspsp.CallCount(bank_mock.PAYMENT))
+}
+
+func TestRedemptionHandler_ProcessBurn(t *testing.T) {
+
+	// arrange
+	f := newRedemptionFixture(t)
+	txHash := common.HexToHash("0x01")
+	burn := func(wei int64, index uint) *contract.ProvableGBPTransfer {
+		return &contract.ProvableGBPTransfer{
+			From:  common.HexToAddress("0x02"),
+			Value: big.NewInt(wei),
+			Raw:   types.Log{TxHash: txHash, Index: index},
+		}
+	}
+
+	// act
+	require.NoError(t, f.handler.ProcessBurn(burn(9_999_999_999_999_999, 3)))
+	require.NoError(t, f.handler.ProcessBurn(burn(9_999_999_999_999_999, 3)))
+	require.NoError(t, f.handler.ProcessBurn(burn(5_000_000_000_000_000_000, 4)))
+
+	// assert: below a penny, the first burn fails and the others are ignored
+	info := f.status(t, txHash)
+	assert.Equal(t, event.FAILED, info.Status)
+	assert.Equal(t, "0.00", info.Amount)
+	assert.Contains(t, info.Reason, "minimum payout")
+	_, ok := f.handler.FindRedemption("0x1234")
+	assert.False(t, ok)
+}
+
+func TestRedemptionHandler_FinalisedSurviveRestart(t *testing.T) {
+
+	// arrange: a paid out and a failed redemption
+	fx := newRedemptionFixture(t)
+	paid := fx.mintAndBurn(t, "10", "1")
+	failed := fx.mintAndBurn(t, "10", "0.001")
+	fx.burnTask.FetchAndProcessBurns()
+	_, err := fx.handler.SubmitRedemption(fx.instruction(t, paid.Hash(), fx.chain.PayerKeyPair.PrivateKey))
+	require.NoError(t, err)
+	fx.burnTask.FetchAndProcessBurns()
+	fx.payouts.CheckPaymentStatuses()
+	fx.payouts.CheckPaymentStatuses()
+	require.Equal(t, event.COMPLETED, fx.status(t, paid.Hash()).Status)
+	require.Equal(t, event.FAILED, fx.status(t, failed.Hash()).Status)
+
+	// act: restart, re-reading the burns from the first block
+	store, err := event_impl.NewFileRedemptionStore(fx.storeFile)
+	require.NoError(t, err)
+	l := zap.NewNop().Sugar()
+	sch := schedule.NewPaymentScheduler(l)
+	handler := event_impl.NewRedemptionHandler(
+		fx.chain.TppKeyPair,
+		fx.bankClient,
+		nil,
+		"",
+		test_util.Receiver(),
+		sch,
+		nil,
+		store,
+		l)
+	schedule.NewBurnEventTask(0, fx.chain.TppContractClient, handler, l).FetchAndProcessBurns()
+
+	// assert: both are known, neither is redeemed again, and the failed one is owed a refund
+	info, ok := handler.FindRedemption(paid.Hash().Hex())
+	require.True(t, ok)
+	assert.Equal(t, event.COMPLETED, info.Status)
+	info, ok = handler.FindRedemption(failed.Hash().Hex())
+	require.True(t, ok)
+	assert.Equal(t, event.FAILED, info.Status)
+	_, err = handler.SubmitRedemption(fx.instruction(t, paid.Hash(), fx.chain.PayerKeyPair.PrivateKey))
+	assert.ErrorContains(t, err, "cannot change")
+	assert.Empty(t, sch.GetScheduledPayments())
+	assert.Equal(t, 1, fx.aspsp.CallCount(bank_mock.PAYMENT))
+
+	refunds := store.Refunds()
+	require.Len(t, refunds, 1)
+	assert.Equal(t, failed.Hash().Hex(), refunds[0].BurnTxHash)
+	assert.Equal(t, "0.00", refunds[0].Amount)
+	assert.Contains(t, refunds[0].Reason, "minimum payout")
+}
+
+func TestRedemptionHandler_LostPayoutResponseSubmittedOnce(t *testing.T) {
+
+	// arrange
+	f := newRedemptionFixture(t)
+	f.aspsp.SetBehaviour(bank_mock.PAYMENT, bank_mock.Behaviour{
+		FailStatus:   http.StatusGatewayTimeout,
+		FailTimes:    1,
+		LoseResponse: true,
+	})
+	burn := f.mintAndBurn(t, "10", "1")
+	f.burnTask.FetchAndProcessBurns()
+	_, err := f.handler.SubmitRedemption(f.instruction(t, burn.Hash(), f.chain.PayerKeyPair.PrivateKey))
+	require.NoError(t, err)
+
+	// act & assert
+	// 1. the payment is made, but its response lost. It is stored as being submitted.
+	f.burnTask.FetchAndProcessBurns()
+	assert.Equal(t, event.SUBMITTING, f.status(t, burn.Hash()).Status)
+	store, err := event_impl.NewFileRedemptionStore(f.storeFile)
+	require.NoError(t, err)
+	stored, ok := store.Find(burn.Hash().Hex())
+	require.True(t, ok)
+	assert.Equal(t, event.SUBMITTING, stored.Status)
+	assert.NotEmpty(t, stored.ConsentId)
+	approvals := f.aspsp.CallCount(bank_mock.AUTHORIZE)
+
+	// 2. the retry resubmits with the same grant, and gets the payment already made
+	f.burnTask.FetchAndProcessBurns()
+	info := f.status(t, burn.Hash())
+	assert.Equal(t, event.PAYMENT_SUBMITTED, info.Status)
+	assert.NotEmpty(t, info.PaymentId)
+	assert.Equal(t, 1, f.aspsp.PaymentCount())
+	assert.Equal(t, 1, f.aspsp.CallCount(bank_mock.CONSENT))
+	assert.Equal(t, approvals, f.aspsp.CallCount(bank_mock.AUTHORIZE))
+}
+
+func TestRedemptionHandler_FailedSubmissionChecksConsent(t *testing.T) {
+	cases := []struct {
+		name       string
+		behaviour  bank_mock.Behaviour
+		expStatus  event.RequestStatus
+		expRefunds int
+	}{
+		{
+			name:       "refused",
+			behaviour:  bank_mock.Behaviour{FailStatus: http.StatusBadRequest, FailTimes: -1},
+			expStatus:  event.FAILED,
+			expRefunds: 1,
+		},
+		{
+			name:       "made, responses lost",
+			behaviour:  bank_mock.Behaviour{FailStatus: http.StatusGatewayTimeout, FailTimes: -1, LoseResponse: true},
+			expStatus:  event.SUBMITTING,
+			expRefunds: 0,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			f := newRedemptionFixture(t)
+			f.aspsp.SetBehaviour(bank_mock.PAYMENT, c.behaviour)
+			burn := f.mintAndBurn(t, "10", "1")
+			f.burnTask.FetchAndProcessBurns()
+			_, err := f.handler.SubmitRedemption(f.instruction(t, burn.Hash(), f.chain.PayerKeyPair.PrivateKey))
+			require.NoError(t, err)
+
+			// act
+			for i := 0; i <= event_impl.MAX_PAYOUT_ATTEMPTS; i++ {
+				f.burnTask.FetchAndProcessBurns()
+			}
+
+			// assert: failed only if the bank has not used the consent, and given up on either way
+			assert.Equal(t, c.expStatus, f.status(t, burn.Hash()).Status)
+			assert.LessOrEqual(t, f.aspsp.CallCount(bank_mock.PAYMENT), event_impl.MAX_PAYOUT_ATTEMPTS)
+			assert.Equal(t, 1, f.aspsp.CallCount(bank_mock.CONSENT_STATUS))
+			store, err := event_impl.NewFileRedemptionStore(f.storeFile)
+			require.NoError(t, err)
+			assert.Len(t, store.Refunds(), c.expRefunds)
+		})
+	}
+}
+
+func TestRedemptionHandler_SubmittedSurvivesRestart(t *testing.T) {
+
+	// arrange: a payout submitted, but not settled
+	fx := newRedemptionFixture(t)
+	burn := fx.mintAndBurn(t, "10", "1")
+	fx.burnTask.FetchAndProcessBurns()
+	_, err := fx.handler.SubmitRedemption(fx.instruction(t, burn.Hash(), fx.chain.PayerKeyPair.PrivateKey))
+	require.NoError(t, err)
+	fx.burnTask.FetchAndProcessBurns()
+	require.Equal(t, event.PAYMENT_SUBMITTED, fx.status(t, burn.Hash()).Status)
+
+	// act: restart, re-reading the burns from the first block
+	store, err := event_impl.NewFileRedemptionStore(fx.storeFile)
+	require.NoError(t, err)
+	l := zap.NewNop().Sugar()
+	sch := schedule.NewPaymentScheduler(l)
+	handler := event_impl.NewRedemptionHandler(
+		fx.chain.TppKeyPair,
+		fx.bankClient,
+		fx.bankClient.(*bank_impl.NatwestSandboxClient),
+		test_util.MockAspspInfo().CustomerUsername,
+		test_util.Receiver(),
+		sch,
+		nil,
+		store,
+		l)
+	burnTask := schedule.NewBurnEventTask(0, fx.chain.TppContractClient, handler, l)
+	burnTask.FetchAndProcessBurns()
+	burnTask.FetchAndProcessBurns()
+	_, err = handler.SubmitRedemption(fx.instruction(t, burn.Hash(), fx.chain.PayerKeyPair.PrivateKey))
+
+	// assert: not paid again, and tracked to completion
+	assert.ErrorContains(t, err, "cannot change")
+	assert.Equal(t, 1, fx.aspsp.PaymentCount())
+	require.Len(t, sch.GetScheduledPayments(), 1)
+	payouts := schedule.NewPaymentStatusTask(sch, fx.bankClient, handler, l)
+	payouts.CheckPaymentStatuses()
+	payouts.CheckPaymentStatuses()
+	info, ok := handler.FindRedemption(burn.Hash().Hex())
+	require.True(t, ok)
+	assert.Equal(t, event.COMPLETED, info.Status)
+	assert.Empty(t, sch.GetScheduledPayments())
+}
@@ -0,0 +1,137 @@
+package event_impl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/event"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// FileRedemptionStore persistent implementation of the RedemptionStore interface.
+// Appends the redemptions to a file as JSON lines, and reloads them on start-up. A later line replaces an earlier one.
+type FileRedemptionStore struct {
+	mu          sync.Mutex
+	path        string
+	redemptions map[string]*event.RedemptionInfo
+}
+
+// NewFileRedemptionStore returns a store backed by the file at `_path`, created if it does not exist
+func NewFileRedemptionStore(_path string) (event.RedemptionStore, error) {
+
+	s := &FileRedemptionStore{
+		path:        _path,
+		redemptions: make(map[string]*event.RedemptionInfo),
+	}
+	f, err := os.Open(_path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var info event.RedemptionInfo
+		if err = json.Unmarshal(scanner.Bytes(), &info); err != nil {
+			return nil, errors.New("Invalid redemption in " + _path + " at line " + strconv.Itoa(line) + ": " + err.Error())
+		}
+		id, err := event.RedemptionId(info.BurnTxHash)
+		if err != nil {
+			return nil, errors.New("Invalid redemption in " + _path + " at line " + strconv.Itoa(line) + ": " + err.Error())
+		}
+		s.redemptions[id] = &info
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileRedemptionStore) Save(info *event.RedemptionInfo) error {
+
+	id, err := event.RedemptionId(info.BurnTxHash)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(data, '\n')); err == nil {
+		// the redemption is only kept once it is on disk
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	stored := *info
+	s.redemptions[id] = &stored
+	return nil
+}
+
+func (s *FileRedemptionStore) Find(burnTxHash string) (*event.RedemptionInfo, bool) {
+
+	id, err := event.RedemptionId(burnTxHash)
+	if err != nil {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, ok := s.redemptions[id]
+	if !ok {
+		return nil, false
+	}
+	found := *info
+	return &found, true
+}
+
+func (s *FileRedemptionStore) Refunds() []*event.RedemptionInfo {
+	return s.list(func(info *event.RedemptionInfo) bool {
+		return info.Status == event.FAILED
+	})
+}
+
+func (s *FileRedemptionStore) Unfinished() []*event.RedemptionInfo {
+	return s.list(func(info *event.RedemptionInfo) bool {
+		return info.Status == event.SUBMITTING || info.Status == event.PAYMENT_SUBMITTED
+	})
+}
+
+// list returns copies of the matching redemptions, by burn transaction hash
+func (s *FileRedemptionStore) list(match func(info *event.RedemptionInfo) bool) []*event.RedemptionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res []*event.RedemptionInfo
+	for _, info := range s.redemptions {
+		if match(info) {
+			found := *info
+			res = append(res, &found)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].BurnTxHash < res[j].BurnTxHash
+	})
+	return res
+}
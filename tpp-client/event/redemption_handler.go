@@ -0,0 +1,150 @@
+package event
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/contract"
+	"regexp"
+	"strings"
+)
+
+// PaymentStatusHandler processes the status responses of the payments checked by a `PaymentStatusTask`
+type PaymentStatusHandler interface {
+
+	// ProcessPaymentStatusResponse returns `true` once the payment needs no more checking
+	ProcessPaymentStatusResponse(request *bank.PaymentStatusResponse) (bool, error)
+}
+
+// RedemptionHandler pays out the GBP of burnt tokens to their holders' bank accounts.
+// A redemption is a holder's burn, i.e. a `Transfer` to the zero address, and their payout instruction.
+// They may arrive in any order; the payout is made once both have.
+type RedemptionHandler interface {
+
+	// SubmitRedemption accepts a holder's payout instruction, i.e. the JSON of an encrypted `RedemptionPayload`.
+	// The instruction must be signed by the holder of the burnt tokens.
+	SubmitRedemption(encryptedData []byte) (*RedemptionInfo, error)
+
+	// ProcessBurn processes a `Transfer` event to the zero address.
+	// Only the first burn of a transaction is redeemed.
+	ProcessBurn(burn *contract.ProvableGBPTransfer) error
+
+	// ProcessPayouts makes the payouts of the redemptions with both their burn and instruction, including the ones
+	// whose bank calls failed before, e.g. while the bank was unavailable.
+	// The payouts are made from the TPP's account and their status is then checked by a `PaymentStatusTask`.
+	ProcessPayouts()
+
+	// ProcessPaymentStatusResponse called by the scheduler with the status of a payout.
+	// It returns `true` once the payout is settled or rejected, i.e. the redemption is completed or failed.
+	ProcessPaymentStatusResponse(request *bank.PaymentStatusResponse) (bool, error)
+
+	// FindRedemption returns the redemption of a burn transaction, including the stored ones of previous runs,
+	// or `false` if neither its burn nor an instruction have arrived
+	FindRedemption(burnTxHash string) (*RedemptionInfo, bool)
+}
+
+// RedemptionStore keeps the redemptions across restarts, from their payout's submission until they are finalised,
+// i.e. paid out or failed. The failed ones are the holders owed a refund, as their tokens are burnt.
+type RedemptionStore interface {
+
+	// Save records a redemption, replacing its earlier record
+	Save(info *RedemptionInfo) error
+
+	// Find returns the stored redemption of a burn transaction, or `false` if there is none
+	Find(burnTxHash string) (*RedemptionInfo, bool)
+
+	// Refunds returns the failed redemptions, by burn transaction hash
+	Refunds() []*RedemptionInfo
+
+	// Unfinished returns the redemptions whose payout was submitted, or being submitted, but not finalised,
+	// by burn transaction hash
+	Unfinished() []*RedemptionInfo
+}
+
+// RedemptionPayload is the holder's payout instruction, encrypted with the TPP's public key as in the mint requests
+type RedemptionPayload struct {
+	// BurnTxHash of the holder's burn, 0x-prefixed hex
+	BurnTxHash    string `json:"burnTxHash"`
+	SortCode      string `json:"sortCode"`
+	AccountNumber string `json:"accountNumber"`
+	// Iban identifies the account instead of the sort code and account number, if set
+	Iban string `json:"iban,omitempty"`
+	// SecondaryIdentification, e.g. a building society roll number
+	SecondaryIdentification string `json:"secondaryIdentification,omitempty"`
+	Name                    string `json:"name"`
+	// Signature of `Message()` by the holder, as returned by `personal_sign`, in hex
+	Signature string `json:"signature"`
+}
+
+// RedemptionInfo is the status of a redemption, as shown to the holder
+type RedemptionInfo struct {
+	BurnTxHash string        `json:"burnTxHash"`
+	Status     RequestStatus `json:"status"`
+	// Holder and Amount (GBP) of the burn, once it has arrived
+	Holder string `json:"holder,omitempty"`
+	Amount string `json:"amount,omitempty"`
+	// ConsentId of the payout, once created
+	ConsentId string `json:"consentId,omitempty"`
+	// PaymentId and EndToEndId of the payout, once submitted
+	PaymentId  string `json:"paymentId,omitempty"`
+	EndToEndId string `json:"endToEndId,omitempty"`
+	// Reason of a failed redemption
+	Reason string `json:"reason,omitempty"`
+}
+
+var txHashPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{64}$`)
+
+// RedemptionId returns the ID of a burn transaction's redemption, i.e. its hash in lowercase hex without prefix.
+// The ID is used as the request ID of the payout.
+func RedemptionId(burnTxHash string) (string, error) {
+	if !txHashPattern.MatchString(burnTxHash) {
+		return "", errors.New("Invalid burn transaction hash: " + burnTxHash)
+	}
+	return strings.ToLower(burnTxHash[2:]), nil
+}
+
+// Payee returns the account to pay the redemption to
+func (p *RedemptionPayload) Payee() bank.AccountDetails {
+	return bank.AccountDetails{
+		Name:                    p.Name,
+		SortCode:                p.SortCode,
+		AccountNumber:           p.AccountNumber,
+		Iban:                    p.Iban,
+		SecondaryIdentification: p.SecondaryIdentification,
+	}
+}
+
+// Message returns the text the holder signs, binding the burn to the payee's account
+func (p *RedemptionPayload) Message() string {
+	account := "Sort code: " + p.SortCode + "\nAccount number: " + p.AccountNumber
+	if p.Iban != "" {
+		account = "IBAN: " + p.Iban
+	}
+	if p.SecondaryIdentification != "" {
+		account += "\nSecondary identification: " + p.SecondaryIdentification
+	}
+	return "Redeem ProvableGBP\n" +
+		"Burn: " + strings.ToLower(p.BurnTxHash) + "\n" +
+		"Payee: " + p.Name + "\n" +
+		account
+}
+
+// Signer recovers the address which signed the instruction
+func (p *RedemptionPayload) Signer() (common.Address, error) {
+	sig, err := hex.DecodeString(strings.TrimPrefix(p.Signature, "0x"))
+	if err != nil || len(sig) != crypto.SignatureLength {
+		return common.Address{}, errors.New("Invalid instruction signature")
+	}
+	// wallets return the recovery ID as 27/28
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	pub, err := crypto.SigToPub(accounts.TextHash([]byte(p.Message())), sig)
+	if err != nil {
+		return common.Address{}, errors.New("Invalid instruction signature: " + err.Error())
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
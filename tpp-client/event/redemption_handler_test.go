@@ -0,0 +1,112 @@
+package event_test
+
+import (
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+const BURN_TX_HASH = "0x8d3c5b8a4e6f3f0d1c2b3a4958675a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f"
+
+func newRedemptionPayload() *event.RedemptionPayload {
+	return &event.RedemptionPayload{
+		BurnTxHash:    BURN_TX_HASH,
+		SortCode:      "500000",
+		AccountNumber: "12345601",
+		Name:          "John Doe",
+	}
+}
+
+func TestRedemptionPayload_Signer(t *testing.T) {
+
+	// arrange
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	holder := crypto.PubkeyToAddress(key.PublicKey)
+	payload := newRedemptionPayload()
+	// as returned by personal_sign, i.e. a recovery ID of 27/28
+	sig, err := crypto.Sign(accounts.TextHash([]byte(payload.Message())), key)
+	require.NoError(t, err)
+	sig[crypto.RecoveryIDOffset] += 27
+	payload.Signature = hexutil.Encode(sig)
+
+	// act
+	signer, err := payload.Signer()
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, holder, signer)
+
+	// ...another payee's account does not recover the holder
+	payload.AccountNumber = "87654301"
+	signer, err = payload.Signer()
+	require.NoError(t, err)
+	assert.NotEqual(t, holder, signer)
+}
+
+func TestRedemptionPayload_InvalidSignature(t *testing.T) {
+	for _, sig := range []string{"", "0x1234", "not hex", "0x" + strings.Repeat("00", 65)} {
+		t.Run(sig, func(t *testing.T) {
+			// arrange
+			payload := newRedemptionPayload()
+			payload.Signature = sig
+
+			// act
+			_, err := payload.Signer()
+
+			// assert
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRedemptionPayload_MessageBindsAccount(t *testing.T) {
+
+	// arrange
+	sortCode := newRedemptionPayload()
+	iban := newRedemptionPayload()
+	iban.Iban = "GB29NWBK60161331926819"
+	rollNumber := newRedemptionPayload()
+	rollNumber.SecondaryIdentification = "ROLL-1"
+
+	// act & assert
+	assert.Contains(t, sortCode.Message(), "Burn: "+BURN_TX_HASH)
+	assert.Contains(t, sortCode.Message(), "Sort code: 500000\nAccount number: 12345601")
+	assert.Contains(t, iban.Message(), "IBAN: GB29NWBK60161331926819")
+	assert.NotContains(t, iban.Message(), "Sort code")
+	assert.Contains(t, rollNumber.Message(), "Secondary identification: ROLL-1")
+}
+
+func TestRedemptionId(t *testing.T) {
+	cases := []struct {
+		hash  string
+		expId string
+		valid bool
+	}{
+		{hash: BURN_TX_HASH, expId: BURN_TX_HASH[2:], valid: true},
+		{hash: strings.ToUpper(BURN_TX_HASH[:2]) + strings.ToUpper(BURN_TX_HASH[2:]), valid: false},
+		{hash: "0x" + strings.ToUpper(BURN_TX_HASH[2:]), expId: BURN_TX_HASH[2:], valid: true},
+		{hash: BURN_TX_HASH[2:], valid: false},
+		{hash: BURN_TX_HASH[:40], valid: false},
+		{hash: "0x" + strings.Repeat("g", 64), valid: false},
+	}
+	for _, c := range cases {
+		t.Run(c.hash, func(t *testing.T) {
+			// act
+			id, err := event.RedemptionId(c.hash)
+
+			// assert
+			if c.valid {
+				require.NoError(t, err)
+				assert.Equal(t, c.expId, id)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package schedule
+
+import (
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/contract"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/event"
+	"go.uber.org/zap"
+)
+
+type BurnEventTask interface {
+	FetchAndProcessBurns()
+}
+
+type BurnEventTaskImpl struct {
+	startingBlock  uint64
+	contractClient *contract.ContractClient
+	handler        *event.RedemptionHandler
+	l              *zap.SugaredLogger
+}
+
+func NewBurnEventTask(
+	_startFromBlock uint64,
+	_contractClient *contract.ContractClient,
+	_handler event.RedemptionHandler,
+	_l *zap.SugaredLogger) BurnEventTask {
+
+	return &BurnEventTaskImpl{
+		startingBlock:  _startFromBlock,
+		contractClient: _contractClient,
+		handler:        &_handler,
+		l:              _l,
+	}
+}
+
+/**
+ * Fetches and processes the burns, i.e. Transfer events to the zero address, then makes the payouts which are ready.
+ */
+func (t *BurnEventTaskImpl) FetchAndProcessBurns() {
+
+	filterOpts := bind.FilterOpts{
+		Start: t.startingBlock,
+		End:   nil,
+	}
+
+	events, err := (*t.contractClient).GetEventFilterer().FilterTransfer(&filterOpts, nil, []common.Address{{}})
+	if err != nil {
+		t.l.Errorw("Error fetching burn events: " + err.Error())
+	} else {
+		var latestBlock uint64 = 0
+		for events.Next() {
+			burn := events.Event
+			// the handler ignores burns it has already seen, e.g. in the starting block
+			if err := (*t.handler).ProcessBurn(burn); err != nil {
+				t.l.Errorw("Error processing burn event: "+err.Error(),
+					"txHash", burn.Raw.TxHash.Hex())
+			}
+			latestBlock = burn.Raw.BlockNumber
+		}
+		if latestBlock > t.startingBlock {
+			t.startingBlock = latestBlock + 1
+		}
+	}
+
+	(*t.handler).ProcessPayouts()
+}
@@ -0,0 +1,74 @@
+package schedule
+
+import (
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"go.uber.org/zap"
+	"sync"
+)
+
+type ConsentStatusScheduler interface {
+	ScheduleConsent(consent *bank.PaymentAuthResponse) bool
+	GetScheduledConsents() []*bank.PaymentAuthResponse
+	UnscheduleConsent(consent *bank.PaymentAuthResponse) bool
+}
+
+type ConsentSchedulerImpl struct {
+	// mu guards the consents, scheduled by the contract event task and checked by the consent status task
+	mu       sync.Mutex
+	consents map[string]*bank.PaymentAuthResponse
+	l        *zap.SugaredLogger
+}
+
+func NewConsentScheduler(_l *zap.SugaredLogger) ConsentStatusScheduler {
+	return &ConsentSchedulerImpl{
+		consents: make(map[string]*bank.PaymentAuthResponse),
+		l:        _l}
+}
+
+// ScheduleConsent adds an open consent to the scheduler
+func (t *ConsentSchedulerImpl) ScheduleConsent(consent *bank.PaymentAuthResponse) bool {
+
+	t.l.Infow("Scheduling consent",
+		"consent", consent.ConsentId)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.consents[consent.RequestId] != nil {
+		t.l.Infow("Consent already scheduled",
+			"consent", consent.ConsentId)
+		return false
+	} else {
+		t.consents[consent.RequestId] = consent
+		return true
+	}
+}
+
+// GetScheduledConsents returns a list of all scheduled consents
+func (t *ConsentSchedulerImpl) GetScheduledConsents() []*bank.PaymentAuthResponse {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var consents []*bank.PaymentAuthResponse
+	for _, consent := range t.consents {
+		consents = append(consents, consent)
+	}
+	return consents
+}
+
+// UnscheduleConsent removes a consent from the scheduler.
+// Returns true if the consent was found and removed, false otherwise
+func (t *ConsentSchedulerImpl) UnscheduleConsent(consent *bank.PaymentAuthResponse) bool {
+
+	t.l.Infow("Unscheduling consent",
+		"consent", consent.ConsentId)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.consents[consent.RequestId] != nil {
+		delete(t.consents, consent.RequestId)
+		return true
+	} else {
+		t.l.Infow("Consent not scheduled",
+			"consent", consent.ConsentId)
+		return false
+	}
+}
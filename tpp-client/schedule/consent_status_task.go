@@ -0,0 +1,90 @@
+package schedule
+
+import (
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/event"
+	"go.uber.org/zap"
+)
+
+type ConsentStatusTask interface {
+	CheckConsentStatuses()
+}
+
+type ConsentStatusTaskImpl struct {
+	scheduler  *ConsentStatusScheduler
+	bankClient *bank.OpenBankingClient
+	handler    *event.EventHandler
+	l          *zap.SugaredLogger
+}
+
+func NewConsentStatusTask(
+	_scheduler ConsentStatusScheduler,
+	_bankClient bank.OpenBankingClient,
+	_handler event.EventHandler,
+	_l *zap.SugaredLogger) ConsentStatusTask {
+
+	return &ConsentStatusTaskImpl{
+		scheduler:  &_scheduler,
+		bankClient: &_bankClient,
+		handler:    &_handler,
+		l:          _l,
+	}
+}
+
+// CheckConsentStatuses polls the bank for the status of the consents still waiting for the payer
+func (t *ConsentStatusTaskImpl) CheckConsentStatuses() {
+
+	for _, consent := range (*t.scheduler).GetScheduledConsents() {
+
+		t.l.Infow("Checking consent status",
+			"requestId", consent.RequestId,
+			"consent", consent.ConsentId)
+
+		// consent checks use a client credentials token, cached by the client
+		token, err := (*t.bankClient).GetPaymentAuthAccessToken(consent.RequestId)
+		var status *bank.PaymentConsentStatusResponse
+		if err == nil {
+			status, err = (*t.bankClient).GetPaymentConsentStatus(consent, token)
+		}
+
+		if err != nil {
+			class, _ := bank.ClassOf(err)
+			switch class {
+			case bank.TERMINAL:
+				// e.g. unknown consent. Checking again will not help.
+				t.l.Errorw("Error getting consent status. Giving up: "+err.Error(),
+					"requestId", consent.RequestId,
+					"consent", consent.ConsentId)
+				(*t.scheduler).UnscheduleConsent(consent)
+			case bank.RATE_LIMITED:
+				// the ASPSP is throttling us. Leave the rest for the next cycle.
+				t.l.Warnw("Rate limited while getting consent status: "+err.Error(),
+					"requestId", consent.RequestId,
+					"consent", consent.ConsentId)
+				return
+			case bank.UNAVAILABLE:
+				// the ASPSP is down. Leave the rest for the next cycle.
+				t.l.Warnw("Bank unavailable while getting consent status: "+err.Error(),
+					"requestId", consent.RequestId,
+					"consent", consent.ConsentId)
+				return
+			default:
+				// retryable or unknown, check again in next cycle
+				t.l.Warnw("Error getting consent status: "+err.Error(),
+					"requestId", consent.RequestId,
+					"consent", consent.ConsentId,
+					"class", class)
+			}
+		} else {
+			ok, err := (*t.handler).ProcessConsentStatusResponse(status)
+			if err != nil {
+				t.l.Errorw("Error processing consent status response: "+err.Error(),
+					"requestId", consent.RequestId,
+					"consent", consent.ConsentId)
+			}
+			if ok {
+				(*t.scheduler).UnscheduleConsent(consent)
+			}
+		}
+	}
+}
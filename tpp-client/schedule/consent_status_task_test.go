@@ -0,0 +1,84 @@
+package schedule_test
+
+import (
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/event"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/schedule"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"testing"
+)
+
+// fakeConsentBankClient returns a fixed consent check result. Calling any other method panics.
+type fakeConsentBankClient struct {
+	bank.OpenBankingClient
+	status string
+	err    error
+	calls  int
+}
+
+func (c *fakeConsentBankClient) GetPaymentAuthAccessToken(requestId string) (*bank.AccessToken, error) {
+	return &bank.AccessToken{Token: "tok"}, nil
+}
+
+func (c *fakeConsentBankClient) GetPaymentConsentStatus(consent *bank.PaymentAuthResponse, access *bank.AccessToken) (*bank.PaymentConsentStatusResponse, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &bank.PaymentConsentStatusResponse{RequestId: consent.RequestId, ConsentId: consent.ConsentId, Status: c.status}, nil
+}
+
+// fakeConsentHandler finalises rejected consents only
+type fakeConsentHandler struct {
+	event.EventHandler
+	processed []string
+}
+
+func (h *fakeConsentHandler) ProcessConsentStatusResponse(request *bank.PaymentConsentStatusResponse) (bool, error) {
+	h.processed = append(h.processed, request.Status)
+	return request.Status == bank.CONSENT_REJECTED, nil
+}
+
+func scheduleConsents(sch schedule.ConsentStatusScheduler, ids ...string) {
+	for _, id := range ids {
+		sch.ScheduleConsent(&bank.PaymentAuthResponse{RequestId: id, ConsentId: "c-" + id})
+	}
+}
+
+func TestCheckConsentStatuses(t *testing.T) {
+	cases := []struct {
+		name         string
+		status       string
+		err          error
+		expCalls     int
+		expProcessed int
+		expScheduled int
+	}{
+		{name: "awaiting", status: bank.CONSENT_AWAITING_AUTHORISATION, expCalls: 2, expProcessed: 2, expScheduled: 2},
+		{name: "rejected", status: bank.CONSENT_REJECTED, expCalls: 2, expProcessed: 2, expScheduled: 0},
+		{name: "terminal", err: bank.NewBankError("check consent", 404, nil, nil), expCalls: 2, expScheduled: 0},
+		{name: "retryable", err: bank.NewBankError("check consent", 503, nil, nil), expCalls: 2, expScheduled: 2},
+		{name: "rate limited", err: bank.NewBankError("check consent", 429, nil, nil), expCalls: 1, expScheduled: 2},
+		{name: "unavailable", err: bank.NewUnavailableError("check consent", "bank"), expCalls: 1, expScheduled: 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			l := zap.NewNop().Sugar()
+			sch := schedule.NewConsentScheduler(l)
+			scheduleConsents(sch, "1", "2")
+			client := &fakeConsentBankClient{status: c.status, err: c.err}
+			handler := &fakeConsentHandler{}
+			task := schedule.NewConsentStatusTask(sch, client, handler, l)
+
+			// act
+			task.CheckConsentStatuses()
+
+			// assert
+			assert.Equal(t, c.expCalls, client.calls)
+			assert.Len(t, handler.processed, c.expProcessed)
+			assert.Len(t, sch.GetScheduledConsents(), c.expScheduled)
+		})
+	}
+}
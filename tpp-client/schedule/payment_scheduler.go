@@ -3,6 +3,7 @@ package schedule
 import (
 	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
 	"go.uber.org/zap"
+	"sync"
 )
 
 type PaymentStatusScheduler interface {
@@ -12,6 +13,8 @@ type PaymentStatusScheduler interface {
 }
 
 type PaymentSchedulerImpl struct {
+	// mu guards the payments, scheduled by the event handlers and checked by the payment status task
+	mu       sync.Mutex
 	payments map[string]*bank.SubmitPaymentResponse
 	l        *zap.SugaredLogger
 }
@@ -28,6 +31,8 @@ func (t *PaymentSchedulerImpl) SchedulePayment(payment *bank.SubmitPaymentRespon
 	t.l.Infow("Scheduling payment",
 		"payment", payment.PaymentId)
 
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.payments[payment.RequestId] != nil {
 		t.l.Infow("Payment already scheduled",
 			"payment", payment.PaymentId)
@@ -40,6 +45,8 @@ func (t *PaymentSchedulerImpl) SchedulePayment(payment *bank.SubmitPaymentRespon
 
 // GetScheduledPayments returns a list of all scheduled payments
 func (t *PaymentSchedulerImpl) GetScheduledPayments() []*bank.SubmitPaymentResponse {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	var payments []*bank.SubmitPaymentResponse
 	for _, payment := range t.payments {
 		payments = append(payments, payment)
@@ -54,6 +61,8 @@ func (t *PaymentSchedulerImpl) UnschedulePayment(payment *bank.SubmitPaymentResp
 	t.l.Infow("Unscheduling payment",
 		"payment", payment.PaymentId)
 
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.payments[payment.RequestId] != nil {
 		delete(t.payments, payment.RequestId)
 		return true
@@ -13,14 +13,14 @@ type PaymentStatusTask interface {
 type PaymentStatusTaskImpl struct {
 	scheduler  *PaymentStatusScheduler
 	bankClient *bank.OpenBankingClient
-	handler    *event.EventHandler
+	handler    *event.PaymentStatusHandler
 	l          *zap.SugaredLogger
 }
 
 func NewPaymentStatusTask(
 	_scheduler PaymentStatusScheduler,
 	_bankClient bank.OpenBankingClient,
-	_handler event.EventHandler,
+	_handler event.PaymentStatusHandler,
 	_l *zap.SugaredLogger) PaymentStatusTask {
 
 	return &PaymentStatusTaskImpl{
@@ -46,23 +46,51 @@ func (t *PaymentStatusTaskImpl) CheckPaymentStatuses() {
 
 		status, err := (*t.bankClient).GetPaymentStatus(payment)
 
-		// TODO: errors below should be introspected to decide what to do
-		// Now we just log and move on (i.e. check again in next cycle)
 		if err != nil {
-			t.l.Errorw("Error getting payment status: "+err.Error(),
-				"requestId", payment.RequestId,
-				"payment", payment.PaymentId)
+			class, _ := bank.ClassOf(err)
+			switch class {
+			case bank.TERMINAL:
+				// e.g. unknown payment. Checking again will not help.
+				t.l.Errorw("Error getting payment status. Giving up: "+err.Error(),
+					"requestId", payment.RequestId,
+					"payment", payment.PaymentId)
+				t.unschedule(payment)
+			case bank.RATE_LIMITED:
+				// the ASPSP is throttling us. Leave the rest for the next cycle.
+				t.l.Warnw("Rate limited while getting payment status: "+err.Error(),
+					"requestId", payment.RequestId,
+					"payment", payment.PaymentId)
+				return
+			case bank.UNAVAILABLE:
+				// the ASPSP is down. Leave the rest for the next cycle.
+				t.l.Warnw("Bank unavailable while getting payment status: "+err.Error(),
+					"requestId", payment.RequestId,
+					"payment", payment.PaymentId)
+				return
+			default:
+				// retryable or unknown, check again in next cycle
+				t.l.Warnw("Error getting payment status: "+err.Error(),
+					"requestId", payment.RequestId,
+					"payment", payment.PaymentId,
+					"class", class)
+			}
 		} else {
 			ok, err := (*t.handler).ProcessPaymentStatusResponse(status)
-			// TODO: error below should be introspected to decide what to do (reschedule or remove?)
+			// errors here come from the contract call, so we keep the payment for the next cycle
 			if err != nil {
 				t.l.Errorw("Error processing payment status response: "+err.Error(),
 					"requestId", payment.RequestId,
 					"payment", payment.PaymentId)
 			}
 			if ok {
-				(*t.scheduler).UnschedulePayment(payment)
+				t.unschedule(payment)
 			}
 		}
 	}
 }
+
+// unschedule stops checking the payment and lets the client release its tokens
+func (t *PaymentStatusTaskImpl) unschedule(payment *bank.SubmitPaymentResponse) {
+	(*t.scheduler).UnschedulePayment(payment)
+	(*t.bankClient).ForgetPayment(payment.RequestId)
+}
@@ -0,0 +1,99 @@
+package schedule_test
+
+import (
+	"github.com/sgerogia/sol-stablecoin/tpp-client/bank"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/event"
+	"github.com/sgerogia/sol-stablecoin/tpp-client/schedule"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"testing"
+)
+
+// fakeBankClient returns a fixed status check result. Calling any other method panics.
+type fakeBankClient struct {
+	bank.OpenBankingClient
+	status    *bank.PaymentStatusResponse
+	err       error
+	calls     int
+	forgotten []string
+}
+
+func (c *fakeBankClient) GetPaymentStatus(data *bank.SubmitPaymentResponse) (*bank.PaymentStatusResponse, error) {
+	c.calls++
+	return c.status, c.err
+}
+
+func (c *fakeBankClient) ForgetPayment(requestId string) {
+	c.forgotten = append(c.forgotten, requestId)
+}
+
+// fakeHandler completes every status response it receives
+type fakeHandler struct {
+	event.EventHandler
+	processed int
+}
+
+func (h *fakeHandler) ProcessPaymentStatusResponse(request *bank.PaymentStatusResponse) (bool, error) {
+	h.processed++
+	return true, nil
+}
+
+func schedulePayments(sch schedule.PaymentStatusScheduler, ids ...string) {
+	for _, id := range ids {
+		sch.SchedulePayment(&bank.SubmitPaymentResponse{RequestId: id, PaymentId: "p-" + id})
+	}
+}
+
+func TestCheckPaymentStatuses_ErrorClasses(t *testing.T) {
+	cases := []struct {
+		name         string
+		err          error
+		expCalls     int
+		expScheduled int
+	}{
+		{name: "terminal", err: bank.NewBankError("check payment", 404, nil, nil), expCalls: 2, expScheduled: 0},
+		{name: "retryable", err: bank.NewBankError("check payment", 503, nil, nil), expCalls: 2, expScheduled: 2},
+		{name: "auth expired", err: bank.NewBankError("check payment", 401, nil, nil), expCalls: 2, expScheduled: 2},
+		{name: "rate limited", err: bank.NewBankError("check payment", 429, nil, nil), expCalls: 1, expScheduled: 2},
+		{name: "unavailable", err: bank.NewUnavailableError("check payment", "bank"), expCalls: 1, expScheduled: 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// arrange
+			l := zap.NewNop().Sugar()
+			sch := schedule.NewPaymentScheduler(l)
+			schedulePayments(sch, "1", "2")
+			client := &fakeBankClient{err: c.err}
+			handler := &fakeHandler{}
+			task := schedule.NewPaymentStatusTask(sch, client, handler, l)
+
+			// act
+			task.CheckPaymentStatuses()
+
+			// assert
+			assert.Equal(t, c.expCalls, client.calls)
+			assert.Len(t, sch.GetScheduledPayments(), c.expScheduled)
+			// the tokens go with the payment
+			assert.Len(t, client.forgotten, 2-c.expScheduled)
+			assert.Equal(t, 0, handler.processed)
+		})
+	}
+}
+
+func TestCheckPaymentStatuses_Completed(t *testing.T) {
+	// arrange
+	l := zap.NewNop().Sugar()
+	sch := schedule.NewPaymentScheduler(l)
+	schedulePayments(sch, "1")
+	client := &fakeBankClient{status: &bank.PaymentStatusResponse{RequestId: "1", Status: bank.PAYMENT_ACCEPTED_SETTLEMENT_COMPLETED}}
+	handler := &fakeHandler{}
+	task := schedule.NewPaymentStatusTask(sch, client, handler, l)
+
+	// act
+	task.CheckPaymentStatuses()
+
+	// assert
+	assert.Equal(t, 1, handler.processed)
+	assert.Empty(t, sch.GetScheduledPayments())
+	assert.Equal(t, []string{"1"}, client.forgotten)
+}
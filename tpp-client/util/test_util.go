@@ -2,6 +2,11 @@ package test_util
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
@@ -13,6 +18,7 @@ import (
 	"github.com/sgerogia/sol-stablecoin/tpp-client/event"
 	"math/big"
 	"os"
+	"time"
 )
 
 const INSTITUTION = "natwest-sandbox"
@@ -47,6 +53,38 @@ func MintRequestPayload(pubKey []byte) *event.MintRequestPayload {
 	}
 }
 
+// NewSelfSignedCert generates an RSA key and a self-signed certificate for it, for signing or MTLS in tests
+func NewSelfSignedCert(commonName string) (*tls.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
 type NatwestSandboxInfo struct {
 	ClientId         string
 	ClientSecret     string
@@ -71,6 +109,16 @@ func GetNatwestSandboxInfo() *NatwestSandboxInfo {
 	}
 }
 
+// MockAspspInfo returns the connection information to use with a local mock ASPSP
+func MockAspspInfo() *NatwestSandboxInfo {
+	return &NatwestSandboxInfo{
+		ClientId:         "mock-client-id",
+		ClientSecret:     "mock-client-secret",
+		RedirectUrl:      "http://localhost:8080/callback",
+		CustomerUsername: "customer@mock.aspsp",
+	}
+}
+
 func isEnvVarSet(e string) bool {
 	return os.Getenv(e) != ""
 }